@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/audit"
+	"github.com/CefBoud/kafka-mcp-server/pkg/auth"
+	"github.com/CefBoud/kafka-mcp-server/pkg/kafka"
+	"github.com/CefBoud/kafka-mcp-server/pkg/llm"
+	"github.com/CefBoud/kafka-mcp-server/pkg/redact"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
+	"github.com/IBM/sarama"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configSections are the config file keys that have no matching flag.
+var configSections = []string{"clusters", "profiles", "tools", "topics", "consumer-groups", "redaction", "recipes"}
+
+func initConfig() {
+	// Initialize Viper configuration
+	viper.SetEnvPrefix("KAFKA_MCP")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+	viper.AutomaticEnv()
+}
+
+// newConfig builds the server configuration from flags, environment variables and the config file.
+// All configuration problems are reported at once.
+func newConfig() (Config, error) {
+	var errs []error
+	if path := viper.GetString("config"); path != "" {
+		errs = append(errs, loadConfigFile(path, viper.GetString("profile")))
+	}
+
+	kafkaConfig, err := newKafkaConfig()
+	errs = append(errs, err)
+	multiplexLLM, err := newMultiplexLLM()
+	errs = append(errs, err)
+	errs = append(errs, validateOptions()...)
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	logger, err := initLogger(viper.GetString("log-file"))
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	if path := viper.GetString("audit-log-file"); path != "" {
+		kafkaConfig.Audit, err = audit.NewLogger(path, viper.GetInt("audit-log-max-size"), viper.GetInt("audit-log-max-backups"))
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	return Config{
+		readOnly:     viper.GetBool("read-only"),
+		logger:       logger,
+		logCommands:  viper.GetBool("enable-command-logging"),
+		KafkaConfig:  kafkaConfig,
+		Multiplex:    viper.GetBool("enable-multiplex"),
+		MultiplexLLM: multiplexLLM,
+		metricsAddr:  viper.GetString("metrics-addr"),
+		tracing: tracing.Config{
+			Exporter: viper.GetString("tracing-exporter"),
+			Endpoint: viper.GetString("tracing-endpoint"),
+			Insecure: viper.GetBool("tracing-insecure"),
+			File:     viper.GetString("tracing-file"),
+			Version:  version,
+		},
+	}, nil
+}
+
+// loadConfigFile reads a YAML, TOML or JSON config file into viper after replacing `${VAR}` and
+// `${VAR:-default}` references with environment variables. When profile is set, the keys of
+// `profiles.<profile>` override the top-level ones.
+func loadConfigFile(path, profile string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	data, errs := interpolateEnv(data)
+
+	viper.SetConfigType(strings.TrimPrefix(filepath.Ext(path), "."))
+	if err := viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for _, key := range unknownKeys(viper.AllSettings()) {
+		errs = append(errs, fmt.Errorf("unknown option %q", key))
+	}
+	if profile != "" {
+		overrides, ok := viper.Get("profiles." + profile).(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("profile %q is not defined in %s", profile, path))
+		} else {
+			for _, key := range unknownKeys(overrides) {
+				errs = append(errs, fmt.Errorf("profile %s: unknown option %q", profile, key))
+			}
+			if err := viper.MergeConfigMap(overrides); err != nil {
+				errs = append(errs, fmt.Errorf("profile %s: %w", profile, err))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateEnv replaces environment variable references, reporting the ones that are not set and have no default.
+func interpolateEnv(data []byte) ([]byte, []error) {
+	var errs []error
+	data = envReference.ReplaceAllFunc(data, func(ref []byte) []byte {
+		m := envReference.FindSubmatch(ref)
+		if value, ok := os.LookupEnv(string(m[1])); ok {
+			return []byte(value)
+		}
+		if len(m[2]) > 0 {
+			return m[3]
+		}
+		errs = append(errs, fmt.Errorf("environment variable %s is not set", m[1]))
+		return nil
+	})
+	return data, errs
+}
+
+// unknownKeys returns the top-level keys of settings that match neither a flag nor a config section.
+func unknownKeys(settings map[string]any) []string {
+	known := map[string]bool{}
+	for _, s := range configSections {
+		known[s] = true
+	}
+	var visit func(cmd *cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		cmd.Flags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+		cmd.PersistentFlags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+		for _, c := range cmd.Commands() {
+			// the export command's flags describe a single export, not the server
+			if c.Name() != "export" {
+				visit(c)
+			}
+		}
+	}
+	visit(rootCmd)
+
+	var unknown []string
+	for key := range settings {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// validateOptions checks the options that are not validated while building the Kafka configuration.
+func validateOptions() []error {
+	var errs []error
+	if _, err := auth.ParseRole(viper.GetString("auth-default-role")); err != nil {
+		errs = append(errs, fmt.Errorf("auth-default-role: %w", err))
+	}
+	if (viper.GetString("tls-cert-file") == "") != (viper.GetString("tls-key-file") == "") {
+		errs = append(errs, fmt.Errorf("tls-cert-file and tls-key-file must be set together"))
+	}
+	if viper.GetString("tls-client-ca-file") != "" && viper.GetString("tls-cert-file") == "" {
+		errs = append(errs, fmt.Errorf("tls-client-ca-file requires tls-cert-file and tls-key-file"))
+	}
+	for _, key := range []string{"auth-tokens-file", "auth-jwks-file", "auth-roles-file", "tls-cert-file", "tls-key-file", "tls-client-ca-file"} {
+		if path := viper.GetString(key); path != "" {
+			if _, err := os.Stat(path); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+	}
+	switch exporter := viper.GetString("tracing-exporter"); exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	case tracing.ExporterFile:
+		if viper.GetString("tracing-file") == "" {
+			errs = append(errs, fmt.Errorf("tracing-exporter file requires tracing-file"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing-exporter: unknown exporter %q (expected otlp, stdout or file)", exporter))
+	}
+	return errs
+}
+
+// newMultiplexLLM creates the provider selected by multiplex-model, or nil when neither
+// multiplexing nor recipes are enabled or PROMPT_ARGUMENTs are only inferred through sampling.
+func newMultiplexLLM() (llm.Provider, error) {
+	if !viper.GetBool("enable-multiplex") && !viper.IsSet("recipes") {
+		return nil, nil
+	}
+	provider, model := llm.ParseModel(viper.GetString("multiplex-model"))
+	p, err := llm.New(llm.Config{
+		Provider:    provider,
+		Model:       model,
+		Endpoint:    viper.GetString("multiplex-endpoint"),
+		APIKey:      viper.GetString("multiplex-api-key"),
+		Temperature: viper.GetFloat64("multiplex-temperature"),
+		Timeout:     viper.GetDuration("multiplex-timeout"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("multiplex-model: %w", err)
+	}
+	return p, nil
+}
+
+// newKafkaConfig collects the clusters defined in the config file plus, when set, a cluster named
+// "default" built from bootstrap-servers.
+func newKafkaConfig() (*kafka.Config, error) {
+	var clusters []*kafka.ClusterConfig
+	if err := viper.UnmarshalKey("clusters", &clusters); err != nil {
+		return nil, fmt.Errorf("invalid clusters configuration: %w", err)
+	}
+
+	// either via command line of KAFKA_MCP_BOOTSTRAP_SERVERS env var
+	if bootstrapServers := viper.GetString("bootstrap-servers"); bootstrapServers != "" {
+		clusters = append(clusters, &kafka.ClusterConfig{
+			Name:             "default",
+			BootstrapServers: strings.Split(bootstrapServers, ","),
+		})
+	}
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("bootstrap-servers, KAFKA_MCP_BOOTSTRAP_SERVERS env or clusters in the config file must be set")
+	}
+
+	var errs []error
+	cfg := &kafka.Config{
+		Clusters:       make(map[string]*kafka.ClusterConfig, len(clusters)),
+		DefaultCluster: viper.GetString("default-cluster"),
+		Timeouts: kafka.TimeoutConfig{
+			Call:     viper.GetDuration("tool-timeout"),
+			MaxCall:  viper.GetDuration("max-tool-timeout"),
+			Dial:     viper.GetDuration("dial-timeout"),
+			Metadata: viper.GetDuration("metadata-timeout"),
+			Read:     viper.GetDuration("read-timeout"),
+		},
+	}
+	cfg.Subscriptions = kafka.SubscriptionConfig{
+		PollInterval:  viper.GetDuration("subscription-poll-interval"),
+		LagThreshold:  viper.GetInt64("lag-threshold"),
+		MaxPerSession: viper.GetInt("max-subscriptions"),
+	}
+	cfg.Multiplex = kafka.MultiplexConfig{
+		MaxConcurrency: viper.GetInt("multiplex-concurrency"),
+		MaxSteps:       viper.GetInt("multiplex-max-steps"),
+	}
+	cfg.Search = kafka.SearchConfig{
+		MaxMessages: viper.GetInt64("search-max-messages"),
+		MaxBytes:    viper.GetInt64("search-max-bytes"),
+	}
+	cfg.Export = kafka.ExportConfig{
+		Dir:         viper.GetString("export-dir"),
+		MaxMessages: viper.GetInt64("export-max-messages"),
+	}
+	if cfg.Export.Dir == "" {
+		cfg.Export.Dir = filepath.Join(os.TempDir(), "kafka-mcp-exports")
+	}
+	if cfg.Search.MaxMessages < 1 {
+		errs = append(errs, fmt.Errorf("search-max-messages must be at least 1"))
+	}
+	if cfg.Search.MaxBytes < 1 {
+		errs = append(errs, fmt.Errorf("search-max-bytes must be at least 1"))
+	}
+	if cfg.Export.MaxMessages < 1 {
+		errs = append(errs, fmt.Errorf("export-max-messages must be at least 1"))
+	}
+	if cfg.Multiplex.MaxConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("multiplex-concurrency must be at least 1"))
+	}
+	if cfg.Multiplex.MaxSteps < 1 {
+		errs = append(errs, fmt.Errorf("multiplex-max-steps must be at least 1"))
+	}
+	if cfg.Subscriptions.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("subscription-poll-interval must be positive"))
+	}
+	if cfg.Subscriptions.MaxPerSession < 1 {
+		errs = append(errs, fmt.Errorf("max-subscriptions must be at least 1"))
+	}
+	if cfg.Timeouts.MaxCall > 0 && cfg.Timeouts.Call > cfg.Timeouts.MaxCall {
+		errs = append(errs, fmt.Errorf("tool-timeout %s exceeds max-tool-timeout %s", cfg.Timeouts.Call, cfg.Timeouts.MaxCall))
+	}
+	for i, c := range clusters {
+		if c.Name == "" {
+			errs = append(errs, fmt.Errorf("cluster #%d has no name", i))
+			continue
+		}
+		if len(c.BootstrapServers) == 0 {
+			errs = append(errs, fmt.Errorf("cluster %s has no bootstrap-servers", c.Name))
+		}
+		if _, ok := cfg.Clusters[c.Name]; ok {
+			errs = append(errs, fmt.Errorf("cluster %s is defined twice", c.Name))
+		}
+		switch c.Security.SASL.Mechanism {
+		case "", sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		default:
+			errs = append(errs, fmt.Errorf("cluster %s: unsupported SASL mechanism %q", c.Name, c.Security.SASL.Mechanism))
+		}
+		if (c.Security.TLS.CertFile == "") != (c.Security.TLS.KeyFile == "") {
+			errs = append(errs, fmt.Errorf("cluster %s: tls cert-file and key-file must be set together", c.Name))
+		}
+		cfg.Clusters[c.Name] = c
+	}
+	if cfg.DefaultCluster == "" {
+		cfg.DefaultCluster = clusters[0].Name
+	}
+	if _, ok := cfg.Clusters[cfg.DefaultCluster]; !ok {
+		errs = append(errs, fmt.Errorf("default-cluster %s is not defined", cfg.DefaultCluster))
+	}
+
+	for key, target := range map[string]any{
+		"tools":           &cfg.Tools,
+		"topics":          &cfg.Topics,
+		"consumer-groups": &cfg.ConsumerGroups,
+	} {
+		if err := viper.UnmarshalKey(key, target); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s configuration: %w", key, err))
+		}
+	}
+	for key, filter := range map[string]kafka.NameFilter{"topics": cfg.Topics, "consumer-groups": cfg.ConsumerGroups} {
+		if err := filter.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	if err := viper.UnmarshalKey("recipes", &cfg.Recipes); err != nil {
+		errs = append(errs, fmt.Errorf("invalid recipes configuration: %w", err))
+	}
+	recipes := map[string]bool{}
+	for _, r := range cfg.Recipes {
+		if recipes[r.Name] {
+			errs = append(errs, fmt.Errorf("recipe %s is defined twice", r.Name))
+		}
+		recipes[r.Name] = true
+		errs = append(errs, r.Validate())
+	}
+
+	var rules []redact.Rule
+	if err := viper.UnmarshalKey("redaction", &rules); err != nil {
+		errs = append(errs, fmt.Errorf("invalid redaction configuration: %w", err))
+	} else if cfg.Redactor, err = redact.New(rules); err != nil {
+		errs = append(errs, err)
+	}
+	return cfg, errors.Join(errs...)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestInterpolateEnv(t *testing.T) {
+	t.Setenv("KMS_TEST_HOST", "broker:9092")
+	t.Setenv("KMS_TEST_EMPTY", "")
+
+	got, errs := interpolateEnv([]byte("servers: ${KMS_TEST_HOST}\nuser: ${KMS_TEST_UNSET:-admin}\nempty: '${KMS_TEST_EMPTY:-x}'\nliteral: $KMS_TEST_HOST"))
+	if len(errs) != 0 {
+		t.Fatalf("interpolateEnv() errors = %v", errs)
+	}
+	if want := "servers: broker:9092\nuser: admin\nempty: ''\nliteral: $KMS_TEST_HOST"; string(got) != want {
+		t.Errorf("interpolateEnv() = %q, want %q", got, want)
+	}
+
+	_, errs = interpolateEnv([]byte("a: ${KMS_TEST_UNSET}\nb: ${KMS_TEST_UNSET_TOO}"))
+	if len(errs) != 2 {
+		t.Errorf("interpolateEnv() of unset variables errors = %v, want 2 errors", errs)
+	}
+}
+
+// writeConfig writes a config file and resets viper when the test ends.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(viper.Reset)
+	return path
+}
+
+func TestLoadConfigFileProfile(t *testing.T) {
+	t.Setenv("KMS_TEST_TIMEOUT", "45s")
+	path := writeConfig(t, "config.yaml", `
+bootstrap-servers: localhost:9092
+read-only: true
+tool-timeout: 10s
+profiles:
+  prod:
+    bootstrap-servers: prod-1:9092,prod-2:9092
+    tool-timeout: ${KMS_TEST_TIMEOUT}
+`)
+	if err := loadConfigFile(path, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if got := viper.GetString("bootstrap-servers"); got != "prod-1:9092,prod-2:9092" {
+		t.Errorf("bootstrap-servers = %q, want the profile's", got)
+	}
+	if got := viper.GetDuration("tool-timeout").String(); got != "45s" {
+		t.Errorf("tool-timeout = %s, want 45s from the environment", got)
+	}
+	if !viper.GetBool("read-only") {
+		t.Error("read-only = false, want the top-level value kept")
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name, file, content, profile, want string
+	}{
+		{"unknown option", "config.yaml", "bootstrap-server: localhost:9092\n", "", `unknown option "bootstrap-server"`},
+		{"unknown profile option", "config.yaml", "profiles:\n  dev:\n    read-onyl: true\n", "dev", `profile dev: unknown option "read-onyl"`},
+		{"undefined profile", "config.yaml", "read-only: true\n", "prod", `profile "prod" is not defined`},
+		{"unset variable", "config.yaml", "bootstrap-servers: ${KMS_TEST_UNSET}\n", "", "environment variable KMS_TEST_UNSET is not set"},
+		{"invalid syntax", "config.json", "{", "", "failed to parse config file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadConfigFile(writeConfig(t, tt.file, tt.content), tt.profile)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfigFile() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// runExport exports the messages of topic to path with the options of the export command's flags,
+// which are passed as the arguments of the exportMessages tool.
+func runExport(cmd *cobra.Command, cfg Config, topic, path string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	flags := cmd.Flags()
+	args := map[string]any{"name": topic}
+	args["format"], _ = flags.GetString("format")
+	args["decode"], _ = flags.GetBool("decode")
+	args["startTime"], _ = flags.GetString("start-time")
+	args["endTime"], _ = flags.GetString("end-time")
+	if partitions, _ := flags.GetInt32Slice("partitions"); len(partitions) > 0 {
+		selected := make([]any, len(partitions))
+		for i, p := range partitions {
+			selected[i] = float64(p)
+		}
+		args["partitions"] = selected
+	}
+	for flag, arg := range map[string]string{"start-offset": "startOffset", "end-offset": "endOffset", "max-messages": "maxMessages"} {
+		if flags.Changed(flag) {
+			n, _ := flags.GetInt64(flag)
+			args[arg] = float64(n)
+		}
+	}
+	for flag, arg := range map[string]string{"redact-field": "redactFields", "redact-pattern": "redactPatterns"} {
+		values, _ := flags.GetStringSlice(flag)
+		list := make([]any, len(values))
+		for i, v := range values {
+			list[i] = v
+		}
+		args[arg] = list
+	}
+	cluster, _ := flags.GetString("cluster")
+
+	result, err := cfg.KafkaConfig.ExportMessages(ctx, cluster, path, args)
+	if result == nil {
+		return err
+	}
+	out, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(out))
+	if err != nil {
+		return fmt.Errorf("export interrupted after %d messages: %w", result.Records, err)
+	}
+	return nil
+}
@@ -5,16 +5,22 @@ import (
 	"fmt"
 	"io"
 	stdlog "log"
+	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 
+	"github.com/CefBoud/kafka-mcp-server/pkg/auth"
 	"github.com/CefBoud/kafka-mcp-server/pkg/kafka"
+	"github.com/CefBoud/kafka-mcp-server/pkg/llm"
 	iolog "github.com/CefBoud/kafka-mcp-server/pkg/log"
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
 	"github.com/mark3labs/mcp-go/server"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -35,35 +41,43 @@ var (
 		Short: "Start stdio server",
 		Long:  `Start a server that communicates via standard input/output streams using JSON-RPC messages.`,
 		Run: func(_ *cobra.Command, _ []string) {
-			logFile := viper.GetString("log-file")
-			readOnly := viper.GetBool("read-only")
-			multiplex := viper.GetBool("enable-multiplex")
-			multiplexModel := viper.GetString("multiplex-model")
-
-			logger, err := initLogger(logFile)
+			cfg, err := newConfig()
 			if err != nil {
-				stdlog.Fatal("Failed to initialize logger:", err)
+				stdlog.Fatal(err)
+			}
+			if err := runStdioServer(cfg); err != nil {
+				stdlog.Fatal("failed to run stdio server:", err)
 			}
-			logCommands := viper.GetBool("enable-command-logging")
+		},
+	}
 
-			// either via command line of KAFKA_MCP_BOOTSTRAP_SERVERS env var
-			bootstrapServers := viper.GetString("bootstrap-servers")
-			if bootstrapServers == "" {
-				stdlog.Fatal("bootstrap-servers or KAFKA_MCP_BOOTSTRAP_SERVERS env not set")
+	sseCmd = &cobra.Command{
+		Use:   "sse",
+		Short: "Start HTTP/SSE server",
+		Long:  `Start a server that communicates over HTTP using server-sent events. Callers can be authenticated with static bearer tokens, JWTs or mTLS client certificates, and each identity is mapped to a role (read-only, operator or admin) that decides which tools it can see and call.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			cfg, err := newConfig()
+			if err != nil {
+				stdlog.Fatal(err)
 			}
+			if err := runSSEServer(cfg); err != nil {
+				stdlog.Fatal("failed to run sse server:", err)
+			}
+		},
+	}
 
-			cfg := Config{
-				readOnly:    readOnly,
-				logger:      logger,
-				logCommands: logCommands,
-				KafkaConfig: &kafka.Config{
-					BootstrapServers: strings.Split(bootstrapServers, ","),
-				},
-				Multiplex:      multiplex,
-				MultiplexModel: multiplexModel,
+	exportCmd = &cobra.Command{
+		Use:   "export <topic> <file>",
+		Short: "Export topic messages to a file",
+		Long:  `Write the messages of a topic, or of a range of its partitions, offsets or times, to a JSONL, CSV or Parquet file, like the exportMessages tool, and print the file's path, record count and size. The format defaults to the file's extension. Access lists and redaction rules apply as for the tools.`,
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := newConfig()
+			if err != nil {
+				stdlog.Fatal(err)
 			}
-			if err := runStdioServer(cfg); err != nil {
-				stdlog.Fatal("failed to run stdio server:", err)
+			if err := runExport(cmd, cfg, args[0], args[1]); err != nil {
+				stdlog.Fatal("failed to export messages: ", err)
 			}
 		},
 	}
@@ -90,38 +104,152 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	// Add global flags that will be shared by all commands
+	rootCmd.PersistentFlags().String("config", "", "Path to a config file (YAML, TOML or JSON). Every option can be set in it using the flag name as key, along with named clusters and profiles")
+	rootCmd.PersistentFlags().String("profile", "", "Name of the config file profile whose options override the top-level ones")
+	rootCmd.PersistentFlags().String("bootstrap-servers", "", "Comma-separated list of the Kafka servers to connect to.")
+	rootCmd.PersistentFlags().String("default-cluster", "", "Cluster used by tool calls without a cluster argument. Defaults to the first cluster of the config file.")
 	rootCmd.PersistentFlags().Bool("read-only", false, "Restrict the server to read-only operations")
 	rootCmd.PersistentFlags().String("log-file", "", "Path to log file")
 	rootCmd.PersistentFlags().Bool("enable-command-logging", false, "When enabled, the server will log all command requests and responses to the log file")
+	rootCmd.PersistentFlags().String("audit-log-file", "", "Path to a JSON-lines audit log with one record per tool call")
+	rootCmd.PersistentFlags().Int("audit-log-max-size", 100, "Size in megabytes after which the audit log is rotated (0 disables rotation)")
+	rootCmd.PersistentFlags().Int("audit-log-max-backups", 5, "Number of rotated audit log files to keep")
+	rootCmd.PersistentFlags().String("metrics-addr", "", "Address of the Prometheus metrics endpoint, e.g. :9090 (disabled when empty)")
+	rootCmd.PersistentFlags().String("tracing-exporter", "", "OpenTelemetry trace exporter: otlp, stdout (written to stderr) or file. Tracing is disabled when empty")
+	rootCmd.PersistentFlags().String("tracing-endpoint", "", "OTLP/HTTP collector endpoint, e.g. localhost:4318 (defaults to the OTEL_EXPORTER_OTLP_* env vars)")
+	rootCmd.PersistentFlags().Bool("tracing-insecure", false, "Use plain HTTP to reach the OTLP collector")
+	rootCmd.PersistentFlags().String("tracing-file", "", "File receiving the spans of the file exporter")
+	rootCmd.PersistentFlags().Duration("tool-timeout", 15*time.Second, "Default duration of a tool call; longer calls return partial results. Clients can override it with the timeoutMs argument")
+	rootCmd.PersistentFlags().Duration("max-tool-timeout", 2*time.Minute, "Upper bound of the timeoutMs argument of tool calls")
+	rootCmd.PersistentFlags().Duration("dial-timeout", 10*time.Second, "Timeout for connecting to a Kafka broker")
+	rootCmd.PersistentFlags().Duration("metadata-timeout", 10*time.Second, "Timeout for fetching cluster metadata, retries included")
+	rootCmd.PersistentFlags().Duration("read-timeout", 30*time.Second, "Timeout for reading a response from a Kafka broker")
+	rootCmd.PersistentFlags().Duration("subscription-poll-interval", 30*time.Second, "How often the resources clients subscribed to are polled for changes")
+	rootCmd.PersistentFlags().Int64("lag-threshold", 1000, "Total lag above which a subscribed consumer group is reported as updated")
+	rootCmd.PersistentFlags().Int("max-subscriptions", 50, "Maximum number of resources a client session can subscribe to")
+	rootCmd.PersistentFlags().Int64("search-max-messages", 100000, "Maximum number of messages a searchMessages call scans")
+	rootCmd.PersistentFlags().Int64("search-max-bytes", 100<<20, "Maximum number of key, value and header bytes a searchMessages call scans")
+	rootCmd.PersistentFlags().String("export-dir", "", "Directory the files of exportMessages and backupTopic calls are written to (defaults to kafka-mcp-exports in the temporary directory)")
+	rootCmd.PersistentFlags().Int64("export-max-messages", 1000000, "Maximum number of messages of an export")
 	rootCmd.PersistentFlags().Bool("enable-multiplex", false, "Enable multiplexing/batching multiple tool calls together.")
-	rootCmd.PersistentFlags().String("multiplex-model", "", "When multiplexing is enabled, this model is used to infer PROMPT_ARGUMENTs which are dynamic tool arguments derived from previous tool results and a prompt supplied by the MCP client. (Only gemini is supported for now. 'GEMINI_API_KEY' env var is expected.)")
+	rootCmd.PersistentFlags().String("multiplex-model", "", "When multiplexing is enabled, PROMPT_ARGUMENTs, which are dynamic tool arguments derived from previous tool results and a prompt supplied by the MCP client, are inferred by the client's model through MCP sampling. This model is used instead when the client does not support sampling or sampling fails, as provider[:model]: openai, ollama, anthropic or gemini, e.g. openai:gpt-4o-mini. The API key is read from OPENAI_API_KEY, ANTHROPIC_API_KEY or GEMINI_API_KEY")
+	rootCmd.PersistentFlags().String("multiplex-endpoint", "", "Base URL of the multiplex model's API, e.g. http://localhost:8080/v1 for a llama.cpp server (defaults to the provider's API)")
+	rootCmd.PersistentFlags().String("multiplex-api-key", "", "API key of the multiplex model (defaults to the provider's env var)")
+	rootCmd.PersistentFlags().Float64("multiplex-temperature", 0, "Sampling temperature of the multiplex model")
+	rootCmd.PersistentFlags().Duration("multiplex-timeout", 30*time.Second, "Timeout of a query to the multiplex model")
+	rootCmd.PersistentFlags().Int("multiplex-concurrency", 4, "Maximum number of calls of a multiplexed batch running at once")
+	rootCmd.PersistentFlags().Int("multiplex-max-steps", 100, "Maximum number of tool calls a multiplexed batch makes, forEach iterations included")
 
 	// Bind flag to viper
+	_ = viper.BindPFlag("config", rootCmd.PersistentFlags().Lookup("config"))
+	_ = viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
+	_ = viper.BindPFlag("default-cluster", rootCmd.PersistentFlags().Lookup("default-cluster"))
 	_ = viper.BindPFlag("read-only", rootCmd.PersistentFlags().Lookup("read-only"))
 	_ = viper.BindPFlag("log-file", rootCmd.PersistentFlags().Lookup("log-file"))
 	_ = viper.BindPFlag("enable-command-logging", rootCmd.PersistentFlags().Lookup("enable-command-logging"))
+	_ = viper.BindPFlag("audit-log-file", rootCmd.PersistentFlags().Lookup("audit-log-file"))
+	_ = viper.BindPFlag("audit-log-max-size", rootCmd.PersistentFlags().Lookup("audit-log-max-size"))
+	_ = viper.BindPFlag("audit-log-max-backups", rootCmd.PersistentFlags().Lookup("audit-log-max-backups"))
 	_ = viper.BindPFlag("bootstrap-servers", rootCmd.PersistentFlags().Lookup("bootstrap-servers"))
+	_ = viper.BindPFlag("metrics-addr", rootCmd.PersistentFlags().Lookup("metrics-addr"))
+	_ = viper.BindPFlag("tracing-exporter", rootCmd.PersistentFlags().Lookup("tracing-exporter"))
+	_ = viper.BindPFlag("tracing-endpoint", rootCmd.PersistentFlags().Lookup("tracing-endpoint"))
+	_ = viper.BindPFlag("tracing-insecure", rootCmd.PersistentFlags().Lookup("tracing-insecure"))
+	_ = viper.BindPFlag("tracing-file", rootCmd.PersistentFlags().Lookup("tracing-file"))
+	_ = viper.BindPFlag("tool-timeout", rootCmd.PersistentFlags().Lookup("tool-timeout"))
+	_ = viper.BindPFlag("max-tool-timeout", rootCmd.PersistentFlags().Lookup("max-tool-timeout"))
+	_ = viper.BindPFlag("dial-timeout", rootCmd.PersistentFlags().Lookup("dial-timeout"))
+	_ = viper.BindPFlag("metadata-timeout", rootCmd.PersistentFlags().Lookup("metadata-timeout"))
+	_ = viper.BindPFlag("read-timeout", rootCmd.PersistentFlags().Lookup("read-timeout"))
+	_ = viper.BindPFlag("subscription-poll-interval", rootCmd.PersistentFlags().Lookup("subscription-poll-interval"))
+	_ = viper.BindPFlag("lag-threshold", rootCmd.PersistentFlags().Lookup("lag-threshold"))
+	_ = viper.BindPFlag("max-subscriptions", rootCmd.PersistentFlags().Lookup("max-subscriptions"))
+	_ = viper.BindPFlag("search-max-messages", rootCmd.PersistentFlags().Lookup("search-max-messages"))
+	_ = viper.BindPFlag("search-max-bytes", rootCmd.PersistentFlags().Lookup("search-max-bytes"))
+	_ = viper.BindPFlag("export-dir", rootCmd.PersistentFlags().Lookup("export-dir"))
+	_ = viper.BindPFlag("export-max-messages", rootCmd.PersistentFlags().Lookup("export-max-messages"))
 	_ = viper.BindPFlag("enable-multiplex", rootCmd.PersistentFlags().Lookup("enable-multiplex"))
 	_ = viper.BindPFlag("multiplex-model", rootCmd.PersistentFlags().Lookup("multiplex-model"))
+	_ = viper.BindPFlag("multiplex-endpoint", rootCmd.PersistentFlags().Lookup("multiplex-endpoint"))
+	_ = viper.BindPFlag("multiplex-api-key", rootCmd.PersistentFlags().Lookup("multiplex-api-key"))
+	_ = viper.BindPFlag("multiplex-temperature", rootCmd.PersistentFlags().Lookup("multiplex-temperature"))
+	_ = viper.BindPFlag("multiplex-timeout", rootCmd.PersistentFlags().Lookup("multiplex-timeout"))
+	_ = viper.BindPFlag("multiplex-concurrency", rootCmd.PersistentFlags().Lookup("multiplex-concurrency"))
+	_ = viper.BindPFlag("multiplex-max-steps", rootCmd.PersistentFlags().Lookup("multiplex-max-steps"))
+
+	sseCmd.Flags().String("addr", ":8080", "Address the HTTP server listens on")
+	sseCmd.Flags().String("base-url", "", "Public base URL of the server, used to build the message endpoint advertised to clients (defaults to http(s)://<addr>)")
+	sseCmd.Flags().String("tls-cert-file", "", "Server TLS certificate. Enables HTTPS together with --tls-key-file")
+	sseCmd.Flags().String("tls-key-file", "", "Server TLS private key")
+	sseCmd.Flags().String("tls-client-ca-file", "", "CA bundle used to verify client certificates. Enables mTLS authentication")
+	sseCmd.Flags().String("auth-tokens-file", "", `JSON file of static bearer tokens: [{"token": "...", "subject": "...", "role": "read-only|operator|admin"}]`)
+	sseCmd.Flags().String("auth-jwks-file", "", "Local JWKS file used to validate bearer JWTs")
+	sseCmd.Flags().String("auth-jwt-issuer", "", "Expected iss claim of JWTs")
+	sseCmd.Flags().String("auth-jwt-audience", "", "Expected aud claim of JWTs")
+	sseCmd.Flags().String("auth-jwt-role-claim", "role", "JWT claim holding the caller's role")
+	sseCmd.Flags().String("auth-roles-file", "", `JSON file mapping JWT subjects and client certificate common names to roles: {"alice": "admin"}`)
+	sseCmd.Flags().String("auth-default-role", string(auth.RoleReadOnly), "Role of JWT and mTLS identities without an explicit role")
+	sseCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		_ = viper.BindPFlag(f.Name, f)
+	})
+
+	exportCmd.Flags().String("cluster", "", "Cluster to export from (defaults to the default cluster)")
+	exportCmd.Flags().String("format", "", "Format of the file: jsonl, csv or parquet (defaults to the file's extension, or jsonl)")
+	exportCmd.Flags().Int32Slice("partitions", nil, "Partitions to export (defaults to every partition)")
+	exportCmd.Flags().Int64("start-offset", 0, "Offset to start from in each partition (defaults to the oldest message)")
+	exportCmd.Flags().Int64("end-offset", 0, "Offset to stop before in each partition (defaults to the end of the partition)")
+	exportCmd.Flags().String("start-time", "", "Only export messages with a timestamp at or after this time, in RFC 3339 format or as a duration back from now such as 1h")
+	exportCmd.Flags().String("end-time", "", "Only export messages with a timestamp before this time")
+	exportCmd.Flags().Int64("max-messages", 0, "Maximum number of messages to export (defaults to and is capped at --export-max-messages)")
+	exportCmd.Flags().Bool("decode", false, "Write the keys and values holding JSON as JSON rather than as strings (jsonl only)")
+	exportCmd.Flags().StringSlice("redact-field", nil, "Dot-separated path of a JSON field to mask, on top of the redaction rules")
+	exportCmd.Flags().StringSlice("redact-pattern", nil, "Regular expression, or email, card-number or token, to mask, on top of the redaction rules")
 
 	// Add subcommands
 	rootCmd.AddCommand(stdioCmd)
+	rootCmd.AddCommand(sseCmd)
+	rootCmd.AddCommand(exportCmd)
 }
 
-func initConfig() {
-	// Initialize Viper configuration
-	viper.SetEnvPrefix("KAFKA_MCP")
-	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
-	viper.AutomaticEnv()
+type Config struct {
+	readOnly    bool
+	logger      *log.Logger
+	logCommands bool
+	KafkaConfig *kafka.Config
+	Multiplex   bool
+	// MultiplexLLM infers PROMPT_ARGUMENTs for clients without sampling. Nil when not configured.
+	MultiplexLLM llm.Provider
+	metricsAddr  string
+	tracing      tracing.Config
 }
 
-type Config struct {
-	readOnly       bool
-	logger         *log.Logger
-	logCommands    bool
-	KafkaConfig    *kafka.Config
-	Multiplex      bool
-	MultiplexModel string
+// setupTracing installs the configured trace exporter and returns the function flushing it on shutdown.
+func setupTracing(ctx context.Context, cfg Config) (func(), error) {
+	shutdown, err := tracing.Setup(ctx, cfg.tracing)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			cfg.logger.Errorf("failed to flush traces: %v", err)
+		}
+	}, nil
+}
+
+// startMetricsServer serves the Prometheus metrics on /metrics when an address is configured.
+func startMetricsServer(cfg Config) {
+	if cfg.metricsAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		if err := http.ListenAndServe(cfg.metricsAddr, mux); err != nil {
+			cfg.logger.Errorf("metrics server stopped: %v", err)
+		}
+	}()
 }
 
 func runStdioServer(cfg Config) error {
@@ -129,14 +257,14 @@ func runStdioServer(cfg Config) error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	hooks := &server.Hooks{}
-	if cfg.Multiplex {
-		hooks.OnBeforeCallTool = []server.OnBeforeCallToolFunc{kafka.BeforeToolCallPromptArgumentHook}
-		hooks.OnAfterCallTool = []server.OnAfterCallToolFunc{kafka.AfterToolCallPromptArgumentHook}
-		ctx = context.WithValue(ctx, "MultiplexModel", cfg.MultiplexModel)
+	startMetricsServer(cfg)
+	shutdownTracing, err := setupTracing(ctx, cfg)
+	if err != nil {
+		return err
 	}
-	// Create
-	kafkaServer := kafka.NewServer(version, cfg.readOnly, cfg.Multiplex, cfg.MultiplexModel, cfg.KafkaConfig, server.WithHooks(hooks))
+	defer shutdownTracing()
+
+	ctx, kafkaServer := newKafkaServer(ctx, cfg, &server.Hooks{})
 	stdioServer := server.NewStdioServer(kafkaServer)
 
 	stdLogger := stdlog.New(cfg.logger.Writer(), "stdioserver", 0)
@@ -148,7 +276,7 @@ func runStdioServer(cfg Config) error {
 		in, out := io.Reader(os.Stdin), io.Writer(os.Stdout)
 
 		if cfg.logCommands {
-			loggedIO := iolog.NewIOLogger(in, out, cfg.logger)
+			loggedIO := iolog.NewIOLogger(in, out, cfg.logger, cfg.KafkaConfig.Redactor)
 			in, out = loggedIO, loggedIO
 		}
 
@@ -170,6 +298,20 @@ func runStdioServer(cfg Config) error {
 	return nil
 }
 
+// newKafkaServer creates the MCP server and wires the resource subscription and multiplexing hooks.
+func newKafkaServer(ctx context.Context, cfg Config, hooks *server.Hooks) (context.Context, *server.MCPServer) {
+	subscriptions := kafka.NewSubscriptions(ctx, cfg.KafkaConfig)
+	hooks.AddOnRequestInitialization(subscriptions.OnRequestInitialization)
+	hooks.AddAfterSubscribe(subscriptions.AfterSubscribe)
+	hooks.AddAfterUnsubscribe(subscriptions.AfterUnsubscribe)
+	hooks.AddOnUnregisterSession(subscriptions.OnUnregisterSession)
+	if cfg.Multiplex || len(cfg.KafkaConfig.Recipes) > 0 {
+		hooks.OnBeforeCallTool = append(hooks.OnBeforeCallTool, kafka.BeforeToolCallPromptArgumentHook)
+		hooks.OnAfterCallTool = append(hooks.OnAfterCallTool, kafka.AfterToolCallPromptArgumentHook)
+	}
+	return ctx, kafka.NewServer(version, cfg.readOnly, cfg.Multiplex, cfg.MultiplexLLM, cfg.KafkaConfig, server.WithHooks(hooks))
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
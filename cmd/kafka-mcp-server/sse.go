@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/auth"
+	"github.com/CefBoud/kafka-mcp-server/pkg/kafka"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/spf13/viper"
+)
+
+// newAuthenticators builds the authenticators enabled by the auth-* and tls-client-ca-file flags.
+func newAuthenticators() ([]auth.Authenticator, error) {
+	defaultRole, err := auth.ParseRole(viper.GetString("auth-default-role"))
+	if err != nil {
+		return nil, fmt.Errorf("auth-default-role: %w", err)
+	}
+	roles, err := auth.LoadRoleMapping(viper.GetString("auth-roles-file"), defaultRole)
+	if err != nil {
+		return nil, err
+	}
+
+	var authenticators []auth.Authenticator
+	if viper.GetString("tls-client-ca-file") != "" {
+		authenticators = append(authenticators, &auth.MTLSAuthenticator{Roles: roles})
+	}
+	if path := viper.GetString("auth-tokens-file"); path != "" {
+		a, err := auth.NewTokenAuthenticator(path)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if path := viper.GetString("auth-jwks-file"); path != "" {
+		a, err := auth.NewJWTAuthenticator(auth.JWTConfig{
+			JWKSFile:  path,
+			Issuer:    viper.GetString("auth-jwt-issuer"),
+			Audience:  viper.GetString("auth-jwt-audience"),
+			RoleClaim: viper.GetString("auth-jwt-role-claim"),
+			Roles:     roles,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	return authenticators, nil
+}
+
+func runSSEServer(cfg Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	authenticators, err := newAuthenticators()
+	if err != nil {
+		return err
+	}
+
+	startMetricsServer(cfg)
+	shutdownTracing, err := setupTracing(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
+
+	hooks := &server.Hooks{}
+	hooks.AddAfterListTools(kafka.FilterToolsByRoleHook)
+	hooks.AddAfterListPrompts(kafka.FilterPromptsByRoleHook)
+	_, kafkaServer := newKafkaServer(ctx, cfg, hooks)
+
+	addr := viper.GetString("addr")
+	certFile, keyFile := viper.GetString("tls-cert-file"), viper.GetString("tls-key-file")
+	baseURL := viper.GetString("base-url")
+	if baseURL == "" {
+		scheme := "http"
+		if certFile != "" {
+			scheme = "https"
+		}
+		baseURL = fmt.Sprintf("%s://%s", scheme, addr)
+	}
+
+	sseServer := server.NewSSEServer(kafkaServer, server.WithBaseURL(baseURL))
+
+	var handler http.Handler = sseServer
+	if len(authenticators) > 0 {
+		handler = auth.Middleware(handler, cfg.logger, authenticators...)
+	} else {
+		cfg.logger.Warn("no authentication configured; every HTTP caller has full access")
+	}
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if caFile := viper.GetString("tls-client-ca-file"); caFile != "" {
+		if certFile == "" || keyFile == "" {
+			return fmt.Errorf("tls-client-ca-file requires tls-cert-file and tls-key-file")
+		}
+		if httpServer.TLSConfig, err = auth.ClientTLSConfig(caFile); err != nil {
+			return err
+		}
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errC <- httpServer.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			errC <- httpServer.ListenAndServe()
+		}
+	}()
+
+	_, _ = fmt.Fprintf(os.Stderr, "Kafka MCP Server listening on %s\n", baseURL)
+
+	select {
+	case <-ctx.Done():
+		cfg.logger.Infof("shutting down server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return httpServer.Shutdown(shutdownCtx)
+	case err := <-errC:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("error running server: %w", err)
+		}
+	}
+
+	return nil
+}
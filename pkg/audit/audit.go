@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Record describes one tool invocation, or one resource read checked as a call of Tool.
+type Record struct {
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"sessionId,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	Tool      string    `json:"tool"`
+	// Resource is the URI of the resource read instead of calling Tool.
+	Resource   string         `json:"resource,omitempty"`
+	Mutating   bool           `json:"mutating"`
+	Cluster    string         `json:"cluster,omitempty"`
+	Arguments  map[string]any `json:"arguments,omitempty"`
+	Outcome    string         `json:"outcome"`
+	Error      string         `json:"error,omitempty"`
+	DurationMs int64          `json:"durationMs"`
+	Resources  *Resources     `json:"resources,omitempty"`
+}
+
+// Resources are the Kafka objects a tool call touched.
+type Resources struct {
+	Topics []string `json:"topics,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	ACLs   []string `json:"acls,omitempty"`
+}
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+var secretKey = regexp.MustCompile(`(?i)pass(word)?|secret|token|credential|api[-_]?key|private[-_]?key`)
+
+// RedactArguments returns a copy of args where the values of secret-looking keys are masked, recursively.
+func RedactArguments(args map[string]any) map[string]any {
+	if args == nil {
+		return nil
+	}
+	redacted := make(map[string]any, len(args))
+	for k, v := range args {
+		if secretKey.MatchString(k) {
+			redacted[k] = "[REDACTED]"
+			continue
+		}
+		redacted[k] = redactValue(v)
+	}
+	return redacted
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return RedactArguments(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = redactValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+// Logger appends records as JSON lines to a file, rotating it when it grows beyond a maximum size.
+// A nil Logger discards records.
+type Logger struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewLogger opens the audit log at path. maxSizeMB of 0 disables rotation; otherwise the file is renamed
+// to path.1 (and older backups shifted, keeping at most maxBackups) once it exceeds maxSizeMB megabytes.
+func NewLogger(path string, maxSizeMB, maxBackups int) (*Logger, error) {
+	l := &Logger{path: path, maxSize: int64(maxSizeMB) << 20, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// Log writes a record.
+func (l *Logger) Log(r Record) error {
+	if l == nil {
+		return nil
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+	if l.maxBackups <= 0 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove audit log: %w", err)
+		}
+		return l.open()
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxBackups))
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return l.open()
+}
+
+// Close closes the underlying file.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
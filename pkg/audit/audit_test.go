@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactArguments(t *testing.T) {
+	args := map[string]any{
+		"name":     "orders",
+		"password": "hunter2",
+		"sasl": map[string]any{
+			"username":    "app",
+			"secretValue": "s3cr3t",
+		},
+		"messages": []any{map[string]any{"API_KEY": "k", "value": "v"}, "plain"},
+		"token":    42.0,
+	}
+	want := map[string]any{
+		"name":     "orders",
+		"password": "[REDACTED]",
+		"sasl": map[string]any{
+			"username":    "app",
+			"secretValue": "[REDACTED]",
+		},
+		"messages": []any{map[string]any{"API_KEY": "[REDACTED]", "value": "v"}, "plain"},
+		"token":    "[REDACTED]",
+	}
+	if got := RedactArguments(args); !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactArguments() = %#v, want %#v", got, want)
+	}
+	if args["password"] != "hunter2" || args["sasl"].(map[string]any)["secretValue"] != "s3cr3t" {
+		t.Error("RedactArguments() modified its argument")
+	}
+	if RedactArguments(nil) != nil {
+		t.Error("RedactArguments(nil) != nil")
+	}
+}
+
+// readRecords returns the tools of the records of an audit log file.
+func readRecords(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var tools []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid record %s: %v", scanner.Text(), err)
+		}
+		tools = append(tools, r.Tool)
+	}
+	return tools
+}
+
+func TestLoggerRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewLogger(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	record := func(tool string) Record {
+		return Record{Time: time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC), Tool: tool, Outcome: OutcomeSuccess}
+	}
+	line, _ := json.Marshal(record("tool-0"))
+	// two records per file
+	l.maxSize = int64(2 * (len(line) + 1))
+
+	for i := range 7 {
+		if err := l.Log(record("tool-" + strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string][]string{
+		path:        {"tool-6"},
+		path + ".1": {"tool-4", "tool-5"},
+		path + ".2": {"tool-2", "tool-3"},
+	}
+	for file, tools := range want {
+		if got := readRecords(t, file); !reflect.DeepEqual(got, tools) {
+			t.Errorf("%s holds %v, want %v", filepath.Base(file), got, tools)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists beyond maxBackups", filepath.Base(path))
+	}
+}
+
+func TestLoggerRotationWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 100)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLogger(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.maxSize = 150
+
+	if err := l.Log(Record{Tool: "createTopic"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRecords(t, path); !reflect.DeepEqual(got, []string{"createTopic"}) {
+		t.Errorf("audit log holds %v, want only the new record", got)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Error("a backup was kept with maxBackups 0")
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	if err := l.Log(Record{Tool: "listTopics"}); err != nil {
+		t.Errorf("Log() error = %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
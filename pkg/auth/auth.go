@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Role decides which tools an identity can see and call.
+type Role string
+
+const (
+	// RoleReadOnly can only call tools that do not modify the cluster.
+	RoleReadOnly Role = "read-only"
+	// RoleOperator can also produce messages.
+	RoleOperator Role = "operator"
+	// RoleAdmin can call every tool.
+	RoleAdmin Role = "admin"
+)
+
+var roleLevels = map[Role]int{
+	RoleReadOnly: 0,
+	RoleOperator: 1,
+	RoleAdmin:    2,
+}
+
+// ParseRole validates a role name.
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleLevels[role]; !ok {
+		return "", fmt.Errorf("unknown role %q (expected read-only, operator or admin)", s)
+	}
+	return role, nil
+}
+
+// Allows reports whether r grants at least the privileges of required.
+func (r Role) Allows(required Role) bool {
+	level, ok := roleLevels[r]
+	if !ok {
+		return false
+	}
+	return level >= roleLevels[required]
+}
+
+// Identity is an authenticated caller of the HTTP transport.
+type Identity struct {
+	Subject string `json:"subject"`
+	Role    Role   `json:"role"`
+	// Method is the authenticator that produced the identity: token, jwt or mtls.
+	Method string `json:"method"`
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored in ctx, or nil when the request was not authenticated,
+// e.g. when the server runs over stdio.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// ErrNoCredentials is returned by an Authenticator when the request carries no credentials it understands,
+// so that the next authenticator can be tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator extracts and verifies the identity of an HTTP request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Middleware rejects requests that none of the authenticators accept and stores the identity
+// in the request context otherwise.
+func Middleware(next http.Handler, logger *log.Logger, authenticators ...Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, a := range authenticators {
+			id, err := a.Authenticate(r)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			if err != nil {
+				logger.Warnf("authentication failed from %s: %v", r.RemoteAddr, err)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			logger.Debugf("authenticated %s as %s (%s)", id.Subject, id.Role, id.Method)
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+			return
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// RoleMapping assigns roles to subjects authenticated by JWT or client certificates.
+type RoleMapping struct {
+	Subjects    map[string]Role
+	DefaultRole Role
+}
+
+// LoadRoleMapping reads a JSON object mapping subjects to roles, e.g. {"alice": "admin"}.
+// An empty path yields a mapping that gives every subject the default role.
+func LoadRoleMapping(path string, defaultRole Role) (*RoleMapping, error) {
+	m := &RoleMapping{Subjects: map[string]Role{}, DefaultRole: defaultRole}
+	if path == "" {
+		return m, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read roles file: %w", err)
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse roles file: %w", err)
+	}
+	for subject, r := range raw {
+		role, err := ParseRole(r)
+		if err != nil {
+			return nil, fmt.Errorf("roles file, subject %q: %w", subject, err)
+		}
+		m.Subjects[subject] = role
+	}
+	return m, nil
+}
+
+// RoleFor returns the role of a subject.
+func (m *RoleMapping) RoleFor(subject string) Role {
+	if role, ok := m.Subjects[subject]; ok {
+		return role
+	}
+	return m.DefaultRole
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestAuthenticator(t *testing.T, cfg JWTConfig) (*JWTAuthenticator, testKeys) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64([]byte{1, 0, 1})},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": b64([]byte{1, 0, 1})},
+	}}
+	data, _ := json.Marshal(set)
+	cfg.JWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(cfg.JWKSFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Roles == nil {
+		cfg.Roles, _ = LoadRoleMapping("", RoleReadOnly)
+	}
+	a, err := NewJWTAuthenticator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return testNow }
+	return a, testKeys{rsa: rsaKey, ec: ecKey}
+}
+
+// sign returns a token of claims signed with the key of kid, using alg as the header claims it.
+func sign(t *testing.T, keys testKeys, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch kid {
+	case "ec":
+		r, s, err := ecdsa.Sign(rand.Reader, keys.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, keys.rsa, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	roles := &RoleMapping{Subjects: map[string]Role{"alice": RoleAdmin}, DefaultRole: RoleReadOnly}
+	a, keys := newTestAuthenticator(t, JWTConfig{Issuer: "https://issuer", Audience: "kafka-mcp", Roles: roles})
+	valid := func(extra map[string]any) map[string]any {
+		claims := map[string]any{"sub": "bob", "iss": "https://issuer", "aud": "kafka-mcp", "exp": testNow.Add(time.Hour).Unix()}
+		for k, v := range extra {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    *Identity
+		wantErr bool
+	}{
+		{name: "RS256", token: sign(t, keys, "RS256", "rsa", valid(nil)), want: &Identity{Subject: "bob", Role: RoleReadOnly, Method: "jwt"}},
+		{name: "ES256", token: sign(t, keys, "ES256", "ec", valid(nil)), want: &Identity{Subject: "bob", Role: RoleReadOnly, Method: "jwt"}},
+		{name: "role from mapping", token: sign(t, keys, "RS256", "rsa", valid(map[string]any{"sub": "alice"})), want: &Identity{Subject: "alice", Role: RoleAdmin, Method: "jwt"}},
+		{name: "role claim", token: sign(t, keys, "RS256", "rsa", valid(map[string]any{"role": "operator"})), want: &Identity{Subject: "bob", Role: RoleOperator, Method: "jwt"}},
+		{name: "audience list", token: sign(t, keys, "RS256", "rsa", valid(map[string]any{"aud": []string{"other", "kafka-mcp"}})), want: &Identity{Subject: "bob", Role: RoleReadOnly, Method: "jwt"}},
+		{name: "unknown role claim", token: sign(t, keys, "RS256", "rsa", valid(map[string]any{"role": "root"})), wantErr: true},
+		{name: "expired", token: sign(t, keys, "RS256", "rsa", valid(map[string]any{"exp": testNow.Add(-time.Minute).Unix()})), wantErr: true},
+		{name: "no exp", token: sign(t, keys, "RS256", "rsa", valid(map[string]any{"exp": nil})), wantErr: true},
+		{name: "not valid yet", token: sign(t, keys, "RS256", "rsa", valid(map[string]any{"nbf": testNow.Add(time.Minute).Unix()})), wantErr: true},
+		{name: "wrong issuer", token: sign(t, keys, "RS256", "rsa", valid(map[string]any{"iss": "https://other"})), wantErr: true},
+		{name: "wrong audience", token: sign(t, keys, "RS256", "rsa", valid(map[string]any{"aud": "other"})), wantErr: true},
+		{name: "no sub", token: sign(t, keys, "RS256", "rsa", valid(map[string]any{"sub": ""})), wantErr: true},
+		{name: "unknown key", token: sign(t, keys, "RS256", "other", valid(nil)), wantErr: true},
+		{name: "encryption key", token: sign(t, keys, "RS256", "enc", valid(nil)), wantErr: true},
+		{name: "algorithm not matching key", token: sign(t, keys, "ES256", "rsa", valid(nil)), wantErr: true},
+		{name: "unsupported algorithm", token: sign(t, keys, "HS256", "rsa", valid(nil)), wantErr: true},
+		{name: "tampered claims", token: tamper(sign(t, keys, "RS256", "rsa", valid(nil)), valid(map[string]any{"sub": "alice"})), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/sse", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			id, err := a.Authenticate(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Authenticate() = %+v, want an error", id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if *id != *tt.want {
+				t.Errorf("Authenticate() = %+v, want %+v", id, tt.want)
+			}
+		})
+	}
+}
+
+// tamper replaces the claims of a token, keeping its signature.
+func tamper(token string, claims map[string]any) string {
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(claims)
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+}
+
+func TestJWTAuthenticateNoCredentials(t *testing.T) {
+	a, _ := newTestAuthenticator(t, JWTConfig{})
+	for _, header := range []string{"", "Basic dXNlcjpwYXNz", "Bearer opaque-token"} {
+		r := httptest.NewRequest("GET", "/sse", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if _, err := a.Authenticate(r); err != ErrNoCredentials {
+			t.Errorf("Authenticate() with %q error = %v, want ErrNoCredentials", header, err)
+		}
+	}
+}
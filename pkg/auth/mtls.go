@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// MTLSAuthenticator identifies callers by the common name of their verified client certificate.
+// The TLS server must be configured with ClientTLSConfig so that certificates are verified
+// before requests reach the authenticator.
+type MTLSAuthenticator struct {
+	Roles *RoleMapping
+}
+
+// Authenticate implements Authenticator.
+func (a *MTLSAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, ErrNoCredentials
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	subject := cert.Subject.CommonName
+	if subject == "" {
+		return nil, fmt.Errorf("client certificate has no common name")
+	}
+	return &Identity{Subject: subject, Role: a.Roles.RoleFor(subject), Method: "mtls"}, nil
+}
+
+// ClientTLSConfig returns a TLS configuration that verifies client certificates against the CA bundle.
+// Certificates are requested but not required, so that token and JWT callers can still connect.
+func ClientTLSConfig(caFile string) (*tls.Config, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// StaticToken is an entry of the tokens file.
+type StaticToken struct {
+	Token   string `json:"token"`
+	Subject string `json:"subject"`
+	Role    Role   `json:"role"`
+}
+
+// TokenAuthenticator accepts static bearer tokens loaded from a file.
+type TokenAuthenticator struct {
+	tokens map[[sha256.Size]byte]StaticToken
+}
+
+// NewTokenAuthenticator loads a JSON list of {"token", "subject", "role"} entries.
+func NewTokenAuthenticator(path string) (*TokenAuthenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+	var entries []StaticToken
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file: %w", err)
+	}
+	a := &TokenAuthenticator{tokens: make(map[[sha256.Size]byte]StaticToken, len(entries))}
+	for i, e := range entries {
+		if e.Token == "" || e.Subject == "" {
+			return nil, fmt.Errorf("tokens file, entry %d: token and subject are required", i)
+		}
+		if _, err := ParseRole(string(e.Role)); err != nil {
+			return nil, fmt.Errorf("tokens file, subject %q: %w", e.Subject, err)
+		}
+		a.tokens[sha256.Sum256([]byte(e.Token))] = e
+	}
+	return a, nil
+}
+
+// Authenticate implements Authenticator. Tokens are compared by hash so that lookups do not
+// leak timing information about the stored tokens.
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok || looksLikeJWT(token) {
+		return nil, ErrNoCredentials
+	}
+	sum := sha256.Sum256([]byte(token))
+	for hash, e := range a.tokens {
+		if subtle.ConstantTimeCompare(hash[:], sum[:]) == 1 {
+			return &Identity{Subject: e.Subject, Role: e.Role, Method: "token"}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown bearer token")
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < len("Bearer ") || !strings.EqualFold(h[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(h[len("Bearer "):])
+	return token, token != ""
+}
+
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"fmt"
+	"path"
+	"slices"
+)
+
+// NameFilter restricts the topics or consumer groups tools can access using glob patterns.
+// Deny wins over Allow, and an empty Allow list allows every name that is not denied.
+type NameFilter struct {
+	Allow []string `mapstructure:"allow"`
+	Deny  []string `mapstructure:"deny"`
+}
+
+// Allowed reports whether name passes the filter.
+func (f NameFilter) Allowed(name string) bool {
+	if matchesAnyGlob(f.Deny, name) {
+		return false
+	}
+	return len(f.Allow) == 0 || matchesAnyGlob(f.Allow, name)
+}
+
+// Validate checks the glob patterns.
+func (f NameFilter) Validate() error {
+	for _, p := range append(slices.Clone(f.Allow), f.Deny...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func matchesAnyGlob(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ToolsConfig enables or disables tools by name. When Enabled is not empty, only the listed tools are registered.
+type ToolsConfig struct {
+	Enabled  []string `mapstructure:"enabled"`
+	Disabled []string `mapstructure:"disabled"`
+}
+
+func (t ToolsConfig) enabled(name string) bool {
+	if slices.Contains(t.Disabled, name) {
+		return false
+	}
+	return len(t.Enabled) == 0 || slices.Contains(t.Enabled, name)
+}
+
+// checkTopic returns an error when the topic is filtered out. The error does not reveal whether the topic exists.
+func (cfg *Config) checkTopic(topic string) error {
+	if !cfg.Topics.Allowed(topic) {
+		return fmt.Errorf("topic %q is not accessible", topic)
+	}
+	return nil
+}
+
+// checkGroup returns an error when the consumer group is filtered out.
+func (cfg *Config) checkGroup(group string) error {
+	if !cfg.ConsumerGroups.Allowed(group) {
+		return fmt.Errorf("consumer group %q is not accessible", group)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import "testing"
+
+func TestNameFilterAllowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter NameFilter
+		want   map[string]bool
+	}{
+		{
+			name:   "empty",
+			filter: NameFilter{},
+			want:   map[string]bool{"orders": true, "__consumer_offsets": true},
+		},
+		{
+			name:   "allow",
+			filter: NameFilter{Allow: []string{"orders.*", "payments"}},
+			want:   map[string]bool{"orders.eu": true, "orders": false, "payments": true, "payments.dlq": false},
+		},
+		{
+			name:   "deny",
+			filter: NameFilter{Deny: []string{"__*", "*.internal"}},
+			want:   map[string]bool{"orders": true, "__consumer_offsets": false, "billing.internal": false},
+		},
+		{
+			name:   "deny wins over allow",
+			filter: NameFilter{Allow: []string{"orders.*"}, Deny: []string{"orders.pii"}},
+			want:   map[string]bool{"orders.eu": true, "orders.pii": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, want := range tt.want {
+				if got := tt.filter.Allowed(name); got != want {
+					t.Errorf("Allowed(%q) = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNameFilterValidate(t *testing.T) {
+	if err := (NameFilter{Allow: []string{"orders.*"}, Deny: []string{"[a-z]*"}}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := (NameFilter{Deny: []string{"[orders"}}).Validate(); err == nil {
+		t.Error("Validate() of an invalid pattern succeeded")
+	}
+}
+
+func TestToolsConfigEnabled(t *testing.T) {
+	tests := []struct {
+		name  string
+		tools ToolsConfig
+		want  map[string]bool
+	}{
+		{"empty", ToolsConfig{}, map[string]bool{"listTopics": true, "createTopic": true}},
+		{"enabled", ToolsConfig{Enabled: []string{"listTopics"}}, map[string]bool{"listTopics": true, "createTopic": false}},
+		{"disabled", ToolsConfig{Disabled: []string{"createTopic"}}, map[string]bool{"listTopics": true, "createTopic": false}},
+		{"disabled wins", ToolsConfig{Enabled: []string{"createTopic"}, Disabled: []string{"createTopic"}}, map[string]bool{"createTopic": false}},
+	}
+	for _, tt := range tests {
+		for name, want := range tt.want {
+			if got := tt.tools.enabled(name); got != want {
+				t.Errorf("%s: enabled(%q) = %v, want %v", tt.name, name, got, want)
+			}
+		}
+	}
+}
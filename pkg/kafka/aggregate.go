@@ -0,0 +1,333 @@
+package kafka
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"regexp"
+	"slices"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	defaultAggregateGroups = 50
+	defaultAggregateRows   = 100
+	maxDistinctValues      = 100
+)
+
+// aggregatePattern matches the aggregates of aggregateMessages, such as `avg({{item.value.amount}})`.
+var aggregatePattern = regexp.MustCompile(`^\s*(count|sum|min|max|avg|distinct)\(\s*(.*?)\s*\)\s*$`)
+
+// AggregateResult summarizes a sample of messages.
+type AggregateResult struct {
+	// Messages is the size of the sample and Matched the number of its messages meeting `where`.
+	Messages int `json:"messages"`
+	Matched  int `json:"matched"`
+	// Aggregates are computed over the matched messages when there is no groupBy.
+	Aggregates map[string]any `json:"aggregates,omitempty"`
+	// Groups are sorted by decreasing count. OtherGroups counts the groups beyond maxGroups.
+	Groups      []*MessageGroup `json:"groups,omitempty"`
+	OtherGroups int             `json:"otherGroups,omitempty"`
+	// Rows are the projections of the first maxRows matched messages. OtherRows counts the others.
+	Rows      []map[string]any `json:"rows,omitempty"`
+	OtherRows int              `json:"otherRows,omitempty"`
+}
+
+// MessageGroup holds the aggregates of the messages sharing a groupBy value.
+type MessageGroup struct {
+	Key        any            `json:"key"`
+	Count      int            `json:"count"`
+	Aggregates map[string]any `json:"aggregates,omitempty"`
+
+	accumulators []*accumulator
+}
+
+// aggregate is an aggregate function applied to the values a reference selects in each message.
+type aggregate struct {
+	label     string
+	function  string
+	reference string
+}
+
+func parseAggregates(specs []any) ([]aggregate, error) {
+	var aggregates []aggregate
+	for _, spec := range specs {
+		s, _ := spec.(string)
+		m := aggregatePattern.FindStringSubmatch(s)
+		if m == nil {
+			return nil, fmt.Errorf("invalid aggregate %q: expected count(), or count, sum, min, max, avg or distinct of a reference such as avg({{item.value.amount}})", s)
+		}
+		if m[2] == "" && m[1] != "count" {
+			return nil, fmt.Errorf("invalid aggregate %q: %s needs a reference", s, m[1])
+		}
+		if m[2] != "" {
+			if err := checkAggregateExpression(m[2]); err != nil {
+				return nil, fmt.Errorf("invalid aggregate %q: %w", s, err)
+			}
+		}
+		aggregates = append(aggregates, aggregate{label: s, function: m[1], reference: m[2]})
+	}
+	return aggregates, nil
+}
+
+// checkAggregateExpression checks that an expression of aggregateMessages references the message, and
+// nothing else: an expression without reference would be the same for every message.
+func checkAggregateExpression(expression string) error {
+	if !referencePattern.MatchString(expression) {
+		return fmt.Errorf("%q must reference the message, such as {{item.value.id}}", expression)
+	}
+	return checkMessageReferences(expression)
+}
+
+// accumulator computes an aggregate over the values of a group. Values that cannot be selected, and
+// null values, are ignored; sum, min, max and avg also ignore values that are not numbers.
+type accumulator struct {
+	count    int
+	numbers  int
+	sum      float64
+	min, max float64
+	distinct map[string]bool
+}
+
+func (a *accumulator) add(v any) {
+	if v == nil {
+		return
+	}
+	a.count++
+	if a.distinct != nil {
+		a.distinct[referenceText(v)] = true
+	}
+	n, ok := number(v)
+	if !ok {
+		return
+	}
+	if a.numbers == 0 || n < a.min {
+		a.min = n
+	}
+	if a.numbers == 0 || n > a.max {
+		a.max = n
+	}
+	a.numbers++
+	a.sum += n
+}
+
+func (a *accumulator) result(function string) any {
+	switch function {
+	case "count":
+		return a.count
+	case "distinct":
+		values := slices.Sorted(maps.Keys(a.distinct))
+		result := map[string]any{"count": len(values)}
+		if len(values) > maxDistinctValues {
+			values = values[:maxDistinctValues]
+		}
+		result["values"] = values
+		return result
+	}
+	if a.numbers == 0 {
+		return nil
+	}
+	switch function {
+	case "sum":
+		return a.sum
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	}
+	return a.sum / float64(a.numbers)
+}
+
+// aggregateMessages filters, projects, groups and aggregates the messages of a sample.
+func aggregateMessages(messages []ConsumerMessage, where any, project map[string]any, groupBy string, aggregates []aggregate, maxGroups, maxRows int) *AggregateResult {
+	result := &AggregateResult{Messages: len(messages)}
+	groups := map[string]*MessageGroup{}
+	var order []*MessageGroup
+	for _, m := range messages {
+		scope := (&referenceScope{results: &batchResults{}}).forItem(messageItem(m), 0)
+		if where != nil {
+			// messages the condition cannot be evaluated for, typically missing a field, do not match
+			if ok, _ := evaluateCondition(where, scope); !ok {
+				continue
+			}
+		}
+		result.Matched++
+
+		if project != nil && len(result.Rows) >= maxRows {
+			result.OtherRows++
+		} else if project != nil {
+			row := make(map[string]any, len(project))
+			for name, expression := range project {
+				row[name], _ = resolveReferences(expression, scope)
+			}
+			result.Rows = append(result.Rows, row)
+		}
+
+		var key any
+		if groupBy != "" {
+			key, _ = resolveReferences(groupBy, scope)
+		}
+		group, ok := groups[referenceText(key)]
+		if !ok {
+			group = &MessageGroup{Key: key}
+			for _, a := range aggregates {
+				acc := &accumulator{}
+				if a.function == "distinct" {
+					acc.distinct = map[string]bool{}
+				}
+				group.accumulators = append(group.accumulators, acc)
+			}
+			groups[referenceText(key)] = group
+			order = append(order, group)
+		}
+		group.Count++
+		for i, a := range aggregates {
+			if a.reference == "" {
+				group.accumulators[i].add(true)
+				continue
+			}
+			v, err := resolveReferences(a.reference, scope)
+			if err == nil {
+				group.accumulators[i].add(v)
+			}
+		}
+	}
+
+	for _, group := range order {
+		if len(aggregates) > 0 {
+			group.Aggregates = map[string]any{}
+		}
+		for i, a := range aggregates {
+			group.Aggregates[a.label] = group.accumulators[i].result(a.function)
+		}
+	}
+	switch {
+	case groupBy != "":
+		slices.SortStableFunc(order, func(a, b *MessageGroup) int {
+			if c := cmp.Compare(b.Count, a.Count); c != 0 {
+				return c
+			}
+			return cmp.Compare(referenceText(a.Key), referenceText(b.Key))
+		})
+		if len(order) > maxGroups {
+			result.OtherGroups = len(order) - maxGroups
+			order = order[:maxGroups]
+		}
+		result.Groups = order
+	case len(order) > 0:
+		result.Aggregates = order[0].Aggregates
+	}
+	return result
+}
+
+func AggregateMessagesTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("aggregateMessages",
+			mcp.WithDescription("Consumes a sample of numMessages messages from a topic, like consumerMessages, and returns a summary instead of the messages: "+
+				"the messages meeting `where`, grouped by `groupBy`, with `aggregates` such as count(), avg({{item.value.amount}}) or distinct({{item.key}}) per group, and optionally `project`ed rows. "+
+				"Expressions reference the message with `{{item.key}}`, `{{item.value.<path>}}` (the decoded JSON value), `{{item.headers.<name>}}`, `{{item.partition}}`, `{{item.offset}}` and `{{item.timestamp}}`."),
+			mcp.WithString("name",
+				mcp.Required(),
+				mcp.Description("The name of the topic to consume messages from."),
+			),
+			mcp.WithNumber("numMessages",
+				mcp.Required(),
+				mcp.Description("Number of messages of the sample."),
+			),
+			mcp.WithNumber("offset",
+				mcp.Description("-2 (the default) samples the oldest messages, -1 waits for new messages."),
+			),
+			mcp.WithString("where",
+				mcp.Description("Condition the messages must meet, e.g. `{{item.value.status}} == PAID && {{item.value.amount}} > 100`. Messages missing a referenced field do not meet it."),
+			),
+			mcp.WithString("groupBy",
+				mcp.Description("Reference whose value groups the messages, e.g. `{{item.value.type}}` or `{{item.headers.source}}`."),
+			),
+			mcp.WithArray("aggregates",
+				mcp.Description("Aggregates computed per group, or over all matching messages without groupBy: count() counts messages; count, sum, min, max, avg and distinct apply to the non-null values of a reference, e.g. `max({{item.value.amount}})`. distinct returns the number of distinct values and the first 100."),
+				mcp.WithStringItems(),
+			),
+			mcp.WithObject("project",
+				mcp.Description("Names and references of the fields of a row returned for each matching message, e.g. {\"id\": \"{{item.value.id}}\", \"partition\": \"{{item.partition}}\"}."),
+			),
+			mcp.WithNumber("maxGroups",
+				mcp.Description(fmt.Sprintf("Maximum number of groups returned, the largest first. Defaults to %d.", defaultAggregateGroups)),
+			),
+			mcp.WithNumber("maxRows",
+				mcp.Description(fmt.Sprintf("Maximum number of projected rows returned, for the first matching messages. Defaults to %d.", defaultAggregateRows)),
+			),
+			withCluster(),
+		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			args := request.GetArguments()
+			topic, _ := args["name"].(string)
+			numMessages, _ := args["numMessages"].(float64)
+			offset := float64(-2)
+			if o, ok := args["offset"].(float64); ok {
+				offset = o
+			}
+			if err := cfg.checkTopic(topic); err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+
+			var where any
+			if w, ok := args["where"].(string); ok && w != "" {
+				if err := checkAggregateExpression(w); err != nil {
+					err = fmt.Errorf("invalid where condition: %w", err)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				where = w
+			}
+			groupBy, _ := args["groupBy"].(string)
+			if groupBy != "" {
+				if err := checkAggregateExpression(groupBy); err != nil {
+					err = fmt.Errorf("invalid groupBy: %w", err)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+			}
+			specs, _ := args["aggregates"].([]any)
+			aggregates, err := parseAggregates(specs)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			project, _ := args["project"].(map[string]any)
+			for name, expression := range project {
+				s, _ := expression.(string)
+				if err := checkAggregateExpression(s); err != nil {
+					err = fmt.Errorf("invalid project field %s: %w", name, err)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+			}
+			if project == nil && groupBy == "" && len(aggregates) == 0 {
+				err := fmt.Errorf("at least one of aggregates, groupBy or project is required")
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			maxGroups := defaultAggregateGroups
+			if n, ok := args["maxGroups"].(float64); ok && n >= 1 {
+				maxGroups = int(n)
+			}
+			maxRows := defaultAggregateRows
+			if n, ok := args["maxRows"].(float64); ok && n >= 1 {
+				maxRows = int(n)
+			}
+
+			log.Printf("topic: %v, numMessages %v, offset: %v", topic, numMessages, offset)
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			messages, err := cfg.consumeMessages(ctx, cluster, config, topic, int(numMessages), int64(offset))
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+
+			resultJSON, _ := json.Marshal(aggregateMessages(messages, where, project, groupBy, aggregates, maxGroups, maxRows))
+			if len(messages) < int(numMessages) && ctx.Err() != nil {
+				return newPartialToolResult(resultJSON, ctx.Err(), fmt.Sprintf("%d of %d messages consumed", len(messages), int(numMessages))), nil
+			}
+			return mcp.NewToolResultText(string(resultJSON)), nil
+		}
+}
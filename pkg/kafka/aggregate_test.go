@@ -0,0 +1,128 @@
+package kafka
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func aggregateSample() []ConsumerMessage {
+	return []ConsumerMessage{
+		{Key: "c-1", Value: `{"status":"PAID","amount":120}`, Partition: 0, Offset: 0},
+		{Key: "c-2", Value: `{"status":"PAID","amount":80}`, Partition: 0, Offset: 1},
+		{Key: "c-1", Value: `{"status":"FAILED","amount":"n/a"}`, Partition: 1, Offset: 0},
+		{Key: "c-3", Value: `{"status":"PAID","amount":10}`, Partition: 1, Offset: 1},
+		{Key: "c-4", Value: `{"status":"REFUNDED"}`, Partition: 2, Offset: 0},
+	}
+}
+
+func TestParseAggregates(t *testing.T) {
+	got, err := parseAggregates([]any{"count()", " avg( {{item.value.amount}} ) ", "distinct({{item.key}})"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []aggregate{
+		{label: "count()", function: "count"},
+		{label: " avg( {{item.value.amount}} ) ", function: "avg", reference: "{{item.value.amount}}"},
+		{label: "distinct({{item.key}})", function: "distinct", reference: "{{item.key}}"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAggregates() = %+v, want %+v", got, want)
+	}
+
+	for _, spec := range []any{"median({{item.value.amount}})", "sum()", "max(amount)", "count({{results[0].x}})", 3.0} {
+		if _, err := parseAggregates([]any{spec}); err == nil {
+			t.Errorf("parseAggregates(%v) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestCheckAggregateExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		valid      bool
+	}{
+		{"{{item.value.amount}} > 10", true},
+		{"{{item.key}}", true},
+		{"true", false},
+		{"{{results[0].x}} > 1", false},
+		{"{{params.topic}}", false},
+	}
+	for _, tt := range tests {
+		if err := checkAggregateExpression(tt.expression); (err == nil) != tt.valid {
+			t.Errorf("checkAggregateExpression(%q) error = %v, want valid %v", tt.expression, err, tt.valid)
+		}
+	}
+}
+
+// aggregateJSON runs aggregateMessages over the sample and returns its result as generic JSON.
+func aggregateJSON(t *testing.T, where any, project map[string]any, groupBy string, specs []any, maxGroups, maxRows int) map[string]any {
+	t.Helper()
+	aggregates, err := parseAggregates(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(aggregateMessages(aggregateSample(), where, project, groupBy, aggregates, maxGroups, maxRows))
+	var result map[string]any
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestAggregateMessages(t *testing.T) {
+	got := aggregateJSON(t, "{{item.value.status}} == PAID", nil, "", []any{
+		"count()", "sum({{item.value.amount}})", "min({{item.value.amount}})", "max({{item.value.amount}})",
+		"avg({{item.value.amount}})", "distinct({{item.key}})", "count({{item.value.missing}})",
+	}, 10, 10)
+	want := map[string]any{
+		"messages": 5.0,
+		"matched":  3.0,
+		"aggregates": map[string]any{
+			"count()":                       3.0,
+			"sum({{item.value.amount}})":    210.0,
+			"min({{item.value.amount}})":    10.0,
+			"max({{item.value.amount}})":    120.0,
+			"avg({{item.value.amount}})":    70.0,
+			"distinct({{item.key}})":        map[string]any{"count": 3.0, "values": []any{"c-1", "c-2", "c-3"}},
+			"count({{item.value.missing}})": 0.0,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregateMessages() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregateMessagesGroupBy(t *testing.T) {
+	got := aggregateJSON(t, nil, nil, "{{item.value.status}}", []any{"count()", "avg({{item.value.amount}})"}, 2, 10)
+	want := map[string]any{
+		"messages": 5.0,
+		"matched":  5.0,
+		"groups": []any{
+			map[string]any{"key": "PAID", "count": 3.0, "aggregates": map[string]any{"count()": 3.0, "avg({{item.value.amount}})": 70.0}},
+			// ties are sorted by key; the non-numeric amount is ignored
+			map[string]any{"key": "FAILED", "count": 1.0, "aggregates": map[string]any{"count()": 1.0, "avg({{item.value.amount}})": nil}},
+		},
+		"otherGroups": 1.0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregateMessages() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregateMessagesProject(t *testing.T) {
+	got := aggregateJSON(t, "{{item.partition}} < 2", map[string]any{"id": "{{item.key}}", "at": "{{item.partition}}-{{item.offset}}"}, "", nil, 10, 3)
+	want := map[string]any{
+		"messages": 5.0,
+		"matched":  4.0,
+		"rows": []any{
+			map[string]any{"id": "c-1", "at": "0-0"},
+			map[string]any{"id": "c-2", "at": "0-1"},
+			map[string]any{"id": "c-1", "at": "1-0"},
+		},
+		"otherRows": 1.0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregateMessages() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,155 @@
+package kafka
+
+import (
+	"context"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/audit"
+	"github.com/CefBoud/kafka-mcp-server/pkg/auth"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// isMutatingTool reports whether a tool can modify the cluster or write files, i.e. whether it needs more than the read-only role.
+func isMutatingTool(toolName string) bool {
+	return requiredRole(toolName) != auth.RoleReadOnly
+}
+
+// auditTool wraps a tool handler so that every call is written to the audit log.
+func (cfg *Config) auditTool(tool mcp.Tool, handler server.ToolHandlerFunc) (mcp.Tool, server.ToolHandlerFunc) {
+	if cfg.Audit == nil {
+		return tool, handler
+	}
+	return tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		start := time.Now()
+		result, err := handler(ctx, request)
+
+		record := audit.Record{
+			Time:       start.UTC(),
+			Tool:       tool.Name,
+			Mutating:   isMutatingTool(tool.Name),
+			Arguments:  audit.RedactArguments(request.GetArguments()),
+			Outcome:    audit.OutcomeSuccess,
+			DurationMs: time.Since(start).Milliseconds(),
+			Resources:  affectedResources(tool.Name, request),
+		}
+		if _, ok := tool.InputSchema.Properties["cluster"]; ok {
+			if cluster, err := cfg.Cluster(request); err == nil {
+				record.Cluster = cluster.Name
+			}
+		}
+		if err != nil {
+			record.Outcome, record.Error = audit.OutcomeError, err.Error()
+		} else if result != nil && result.IsError {
+			record.Outcome = audit.OutcomeError
+			record.Error = resultText(result)
+		}
+		cfg.writeAudit(ctx, record)
+		return result, err
+	}
+}
+
+// auditResourceRead writes the audit record of a resource read, checked as a call of toolName. The
+// topic or consumer group named in the path of the URI is reported as affected.
+func (cfg *Config) auditResourceRead(ctx context.Context, toolName, uri string, cluster *ClusterConfig, kind string, path []string, start time.Time, err error) {
+	record := audit.Record{
+		Time:       start.UTC(),
+		Tool:       toolName,
+		Resource:   uri,
+		Mutating:   isMutatingTool(toolName),
+		Cluster:    cluster.Name,
+		Outcome:    audit.OutcomeSuccess,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	switch {
+	case kind == "topics" && len(path) > 0:
+		record.Resources = &audit.Resources{Topics: path[:1]}
+	case kind == "groups" && len(path) > 0:
+		record.Resources = &audit.Resources{Groups: path[:1]}
+	}
+	if err != nil {
+		record.Outcome, record.Error = audit.OutcomeError, err.Error()
+	}
+	cfg.writeAudit(ctx, record)
+}
+
+// writeAudit completes the record with the session and identity of the caller in ctx and writes it.
+func (cfg *Config) writeAudit(ctx context.Context, record audit.Record) {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		record.SessionID = session.SessionID()
+	}
+	if id := auth.IdentityFromContext(ctx); id != nil {
+		record.Subject, record.Role = id.Subject, string(id.Role)
+	}
+	if logErr := cfg.Audit.Log(record); logErr != nil {
+		_, _ = os.Stderr.WriteString("failed to write audit record: " + logErr.Error() + "\n")
+	}
+}
+
+// resourceArguments name the arguments holding the topics, consumer groups and ACLs a tool reads or
+// writes. Each argument is a string or an array of strings.
+type resourceArguments struct {
+	topics, groups, acls string
+}
+
+// toolResourceArguments lists the resource arguments of the tools, which audit records report as affected.
+var toolResourceArguments = map[string]resourceArguments{
+	"consumerMessages":       {topics: "name"},
+	"searchMessages":         {topics: "name"},
+	"aggregateMessages":      {topics: "name"},
+	"profileTopic":           {topics: "name"},
+	"analyzePartitionSkew":   {topics: "name"},
+	"exportMessages":         {topics: "name"},
+	"backupTopic":            {topics: "name"},
+	"restoreTopic":           {topics: "name"},
+	"producerMessages":       {topics: "name"},
+	"createTopic":            {topics: "name"},
+	"topicOffsets":           {topics: "name"},
+	"describeConsumerGroups": {groups: "groups"},
+}
+
+// affectedResources returns the topics, consumer groups and ACLs named in the resource arguments of a tool.
+func affectedResources(toolName string, request mcp.CallToolRequest) *audit.Resources {
+	names, ok := toolResourceArguments[toolName]
+	if !ok {
+		return nil
+	}
+	args := request.GetArguments()
+	r := &audit.Resources{
+		Topics: argumentStrings(args, names.topics),
+		Groups: argumentStrings(args, names.groups),
+		ACLs:   argumentStrings(args, names.acls),
+	}
+	if len(r.Topics) == 0 && len(r.Groups) == 0 && len(r.ACLs) == 0 {
+		return nil
+	}
+	return r
+}
+
+// argumentStrings returns the non-empty strings of a string or array argument.
+func argumentStrings(args map[string]any, name string) []string {
+	var values []string
+	switch v := args[name].(type) {
+	case string:
+		values = append(values, v)
+	case []any:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return slices.DeleteFunc(values, func(s string) bool { return s == "" })
+}
+
+// resultText returns the text content of a tool result.
+func resultText(result *mcp.CallToolResult) string {
+	for _, c := range result.Content {
+		if text, ok := c.(mcp.TextContent); ok {
+			return text.Text
+		}
+	}
+	return ""
+}
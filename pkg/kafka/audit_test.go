@@ -0,0 +1,71 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/audit"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestAffectedResources(t *testing.T) {
+	tests := []struct {
+		tool string
+		args map[string]any
+		want *audit.Resources
+	}{
+		{"consumerMessages", map[string]any{"name": "orders"}, &audit.Resources{Topics: []string{"orders"}}},
+		{"describeConsumerGroups", map[string]any{"groups": []any{"billing", "", "audit"}}, &audit.Resources{Groups: []string{"billing", "audit"}}},
+		{"describeConsumerGroups", map[string]any{}, nil},
+		{"createTopic", map[string]any{"name": ""}, nil},
+		{"listTopics", map[string]any{"name": "orders"}, nil},
+	}
+	for _, tt := range tests {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = tt.args
+		if got := affectedResources(tt.tool, request); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("affectedResources(%s, %v) = %+v, want %+v", tt.tool, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestAuditTool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := audit.NewLogger(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	cfg := &Config{Audit: logger}
+
+	_, handler := cfg.auditTool(mcp.NewTool("producerMessages"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		err := errors.New("topic \"orders\" is not accessible")
+		return mcp.NewToolResultError(err.Error()), err
+	})
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]any{"name": "orders", "password": "p"}
+	if _, err := handler(context.Background(), request); err == nil {
+		t.Fatal("the handler error was not returned")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record audit.Record
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(data))), &record); err != nil {
+		t.Fatalf("invalid audit log %s: %v", data, err)
+	}
+	if record.Tool != "producerMessages" || !record.Mutating || record.Outcome != audit.OutcomeError || record.Error == "" {
+		t.Errorf("record = %+v, want a mutating producerMessages call with an error outcome", record)
+	}
+	if record.Arguments["password"] != "[REDACTED]" || !reflect.DeepEqual(record.Resources, &audit.Resources{Topics: []string{"orders"}}) {
+		t.Errorf("record arguments = %v, resources = %+v", record.Arguments, record.Resources)
+	}
+}
@@ -0,0 +1,96 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/auth"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// toolRoles lists the minimum role needed to see and call a tool. Tools that are not listed are read-only
+// and available to every role.
+var toolRoles = map[string]auth.Role{
+	"producerMessages": auth.RoleOperator,
+	"exportMessages":   auth.RoleOperator,
+	"createTopic":      auth.RoleAdmin,
+	"backupTopic":      auth.RoleAdmin,
+	"restoreTopic":     auth.RoleAdmin,
+}
+
+func requiredRole(toolName string) auth.Role {
+	if role, ok := toolRoles[toolName]; ok {
+		return role
+	}
+	return auth.RoleReadOnly
+}
+
+// toolAllowed reports whether the caller in ctx may use the tool. Unauthenticated callers (stdio) are
+// only restricted by the server-wide read-only flag.
+func toolAllowed(ctx context.Context, toolName string) bool {
+	id := auth.IdentityFromContext(ctx)
+	return id == nil || id.Role.Allows(requiredRole(toolName))
+}
+
+// checkToolAllowed returns an error when the caller in ctx may not use the tool.
+func checkToolAllowed(ctx context.Context, toolName string) error {
+	if !toolAllowed(ctx, toolName) {
+		id := auth.IdentityFromContext(ctx)
+		return fmt.Errorf("%s (role %s) is not allowed to call %s", id.Subject, id.Role, toolName)
+	}
+	return nil
+}
+
+// authorizeTool wraps a tool handler so that callers whose role is too low are rejected.
+func authorizeTool(tool mcp.Tool, handler server.ToolHandlerFunc) (mcp.Tool, server.ToolHandlerFunc) {
+	return tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if err := checkToolAllowed(ctx, tool.Name); err != nil {
+			return mcp.NewToolResultError(err.Error()), err
+		}
+		return handler(ctx, request)
+	}
+}
+
+// FilterToolsByRoleHook removes the tools the caller is not allowed to call from the tools/list result.
+func FilterToolsByRoleHook(ctx context.Context, id any, message *mcp.ListToolsRequest, result *mcp.ListToolsResult) {
+	tools := result.Tools[:0]
+	for _, t := range result.Tools {
+		if toolAllowed(ctx, t.Name) {
+			tools = append(tools, t)
+		}
+	}
+	result.Tools = tools
+}
+
+// promptAllowed reports whether the caller in ctx may call every tool whose data the prompt embeds.
+func promptAllowed(ctx context.Context, promptName string) bool {
+	for _, toolName := range promptTools[promptName] {
+		if !toolAllowed(ctx, toolName) {
+			return false
+		}
+	}
+	return true
+}
+
+// authorizePrompt wraps a prompt handler so that callers who may not call its tools are rejected.
+func authorizePrompt(name string, handler server.PromptHandlerFunc) server.PromptHandlerFunc {
+	return func(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		if !promptAllowed(ctx, name) {
+			id := auth.IdentityFromContext(ctx)
+			return nil, fmt.Errorf("%s (role %s) is not allowed to get %s", id.Subject, id.Role, name)
+		}
+		return handler(ctx, request)
+	}
+}
+
+// FilterPromptsByRoleHook removes the prompts the caller is not allowed to get from the prompts/list result.
+func FilterPromptsByRoleHook(ctx context.Context, id any, message *mcp.ListPromptsRequest, result *mcp.ListPromptsResult) {
+	prompts := result.Prompts[:0]
+	for _, p := range result.Prompts {
+		if promptAllowed(ctx, p.Name) {
+			prompts = append(prompts, p)
+		}
+	}
+	result.Prompts = prompts
+}
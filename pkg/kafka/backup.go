@@ -0,0 +1,570 @@
+package kafka
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
+	"github.com/IBM/sarama"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	backupVersion  = 1
+	manifestFile   = "manifest.json"
+	recordsFile    = "records.jsonl"
+	restoreBatch   = 500
+	topicReadyWait = 30 * time.Second
+)
+
+// BackupManifest describes the topic a backup archive holds. It is the first file of the archive,
+// followed by the records, one JSON object per line.
+type BackupManifest struct {
+	Version           int       `json:"version"`
+	Topic             string    `json:"topic"`
+	Cluster           string    `json:"cluster"`
+	CreatedAt         time.Time `json:"createdAt"`
+	Partitions        int32     `json:"partitions"`
+	ReplicationFactor int16     `json:"replicationFactor"`
+	// Configs are the configuration entries set on the topic itself. Sensitive entries are left out.
+	Configs map[string]string `json:"configs,omitempty"`
+	Records int64             `json:"records"`
+	// Complete is false when the export limit or the timeout stopped the backup before the end of the
+	// topic, as told by Reason.
+	Complete bool              `json:"complete"`
+	Reason   string            `json:"reason,omitempty"`
+	Ranges   []BackupPartition `json:"ranges"`
+}
+
+// BackupPartition is the range of a partition a backup holds.
+type BackupPartition struct {
+	Partition   int32 `json:"partition"`
+	StartOffset int64 `json:"startOffset"`
+	EndOffset   int64 `json:"endOffset"`
+	Records     int64 `json:"records"`
+}
+
+// backupRecord is a record of a backup archive. Keys, values and header values are base64-encoded
+// and null keys and values are kept null.
+type backupRecord struct {
+	Partition int32          `json:"partition"`
+	Offset    int64          `json:"offset"`
+	Timestamp time.Time      `json:"timestamp"`
+	Key       []byte         `json:"key"`
+	Value     []byte         `json:"value"`
+	Headers   []backupHeader `json:"headers,omitempty"`
+}
+
+type backupHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// BackupResult describes a backup archive.
+type BackupResult struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+	*BackupManifest
+}
+
+// RestoreResult describes a restore.
+type RestoreResult struct {
+	Topic        string `json:"topic"`
+	TopicCreated bool   `json:"topicCreated"`
+	Records      int64  `json:"records"`
+	// Produced counts the records produced to each partition.
+	Produced map[int32]int64 `json:"produced"`
+	// Warnings lists what the restore could not preserve.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// topicConfigs returns the configuration entries set on the topic itself, leaving out the sensitive
+// ones, whose values are not returned.
+func topicConfigs(ctx context.Context, cluster *ClusterConfig, admin sarama.ClusterAdmin, topic string) (map[string]string, error) {
+	var entries []sarama.ConfigEntry
+	_, span := startKafkaSpan(ctx, cluster, "DescribeConfig")
+	err := awaitContext(ctx, func() (err error) {
+		entries, err = admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: topic})
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("Error describing %s configuration: %v", topic, err)
+	}
+	configs := map[string]string{}
+	for _, e := range entries {
+		if e.Source == sarama.SourceTopic && !e.Sensitive {
+			configs[e.Name] = e.Value
+		}
+	}
+	return configs, nil
+}
+
+// backupTopic writes the records of a topic to records and returns the manifest of the backup. When
+// ctx is done first, the manifest of the records written so far is returned along with ctx's error.
+func (cfg *Config) backupTopic(ctx context.Context, cluster *ClusterConfig, client sarama.Client, topic string, records io.Writer) (*BackupManifest, error) {
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("Error init kafka admin client: %v", err)
+	}
+	m := &BackupManifest{Version: backupVersion, Topic: topic, Cluster: cluster.Name, CreatedAt: time.Now().UTC(), Complete: true}
+	if m.Configs, err = topicConfigs(ctx, cluster, admin, topic); err != nil {
+		return nil, err
+	}
+
+	var partitions []int32
+	_, span := startKafkaSpan(ctx, cluster, "Partitions")
+	err = awaitContext(ctx, func() (err error) {
+		if partitions, err = client.Partitions(topic); err != nil {
+			return err
+		}
+		replicas, err := client.Replicas(topic, partitions[0])
+		m.ReplicationFactor = int16(len(replicas))
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch partitions: %v", err)
+	}
+	m.Partitions = int32(len(partitions))
+
+	var total int64
+	for _, partition := range partitions {
+		r := BackupPartition{Partition: partition}
+		_, span := startKafkaSpan(ctx, cluster, "GetOffset")
+		err = awaitContext(ctx, func() (err error) {
+			if r.StartOffset, err = client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+				return fmt.Errorf("Error getting start offset of partition %d: %v", partition, err)
+			}
+			if r.EndOffset, err = client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+				return fmt.Errorf("Error getting end offset of partition %d: %v", partition, err)
+			}
+			return nil
+		})
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		m.Ranges = append(m.Ranges, r)
+		total += r.EndOffset - r.StartOffset
+	}
+	total = min(total, cfg.Export.MaxMessages)
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	enc := json.NewEncoder(records)
+	progress := progressFromContext(ctx)
+	var writeErr error
+	for i := range m.Ranges {
+		r := &m.Ranges[i]
+		if r.StartOffset >= r.EndOffset || !m.Complete {
+			continue
+		}
+		err = scanPartition(ctx, cluster, consumer, topic, r.Partition, r.StartOffset, r.EndOffset, func(message *sarama.ConsumerMessage) bool {
+			if m.Records >= cfg.Export.MaxMessages {
+				m.Complete, m.Reason = false, fmt.Sprintf("export limit of %d records reached", cfg.Export.MaxMessages)
+				return false
+			}
+			record := backupRecord{Partition: message.Partition, Offset: message.Offset, Timestamp: message.Timestamp, Key: message.Key, Value: message.Value}
+			for _, h := range message.Headers {
+				record.Headers = append(record.Headers, backupHeader{Key: string(h.Key), Value: h.Value})
+			}
+			if writeErr = enc.Encode(record); writeErr != nil {
+				return false
+			}
+			m.Records++
+			r.Records++
+			progress(float64(m.Records), float64(total))
+			return true
+		})
+		if writeErr != nil {
+			return nil, fmt.Errorf("Error writing backup: %v", writeErr)
+		}
+		if err != nil && ctx.Err() == nil {
+			return nil, fmt.Errorf("Error from consumer: %v", err)
+		}
+		if ctx.Err() != nil {
+			m.Complete, m.Reason = false, fmt.Sprintf("%d of %d records backed up", m.Records, total)
+			break
+		}
+	}
+	return m, ctx.Err()
+}
+
+// writeArchive writes a gzipped tar archive of the manifest followed by the records file to path,
+// which must not exist.
+func writeArchive(path string, m *BackupManifest, records *os.File) (err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("Error creating backup file: %v", err)
+	}
+	defer func() {
+		if err = errors.Join(err, f.Close()); err != nil {
+			os.Remove(path)
+		}
+	}()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	manifest, _ := json.MarshalIndent(m, "", "  ")
+	if err := tw.WriteHeader(&tar.Header{Name: manifestFile, Mode: 0o644, Size: int64(len(manifest)), ModTime: m.CreatedAt}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+	info, err := records.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: recordsFile, Mode: 0o644, Size: info.Size(), ModTime: m.CreatedAt}); err != nil {
+		return err
+	}
+	if _, err := records.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, records); err != nil {
+		return err
+	}
+	return errors.Join(tw.Close(), gz.Close())
+}
+
+// openArchive opens a backup archive and reads its manifest. The records are read from the returned
+// reader, and the returned function closes the archive.
+func openArchive(path string) (*BackupManifest, io.Reader, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Error opening backup: %v", err)
+	}
+	fail := func(err error) (*BackupManifest, io.Reader, func(), error) {
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("invalid backup %s: %v", path, err)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fail(err)
+	}
+	tr := tar.NewReader(gz)
+	m := &BackupManifest{}
+	for _, name := range []string{manifestFile, recordsFile} {
+		h, err := tr.Next()
+		if err != nil {
+			return fail(fmt.Errorf("missing %s", name))
+		}
+		if h.Name != name {
+			return fail(fmt.Errorf("expected %s, found %s", name, h.Name))
+		}
+		if name == manifestFile {
+			if err := json.NewDecoder(tr).Decode(m); err != nil {
+				return fail(fmt.Errorf("invalid manifest: %v", err))
+			}
+			if m.Version != backupVersion {
+				return fail(fmt.Errorf("unsupported version %d", m.Version))
+			}
+		}
+	}
+	return m, tr, func() { f.Close() }, nil
+}
+
+// waitForTopic waits for the metadata of a new topic to list its partitions.
+func waitForTopic(ctx context.Context, client sarama.Client, topic string, partitions int32) error {
+	ctx, cancel := context.WithTimeout(ctx, topicReadyWait)
+	defer cancel()
+	for {
+		if err := client.RefreshMetadata(topic); err == nil {
+			if p, err := client.Partitions(topic); err == nil && int32(len(p)) >= partitions {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("topic %s is not ready: %v", topic, ctx.Err())
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func BackupTopicTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("backupTopic",
+			mcp.WithDescription("Writes the records of a topic, with their keys, values, headers, timestamps and partitions, its configuration and partition count to a compressed archive on the server, for restoreTopic. "+
+				"Records are copied as they are, without redaction; the archive is not returned. Returns the archive's path and size and its manifest. "+
+				"When the timeout expires, the archive holds the records backed up so far and its manifest is marked incomplete: raise timeoutMs for larger topics."),
+			mcp.WithString("name",
+				mcp.Required(),
+				mcp.Description("The name of the topic to back up."),
+			),
+			mcp.WithString("path",
+				mcp.Description(fmt.Sprintf("Path of the archive to create, relative to the export directory %s. Defaults to <topic>-<time>.tar.gz.", cfg.Export.Dir)),
+			),
+			withCluster(),
+		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			args := request.GetArguments()
+			topic, _ := args["name"].(string)
+			if err := cfg.checkTopic(topic); err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			name, _ := args["path"].(string)
+			if name == "" {
+				name = fmt.Sprintf("%s-%s.tar.gz", topic, time.Now().UTC().Format("20060102T150405"))
+			}
+			path, err := cfg.exportPath(name, true)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			if _, err := os.Stat(path); err == nil {
+				err = fmt.Errorf("Error creating backup file: %s already exists", path)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			client, err := sarama.NewClient(cluster.BootstrapServers, config)
+			if err != nil {
+				err = fmt.Errorf("Failed to create Kafka client: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer metrics.TrackKafkaClient(cluster.Name, "consumer")()
+			defer client.Close()
+
+			// the records are staged next to the archive, as the manifest preceding them is only known at the end
+			records, err := os.CreateTemp(filepath.Dir(path), ".backup-*.jsonl")
+			if err != nil {
+				err = fmt.Errorf("Error creating backup file: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer os.Remove(records.Name())
+			defer records.Close()
+			w := bufio.NewWriter(records)
+			// when ctx is done, the records backed up so far are archived anyway
+			manifest, scanErr := cfg.backupTopic(ctx, cluster, client, topic, w)
+			if manifest == nil {
+				return mcp.NewToolResultError(scanErr.Error()), scanErr
+			}
+			err = w.Flush()
+			if err == nil {
+				err = writeArchive(path, manifest, records)
+			}
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+
+			result := BackupResult{Path: path, BackupManifest: manifest}
+			if info, err := os.Stat(path); err == nil {
+				result.Bytes = info.Size()
+			}
+			resultJSON, _ := json.Marshal(result)
+			if scanErr != nil {
+				return newPartialToolResult(resultJSON, scanErr, manifest.Reason), nil
+			}
+			return mcp.NewToolResultText(string(resultJSON)), nil
+		}
+}
+
+func RestoreTopicTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("restoreTopic",
+			mcp.WithDescription("Recreates a topic from an archive of backupTopic: creates it with the archived partition count and configuration, "+
+				"then produces the archived records to their original partitions with their original timestamps, keys, values and headers. Offsets are not preserved."),
+			mcp.WithString("path",
+				mcp.Required(),
+				mcp.Description(fmt.Sprintf("Path of the archive, relative to the export directory %s.", cfg.Export.Dir)),
+			),
+			mcp.WithString("name",
+				mcp.Description("The name of the topic to restore to. Defaults to the archived topic."),
+			),
+			mcp.WithNumber("replicationFactor",
+				mcp.Description("Replication factor of the created topic. Defaults to the archived topic's."),
+			),
+			mcp.WithBoolean("useExistingTopic",
+				mcp.Description("Produce the records to an existing topic with at least the archived number of partitions, instead of creating the topic. Defaults to false."),
+			),
+			withCluster(),
+		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			args := request.GetArguments()
+			name, _ := args["path"].(string)
+			path, err := cfg.exportPath(name, false)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			manifest, records, closeArchive, err := openArchive(path)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer closeArchive()
+			topic, _ := args["name"].(string)
+			if topic == "" {
+				topic = manifest.Topic
+			}
+			if err := cfg.checkTopic(topic); err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			replicationFactor := manifest.ReplicationFactor
+			if n, ok := args["replicationFactor"].(float64); ok && n >= 1 {
+				replicationFactor = int16(n)
+			}
+			useExisting, _ := args["useExistingTopic"].(bool)
+
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			if cluster.ReadOnly {
+				err = fmt.Errorf("cluster %s is read-only", cluster.Name)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			// records are sent to the partitions of the archive, one request at a time so that retries
+			// do not reorder them
+			config.Producer.Return.Successes = true
+			config.Producer.Partitioner = sarama.NewManualPartitioner
+			config.Net.MaxOpenRequests = 1
+			client, err := sarama.NewClient(cluster.BootstrapServers, config)
+			if err != nil {
+				err = fmt.Errorf("Failed to create Kafka client: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer metrics.TrackKafkaClient(cluster.Name, "producer")()
+			// closing the admin closes the client
+			admin, err := sarama.NewClusterAdminFromClient(client)
+			if err != nil {
+				client.Close()
+				err = fmt.Errorf("Error init kafka admin client: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer admin.Close()
+
+			result := RestoreResult{Topic: topic, Produced: map[int32]int64{}}
+			if useExisting {
+				var partitions []int32
+				_, span := startKafkaSpan(ctx, cluster, "Partitions")
+				err = awaitContext(ctx, func() (err error) {
+					partitions, err = client.Partitions(topic)
+					return err
+				})
+				tracing.End(span, err)
+				if err != nil {
+					err = fmt.Errorf("Failed to fetch partitions: %v", err)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				if int32(len(partitions)) < manifest.Partitions {
+					err = fmt.Errorf("topic %s has %d partitions, fewer than the %d of the backup", topic, len(partitions), manifest.Partitions)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+			} else {
+				detail := &sarama.TopicDetail{NumPartitions: manifest.Partitions, ReplicationFactor: replicationFactor, ConfigEntries: map[string]*string{}}
+				for name, value := range manifest.Configs {
+					detail.ConfigEntries[name] = &value
+				}
+				_, span := startKafkaSpan(ctx, cluster, "CreateTopic")
+				err = awaitContext(ctx, func() error {
+					return admin.CreateTopic(topic, detail, false)
+				})
+				tracing.End(span, err)
+				if err != nil {
+					err = fmt.Errorf("Error creating topic: %v", err)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				result.TopicCreated = true
+				if err = waitForTopic(ctx, client, topic, manifest.Partitions); err != nil {
+					return mcp.NewToolResultError(err.Error()), err
+				}
+			}
+			if t := manifest.Configs["message.timestamp.type"]; t == "LogAppendTime" {
+				result.Warnings = append(result.Warnings, "the topic uses LogAppendTime: the broker replaces the archived timestamps")
+			}
+			if !manifest.Complete {
+				result.Warnings = append(result.Warnings, "the backup is incomplete: "+manifest.Reason)
+			}
+
+			producer, err := sarama.NewSyncProducerFromClient(client)
+			if err != nil {
+				err = fmt.Errorf("Failed to start Sarama producer: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer func() {
+				if err := producer.Close(); err != nil {
+					log.Println("Failed to close Kafka producer cleanly:", err)
+				}
+			}()
+
+			progress := progressFromContext(ctx)
+			var batch []*sarama.ProducerMessage
+			send := func() error {
+				if len(batch) == 0 {
+					return nil
+				}
+				_, span := startKafkaSpan(ctx, cluster, "SendMessages")
+				err := producer.SendMessages(batch)
+				tracing.End(span, err)
+				if err != nil {
+					return fmt.Errorf("Error producing records: %v", err)
+				}
+				for _, msg := range batch {
+					result.Records++
+					result.Produced[msg.Partition]++
+					size := 0
+					if msg.Value != nil {
+						size = msg.Value.Length()
+					}
+					metrics.ObserveMessage(cluster.Name, metrics.Produced, size)
+				}
+				progress(float64(result.Records), float64(manifest.Records))
+				batch = batch[:0]
+				return nil
+			}
+
+			dec := json.NewDecoder(records)
+			for ctx.Err() == nil {
+				var r backupRecord
+				if err = dec.Decode(&r); err == io.EOF {
+					err = send()
+					break
+				}
+				if err != nil {
+					err = fmt.Errorf("invalid backup %s: %v", path, err)
+					break
+				}
+				msg := &sarama.ProducerMessage{Topic: topic, Partition: r.Partition, Timestamp: r.Timestamp}
+				// nil encoders keep null keys and values null
+				if r.Key != nil {
+					msg.Key = sarama.ByteEncoder(r.Key)
+				}
+				if r.Value != nil {
+					msg.Value = sarama.ByteEncoder(r.Value)
+				}
+				for _, h := range r.Headers {
+					msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(h.Key), Value: h.Value})
+				}
+				if batch = append(batch, msg); len(batch) >= restoreBatch {
+					if err = send(); err != nil {
+						break
+					}
+				}
+			}
+			resultJSON, _ := json.Marshal(result)
+			if ctx.Err() != nil {
+				return newPartialToolResult(resultJSON, ctx.Err(), fmt.Sprintf("%d of %d records restored", result.Records, manifest.Records)), nil
+			}
+			if err != nil {
+				err = fmt.Errorf("%v after restoring %d records", err, result.Records)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			return mcp.NewToolResultText(string(resultJSON)), nil
+		}
+}
@@ -0,0 +1,128 @@
+package kafka
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testManifest() *BackupManifest {
+	return &BackupManifest{
+		Version:           backupVersion,
+		Topic:             "orders",
+		Cluster:           "default",
+		CreatedAt:         time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC),
+		Partitions:        2,
+		ReplicationFactor: 3,
+		Configs:           map[string]string{"cleanup.policy": "compact"},
+		Records:           2,
+		Complete:          true,
+		Ranges:            []BackupPartition{{Partition: 0, StartOffset: 4, EndOffset: 6, Records: 2}, {Partition: 1}},
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := []backupRecord{
+		{Partition: 0, Offset: 4, Timestamp: time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC), Key: []byte("a"), Value: []byte{0xff, 0x00}, Headers: []backupHeader{{Key: "source", Value: []byte("web")}}},
+		{Partition: 0, Offset: 5, Timestamp: time.Date(2025, 5, 1, 9, 1, 0, 0, time.UTC), Key: []byte("a")},
+	}
+	records, err := os.Create(filepath.Join(dir, "records"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer records.Close()
+	enc := json.NewEncoder(records)
+	for _, r := range want {
+		if err := enc.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(dir, "orders.tar.gz")
+	if err := writeArchive(path, testManifest(), records); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeArchive(path, testManifest(), records); err == nil {
+		t.Error("writeArchive() overwrote an existing archive")
+	}
+
+	m, r, closeArchive, err := openArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeArchive()
+	if !reflect.DeepEqual(m, testManifest()) {
+		t.Errorf("manifest = %+v, want %+v", m, testManifest())
+	}
+	var got []backupRecord
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		var record backupRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %+v, want %+v", got, want)
+	}
+}
+
+// writeTestArchive writes a gzipped tar archive of files, given as name and content pairs.
+func writeTestArchive(t *testing.T, files ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "backup.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < len(files); i += 2 {
+		if err := tw.WriteHeader(&tar.Header{Name: files[i], Mode: 0o644, Size: int64(len(files[i+1]))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenArchiveErrors(t *testing.T) {
+	manifest, _ := json.Marshal(testManifest())
+	notGzip := filepath.Join(t.TempDir(), "backup.jsonl")
+	if err := os.WriteFile(notGzip, []byte(`{"version":1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "missing.tar.gz")},
+		{"not gzipped", notGzip},
+		{"no records", writeTestArchive(t, manifestFile, string(manifest))},
+		{"records first", writeTestArchive(t, recordsFile, "", manifestFile, string(manifest))},
+		{"invalid manifest", writeTestArchive(t, manifestFile, "{", recordsFile, "")},
+		{"unsupported version", writeTestArchive(t, manifestFile, `{"version":2}`, recordsFile, "")},
+	}
+	for _, tt := range tests {
+		if m, _, closeArchive, err := openArchive(tt.path); err == nil {
+			closeArchive()
+			t.Errorf("%s: openArchive() = %+v, want an error", tt.name, m)
+		}
+	}
+}
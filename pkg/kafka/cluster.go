@@ -2,25 +2,185 @@ package kafka
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
 
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
 	"github.com/IBM/sarama"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
+// ClusterConfig describes how to reach a named Kafka cluster.
+type ClusterConfig struct {
+	Name              string         `mapstructure:"name" json:"name"`
+	BootstrapServers  []string       `mapstructure:"bootstrap-servers" json:"bootstrapServers"`
+	Security          SecurityConfig `mapstructure:"security" json:"-"`
+	SchemaRegistryURL string         `mapstructure:"schema-registry-url" json:"schemaRegistryUrl,omitempty"`
+	// ReadOnly rejects mutating tool calls against this cluster.
+	ReadOnly bool `mapstructure:"read-only" json:"readOnly"`
+}
+
+type SecurityConfig struct {
+	TLS  TLSConfig  `mapstructure:"tls"`
+	SASL SASLConfig `mapstructure:"sasl"`
+}
+
+type TLSConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CAFile             string `mapstructure:"ca-file"`
+	CertFile           string `mapstructure:"cert-file"`
+	KeyFile            string `mapstructure:"key-file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure-skip-verify"`
+}
+
+type SASLConfig struct {
+	// Mechanism is one of PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512. SASL is disabled when empty.
+	Mechanism string `mapstructure:"mechanism"`
+	Username  string `mapstructure:"username"`
+	Password  string `mapstructure:"password"`
+}
+
+// SaramaConfig returns a sarama configuration with the cluster's security settings applied.
+func (c *ClusterConfig) SaramaConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.MetricRegistry = metrics.SaramaRegistry(c.Name)
+
+	if t := c.Security.TLS; t.Enabled {
+		tlsConfig := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+		if t.CAFile != "" {
+			pem, err := os.ReadFile(t.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file of cluster %s: %v", c.Name, err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			tlsConfig.RootCAs.AppendCertsFromPEM(pem)
+		}
+		if t.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate of cluster %s: %v", c.Name, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if s := c.Security.SASL; s.Mechanism != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.User = s.Username
+		config.Net.SASL.Password = s.Password
+		switch s.Mechanism {
+		case sarama.SASLTypePlaintext:
+			config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case sarama.SASLTypeSCRAMSHA256:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &XDGSCRAMClient{HashGeneratorFcn: SHA256} }
+		case sarama.SASLTypeSCRAMSHA512:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &XDGSCRAMClient{HashGeneratorFcn: SHA512} }
+		default:
+			return nil, fmt.Errorf("unsupported SASL mechanism %q for cluster %s", s.Mechanism, c.Name)
+		}
+	}
+
+	return config, nil
+}
+
+// Cluster returns the cluster named by the request's `cluster` argument, or the default cluster.
+func (cfg *Config) Cluster(request mcp.CallToolRequest) (*ClusterConfig, error) {
+	name, _ := request.GetArguments()["cluster"].(string)
+	return cfg.clusterNamed(name)
+}
+
+// clusterNamed returns the named cluster, or the default cluster when name is empty.
+func (cfg *Config) clusterNamed(name string) (*ClusterConfig, error) {
+	if name == "" {
+		name = cfg.DefaultCluster
+	}
+	cluster, ok := cfg.Clusters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster %q, use listClusters to see the available clusters", name)
+	}
+	return cluster, nil
+}
+
+// clusterConfig resolves the request's cluster and its sarama configuration.
+func (cfg *Config) clusterConfig(request mcp.CallToolRequest) (*ClusterConfig, *sarama.Config, error) {
+	name, _ := request.GetArguments()["cluster"].(string)
+	return cfg.namedClusterConfig(name)
+}
+
+// namedClusterConfig resolves a cluster by name, as clusterNamed does, and its sarama configuration.
+func (cfg *Config) namedClusterConfig(name string) (*ClusterConfig, *sarama.Config, error) {
+	cluster, err := cfg.clusterNamed(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := cluster.SaramaConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.Timeouts.apply(config)
+	return cluster, config, nil
+}
+
+// withCluster adds the optional `cluster` argument shared by every Kafka tool.
+func withCluster() mcp.ToolOption {
+	return mcp.WithString("cluster",
+		mcp.Description("The name of the cluster to use, as returned by listClusters. Defaults to the default cluster."),
+	)
+}
+
+func ListClustersTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("listClusters",
+			mcp.WithDescription("List the Kafka clusters this server is configured for. Their names can be passed as the `cluster` argument of the other tools."),
+		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			type clusterInfo struct {
+				*ClusterConfig
+				Default bool `json:"default"`
+			}
+			clusters := []clusterInfo{}
+			for name, c := range cfg.Clusters {
+				clusters = append(clusters, clusterInfo{ClusterConfig: c, Default: name == cfg.DefaultCluster})
+			}
+			sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+
+			result, _ := json.Marshal(clusters)
+			return mcp.NewToolResultText(string(result)), nil
+		}
+}
+
 func DescribeClusterTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
 	return mcp.NewTool("describeCluster",
 			mcp.WithDescription("Describe the Kafka cluster. Returns brokers and controllerID."),
+			withCluster(),
 		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			config := sarama.NewConfig()
-			admin, err := sarama.NewClusterAdmin(cfg.BootstrapServers, config)
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			admin, err := sarama.NewClusterAdmin(cluster.BootstrapServers, config)
 			if err != nil {
 				err = fmt.Errorf("Error init kafka admin client: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
-			brokers, controllerID, err := admin.DescribeCluster()
+			defer metrics.TrackKafkaClient(cluster.Name, "admin")()
+			defer admin.Close()
+			var brokers []*sarama.Broker
+			var controllerID int32
+			_, span := startKafkaSpan(ctx, cluster, "DescribeCluster")
+			err = awaitContext(ctx, func() (err error) {
+				brokers, controllerID, err = admin.DescribeCluster()
+				return err
+			})
+			tracing.End(span, err)
 			if err != nil {
 				err = fmt.Errorf("Error describing the cluster: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
@@ -6,35 +6,68 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/redact"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
 	"github.com/IBM/sarama"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
-)
-
-var (
-	CONSUMER_TIMEOUT = 15 * time.Second
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ConsumerMessage struct {
 	Key, Value string
+	Headers    map[string]string `json:",omitempty"`
 	Partition  int32
 	Offset     int64
 	Timestamp  string
+	// Redacted counts the fields and pattern matches masked by the redaction rules.
+	Redacted int `json:",omitempty"`
 }
 
 type ConsumerHandler struct {
 	msgCount int
+	// mu guards messages, which claims of different partitions append to concurrently.
+	mu       sync.Mutex
 	messages []ConsumerMessage
 	cancel   context.CancelFunc
+	progress Progress
+	redactor *redact.Redactor
+	cluster  string
+}
+
+// newConsumerMessage converts a sarama message, applying the redaction rules of its topic.
+func newConsumerMessage(message *sarama.ConsumerMessage, redactor *redact.Redactor) ConsumerMessage {
+	key, redactedKey := redactor.Message(message.Topic, message.Key)
+	value, redactedValue := redactor.Message(message.Topic, message.Value)
+	m := ConsumerMessage{
+		Key:       key,
+		Value:     value,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Timestamp: message.Timestamp.String(),
+		Redacted:  redactedKey + redactedValue,
+	}
+	for _, h := range message.Headers {
+		if redactor.DropHeader(message.Topic, string(h.Key)) {
+			m.Redacted++
+			continue
+		}
+		if m.Headers == nil {
+			m.Headers = map[string]string{}
+		}
+		m.Headers[string(h.Key)] = string(h.Value)
+	}
+	return m
 }
 
 func (c *ConsumerHandler) Setup(_ sarama.ConsumerGroupSession) error   { return nil }
 func (c *ConsumerHandler) Cleanup(_ sarama.ConsumerGroupSession) error { return nil }
 
 func (c *ConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	consumed := 0
 	for {
 		select {
 		case message, ok := <-claim.Messages():
@@ -42,17 +75,18 @@ func (c *ConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, clai
 				fmt.Fprintf(os.Stderr, "message channel was closed")
 				return nil
 			}
-			fmt.Fprintf(os.Stderr, "Message claimed: value = %s, timestamp = %v, topic = %s", string(message.Value), message.Timestamp, message.Topic)
+			fmt.Fprintf(os.Stderr, "Message claimed: partition = %d, offset = %d, timestamp = %v, topic = %s", message.Partition, message.Offset, message.Timestamp, message.Topic)
 			session.MarkMessage(message, "")
-			c.messages = append(c.messages, ConsumerMessage{
-				Key:       string(message.Key),
-				Value:     string(message.Value),
-				Partition: message.Partition,
-				Offset:    message.Offset,
-				Timestamp: message.Timestamp.String(),
-			})
-			consumed++
-			if c.msgCount == consumed {
+			c.mu.Lock()
+			if len(c.messages) < c.msgCount {
+				c.messages = append(c.messages, newConsumerMessage(message, c.redactor))
+				metrics.ObserveMessage(c.cluster, metrics.Consumed, len(message.Key)+len(message.Value))
+			}
+			consumed := len(c.messages)
+			c.mu.Unlock()
+			c.progress(float64(consumed), float64(c.msgCount))
+			done := consumed >= c.msgCount
+			if done {
 				c.cancel()
 				continue
 			}
@@ -66,7 +100,7 @@ func (c *ConsumerHandler) ConsumeClaim(session sarama.ConsumerGroupSession, clai
 
 func ConsumeMessagesTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
 	return mcp.NewTool("consumerMessages",
-			mcp.WithDescription("Consumes numMessages from a topic starting from the beginning (offset -2), from the end i.e. latest (offset -1) or from a specific offset (>= 0). The partition must be specified when consuming from a specific offset. If there are not enough messages, we timeout after 5 seconds."),
+			mcp.WithDescription("Consumes numMessages from a topic starting from the beginning (offset -2), from the end i.e. latest (offset -1) or from a specific offset (>= 0). The partition must be specified when consuming from a specific offset. If there are not enough messages, the call stops when its timeout expires and returns the messages consumed so far, marked as partial."),
 			mcp.WithString("name",
 				mcp.Required(),
 				mcp.Description("The name of the topic to consume messages from."),
@@ -82,41 +116,151 @@ func ConsumeMessagesTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandler
 			mcp.WithNumber("partitionIndex",
 				mcp.Description("The index of the topic's partition to consume from. This is required if the offset is ≥ 0."),
 			),
+			withCluster(),
 		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 
-			topic := request.Params.Arguments["name"].(string)
-			numMessages := request.Params.Arguments["numMessages"].(float64)
-			offset := request.Params.Arguments["offset"].(float64)
-			// partitionIndex, ok := request.Params.Arguments["partitionIndex"].(float64)
-			log.Printf("topic: %v, numMessages %v, offset: %v", topic, numMessages, offset)
-			config := sarama.NewConfig()
-
-			if offset == -1 {
-				config.Consumer.Offsets.Initial = sarama.OffsetNewest
-			} else if offset == -2 {
-				config.Consumer.Offsets.Initial = sarama.OffsetOldest
-			} else {
-				err := fmt.Errorf("offset should be -1 or -2")
+			topic := request.GetArguments()["name"].(string)
+			numMessages := request.GetArguments()["numMessages"].(float64)
+			offset := request.GetArguments()["offset"].(float64)
+			// partitionIndex, ok := request.GetArguments()["partitionIndex"].(float64)
+			if err := cfg.checkTopic(topic); err != nil {
 				return mcp.NewToolResultError(err.Error()), err
 			}
-			group := fmt.Sprintf("kafka-mcp-server-group-%v", time.Now().UnixMilli())
-			consumer, err := sarama.NewConsumerGroup(cfg.BootstrapServers, group, config)
+			log.Printf("topic: %v, numMessages %v, offset: %v", topic, numMessages, offset)
+			cluster, config, err := cfg.clusterConfig(request)
 			if err != nil {
-				err := fmt.Errorf("error creating consumer group: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
-			defer consumer.Close()
 
-			ctx, cancel := context.WithTimeout(context.Background(), CONSUMER_TIMEOUT)
-			defer cancel()
-			handler := &ConsumerHandler{msgCount: int(numMessages), cancel: cancel}
-			log.Println("starting consumer")
-			err = consumer.Consume(ctx, []string{topic}, handler)
+			messages, err := cfg.consumeMessages(ctx, cluster, config, topic, int(numMessages), int64(offset))
 			if err != nil {
-				err := fmt.Errorf("Error from consumer: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
-			result, _ := json.Marshal(handler.messages)
+			result, _ := json.Marshal(messages)
+			if len(messages) < int(numMessages) && ctx.Err() != nil {
+				return newPartialToolResult(result, ctx.Err(), fmt.Sprintf("%d of %d messages consumed", len(messages), int(numMessages))), nil
+			}
 			return mcp.NewToolResultText(string(result)), nil
 		}
 }
+
+// consumeMessages reads numMessages messages of a topic through a new consumer group, from the
+// oldest (offset -2) or newest (offset -1) messages. When ctx is done first, the messages read so
+// far are returned without error.
+func (cfg *Config) consumeMessages(ctx context.Context, cluster *ClusterConfig, config *sarama.Config, topic string, numMessages int, offset int64) ([]ConsumerMessage, error) {
+	if offset == -1 {
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	} else if offset == -2 {
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	} else {
+		return nil, fmt.Errorf("offset should be -1 or -2")
+	}
+	group := fmt.Sprintf("kafka-mcp-server-group-%v", time.Now().UnixMilli())
+	consumer, err := sarama.NewConsumerGroup(cluster.BootstrapServers, group, config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating consumer group: %v", err)
+	}
+	defer metrics.TrackKafkaClient(cluster.Name, "consumer-group")()
+	defer consumer.Close()
+
+	_, span := startKafkaSpan(ctx, cluster, "Consume", attribute.String("messaging.destination.name", topic))
+	consumeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	handler := &ConsumerHandler{msgCount: numMessages, cancel: cancel, progress: progressFromContext(ctx), redactor: cfg.Redactor, cluster: cluster.Name}
+	log.Println("starting consumer")
+	err = consumer.Consume(consumeCtx, []string{topic}, handler)
+	span.SetAttributes(attribute.Int("messaging.batch.message_count", len(handler.messages)))
+	if err != nil && ctx.Err() == nil {
+		tracing.End(span, err)
+		return nil, fmt.Errorf("Error from consumer: %v", err)
+	}
+	tracing.End(span, nil)
+	return handler.messages, nil
+}
+
+// consumePartition reads up to limit messages of a partition from offset, or from the oldest
+// message when offset is negative. It stops at the end of the partition or when ctx is done,
+// returning the messages read so far along with ctx's error.
+func (cfg *Config) consumePartition(ctx context.Context, cluster *ClusterConfig, config *sarama.Config, topic string, partition int32, offset int64, limit int) ([]ConsumerMessage, error) {
+	client, err := sarama.NewClient(cluster.BootstrapServers, config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Kafka client: %v", err)
+	}
+	defer metrics.TrackKafkaClient(cluster.Name, "consumer")()
+	defer client.Close()
+
+	_, span := startKafkaSpan(ctx, cluster, "GetOffset")
+	endOffset, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting end offset of partition %d: %v", partition, err)
+	}
+	if offset < 0 {
+		offset = sarama.OffsetOldest
+	}
+	messages := []ConsumerMessage{}
+	if offset >= endOffset {
+		return messages, nil
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	err = scanPartition(ctx, cluster, consumer, topic, partition, offset, endOffset, func(message *sarama.ConsumerMessage) bool {
+		messages = append(messages, newConsumerMessage(message, cfg.Redactor))
+		return len(messages) < limit
+	})
+	return messages, err
+}
+
+// scanIdleTimeout is how long scanPartition waits for a message once the partition has been fetched up
+// to its end. The last offsets of a partition may never be delivered: transaction markers, aborted
+// records and compacted records take offsets too.
+const scanIdleTimeout = 2 * time.Second
+
+// scanPartition calls fn with the messages of a partition from offset up to end, exclusive, until
+// fn returns false, or until no message is left before end. It returns ctx's error when ctx is done
+// first.
+func scanPartition(ctx context.Context, cluster *ClusterConfig, consumer sarama.Consumer, topic string, partition int32, offset, end int64, fn func(*sarama.ConsumerMessage) bool) (err error) {
+	scanned := 0
+	_, span := startKafkaSpan(ctx, cluster, "ConsumePartition", attribute.String("messaging.destination.name", topic))
+	defer func() {
+		span.SetAttributes(attribute.Int("messaging.batch.message_count", scanned))
+		tracing.End(span, err)
+	}()
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return fmt.Errorf("Error consuming partition %d: %v", partition, err)
+	}
+	defer pc.Close()
+
+	idle := time.NewTimer(scanIdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case message := <-pc.Messages():
+			if message.Offset >= end {
+				return nil
+			}
+			scanned++
+			metrics.ObserveMessage(cluster.Name, metrics.Consumed, len(message.Key)+len(message.Value))
+			if !fn(message) || message.Offset >= end-1 {
+				return nil
+			}
+			idle.Reset(scanIdleTimeout)
+		case <-idle.C:
+			// the high watermark is only known once a fetch returned: until then the fetch may just be slow
+			if pc.HighWaterMarkOffset() >= end {
+				return nil
+			}
+			idle.Reset(scanIdleTimeout)
+		case err := <-pc.Errors():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
 	"github.com/IBM/sarama"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -13,18 +15,35 @@ import (
 func ListConsumerGroupsTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
 	return mcp.NewTool("listConsumerGroups",
 			mcp.WithDescription("List consumer groups present in the cluster"),
+			withCluster(),
 		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			config := sarama.NewConfig()
-			admin, err := sarama.NewClusterAdmin(cfg.BootstrapServers, config)
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			admin, err := sarama.NewClusterAdmin(cluster.BootstrapServers, config)
 			if err != nil {
 				err = fmt.Errorf("Error init kafka admin client: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
-			groups, err := admin.ListConsumerGroups()
+			defer metrics.TrackKafkaClient(cluster.Name, "admin")()
+			defer admin.Close()
+			var groups map[string]string
+			_, span := startKafkaSpan(ctx, cluster, "ListConsumerGroups")
+			err = awaitContext(ctx, func() (err error) {
+				groups, err = admin.ListConsumerGroups()
+				return err
+			})
+			tracing.End(span, err)
 			if err != nil {
 				err = fmt.Errorf("Error listing consumer groups: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
+			for group := range groups {
+				if !cfg.ConsumerGroups.Allowed(group) {
+					delete(groups, group)
+				}
+			}
 
 			result, err := json.Marshal(groups)
 			if err != nil {
@@ -39,77 +58,139 @@ func ListConsumerGroupsTool(cfg *Config) (tool mcp.Tool, handler server.ToolHand
 func DescribeConsumerGroupsTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
 	return mcp.NewTool("describeConsumerGroups",
 			mcp.WithDescription("List Kafka consumer groups with topic/partition offsets and lag."),
+			mcp.WithArray("groups",
+				mcp.Description("IDs of the consumer groups to describe. Every consumer group is described when omitted."),
+				mcp.WithStringItems(),
+			),
+			withCluster(),
 		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			config := sarama.NewConfig()
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
 
-			client, err := sarama.NewClient(cfg.BootstrapServers, config)
+			client, err := sarama.NewClient(cluster.BootstrapServers, config)
 			if err != nil {
 				err = fmt.Errorf("Error creating Kafka client: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
+			defer metrics.TrackKafkaClient(cluster.Name, "client")()
 			defer client.Close()
 
-			admin, err := sarama.NewClusterAdmin(cfg.BootstrapServers, config)
+			admin, err := sarama.NewClusterAdmin(cluster.BootstrapServers, config)
 			if err != nil {
 				err = fmt.Errorf("Error creating Kafka admin: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
+			defer metrics.TrackKafkaClient(cluster.Name, "admin")()
 			defer admin.Close()
 
-			groups, err := admin.ListConsumerGroups()
-			if err != nil {
-				err = fmt.Errorf("Error listing consumer groups: %v", err)
-				return mcp.NewToolResultError(err.Error()), err
+			var groupIDs []string
+			if requested, ok := request.GetArguments()["groups"].([]any); ok {
+				for _, g := range requested {
+					groupID, _ := g.(string)
+					if err := cfg.checkGroup(groupID); err != nil {
+						return mcp.NewToolResultError(err.Error()), err
+					}
+					groupIDs = append(groupIDs, groupID)
+				}
+			} else {
+				var groups map[string]string
+				_, span := startKafkaSpan(ctx, cluster, "ListConsumerGroups")
+				err = awaitContext(ctx, func() (err error) {
+					groups, err = admin.ListConsumerGroups()
+					return err
+				})
+				tracing.End(span, err)
+				if err != nil {
+					err = fmt.Errorf("Error listing consumer groups: %v", err)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				for groupID := range groups {
+					if cfg.ConsumerGroups.Allowed(groupID) {
+						groupIDs = append(groupIDs, groupID)
+					}
+				}
 			}
 
 			var resultData []GroupInfo
 			logEndOffsets := make(map[string]int64)
+			progress := progressFromContext(ctx)
 
-			for groupID := range groups {
-				desc, err := admin.DescribeConsumerGroups([]string{groupID})
+			for i, groupID := range groupIDs {
+				if ctx.Err() != nil {
+					resultJSON, _ := json.Marshal(resultData)
+					return newPartialToolResult(resultJSON, ctx.Err(), fmt.Sprintf("%d of %d consumer groups described", i, len(groupIDs))), nil
+				}
+				progress(float64(i), float64(len(groupIDs)))
+				group, err := cfg.describeGroup(ctx, cluster, admin, client, groupID, logEndOffsets)
 				if err != nil {
 					continue
 				}
-
-				for _, group := range desc {
-					if group.Err != sarama.ErrNoError {
-						continue
-					}
-
-					offsets, err := admin.ListConsumerGroupOffsets(group.GroupId, nil)
-					if err != nil {
-						continue
-					}
-
-					var partitionOffsets []GroupPartitionInfo
-
-					for topic, partitions := range offsets.Blocks {
-						for partition, block := range partitions {
-							tp := fmt.Sprintf("%s-%d", topic, partition)
-							if _, ok := logEndOffsets[tp]; !ok {
-								logEndOffsets[tp], err = client.GetOffset(topic, partition, sarama.OffsetNewest)
-								if err != nil {
-									continue
-								}
-							}
-							partitionOffsets = append(partitionOffsets, GroupPartitionInfo{
-								Topic:         topic,
-								Partition:     partition,
-								CurrentOffset: block.Offset,
-								LogEndOffset:  logEndOffsets[tp],
-								Lag:           logEndOffsets[tp] - block.Offset,
-							})
-						}
-					}
-
-					resultData = append(resultData, GroupInfo{
-						GroupID: group.GroupId,
-						Offsets: partitionOffsets,
-					})
-				}
+				resultData = append(resultData, *group)
 			}
 
+			progress(float64(len(groupIDs)), float64(len(groupIDs)))
 			resultJSON, _ := json.Marshal(resultData)
 			return mcp.NewToolResultText(string(resultJSON)), nil
 		}
 }
+
+// describeGroup returns the committed offsets and lag of a consumer group on the accessible topics.
+// logEndOffsets caches the end offsets of the partitions across calls.
+func (cfg *Config) describeGroup(ctx context.Context, cluster *ClusterConfig, admin sarama.ClusterAdmin, client sarama.Client, groupID string, logEndOffsets map[string]int64) (*GroupInfo, error) {
+	_, span := startKafkaSpan(ctx, cluster, "DescribeConsumerGroups")
+	desc, err := admin.DescribeConsumerGroups([]string{groupID})
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(desc) == 0 {
+		return nil, fmt.Errorf("consumer group %q not found", groupID)
+	}
+	group := desc[0]
+	if group.Err != sarama.ErrNoError {
+		return nil, group.Err
+	}
+
+	_, span = startKafkaSpan(ctx, cluster, "ListConsumerGroupOffsets")
+	offsets, err := admin.ListConsumerGroupOffsets(group.GroupId, nil)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	var partitionOffsets []GroupPartitionInfo
+
+	for topic, partitions := range offsets.Blocks {
+		if !cfg.Topics.Allowed(topic) {
+			continue
+		}
+		for partition, block := range partitions {
+			tp := fmt.Sprintf("%s-%d", topic, partition)
+			if _, ok := logEndOffsets[tp]; !ok {
+				_, span := startKafkaSpan(ctx, cluster, "GetOffset")
+				logEndOffset, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+				tracing.End(span, err)
+				if err != nil {
+					continue
+				}
+				logEndOffsets[tp] = logEndOffset
+			}
+			partitionOffsets = append(partitionOffsets, GroupPartitionInfo{
+				Topic:         topic,
+				Partition:     partition,
+				CurrentOffset: block.Offset,
+				LogEndOffset:  logEndOffsets[tp],
+				Lag:           logEndOffsets[tp] - block.Offset,
+			})
+		}
+	}
+
+	return &GroupInfo{
+		GroupID: group.GroupId,
+		State:   group.State,
+		Members: len(group.Members),
+		Offsets: partitionOffsets,
+	}, nil
+}
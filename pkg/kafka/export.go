@@ -0,0 +1,465 @@
+package kafka
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/redact"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
+	"github.com/IBM/sarama"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/parquet-go/parquet-go"
+)
+
+// ExportConfig bounds the files exportMessages writes.
+type ExportConfig struct {
+	// Dir is the directory the files of exportMessages calls are written to.
+	Dir string
+	// MaxMessages is the default and maximum number of messages of an export.
+	MaxMessages int64
+}
+
+// Formats of exported files.
+const (
+	ExportJSONL   = "jsonl"
+	ExportCSV     = "csv"
+	ExportParquet = "parquet"
+)
+
+// ExportResult describes an exported file.
+type ExportResult struct {
+	Path    string `json:"path"`
+	Format  string `json:"format"`
+	Records int64  `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	// Complete is false when maxMessages stopped the export before the end of the range.
+	Complete   bool                `json:"complete"`
+	Reason     string              `json:"reason,omitempty"`
+	Partitions []ExportedPartition `json:"partitions"`
+}
+
+// ExportedPartition is the range of a partition an export covers.
+type ExportedPartition struct {
+	Partition   int32 `json:"partition"`
+	StartOffset int64 `json:"startOffset"`
+	EndOffset   int64 `json:"endOffset"`
+	// NextOffset is the first offset that was not exported, where an export continuing this one starts.
+	NextOffset int64 `json:"nextOffset"`
+	Exported   int64 `json:"exported"`
+}
+
+// exportRecord is a row of an exported file. Keys and values that are not UTF-8 text once redacted
+// are base64-encoded.
+type exportRecord struct {
+	Partition int32             `parquet:"partition"`
+	Offset    int64             `parquet:"offset"`
+	Timestamp time.Time         `parquet:"timestamp,timestamp(millisecond)"`
+	Key       *string           `parquet:"key,optional"`
+	Value     *string           `parquet:"value,optional"`
+	Headers   map[string]string `parquet:"headers"`
+}
+
+// recordWriter writes the records of an exported file to an underlying writer, which Close does
+// not close.
+type recordWriter interface {
+	Write(r *exportRecord) error
+	Close() error
+}
+
+// jsonlWriter writes a JSON object per line. With decode, keys and values holding JSON are written
+// as JSON rather than as strings.
+type jsonlWriter struct {
+	w      *bufio.Writer
+	enc    *json.Encoder
+	decode bool
+}
+
+func newJSONLWriter(f *os.File, decode bool) *jsonlWriter {
+	w := bufio.NewWriter(f)
+	return &jsonlWriter{w: w, enc: json.NewEncoder(w), decode: decode}
+}
+
+func (j *jsonlWriter) payload(s *string) any {
+	if s == nil {
+		return nil
+	}
+	if j.decode && json.Valid([]byte(*s)) {
+		return json.RawMessage(*s)
+	}
+	return *s
+}
+
+func (j *jsonlWriter) Write(r *exportRecord) error {
+	return j.enc.Encode(struct {
+		Partition int32             `json:"partition"`
+		Offset    int64             `json:"offset"`
+		Timestamp time.Time         `json:"timestamp"`
+		Key       any               `json:"key"`
+		Value     any               `json:"value"`
+		Headers   map[string]string `json:"headers,omitempty"`
+	}{r.Partition, r.Offset, r.Timestamp, j.payload(r.Key), j.payload(r.Value), r.Headers})
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.w.Flush()
+}
+
+// csvWriter writes a header row and a row per record, with the headers as a JSON object. Null keys
+// and values are empty.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(f *os.File) (*csvWriter, error) {
+	w := csv.NewWriter(f)
+	return &csvWriter{w: w}, w.Write([]string{"partition", "offset", "timestamp", "key", "value", "headers"})
+}
+
+func (c *csvWriter) Write(r *exportRecord) error {
+	var key, value, headers string
+	if r.Key != nil {
+		key = *r.Key
+	}
+	if r.Value != nil {
+		value = *r.Value
+	}
+	if len(r.Headers) > 0 {
+		b, _ := json.Marshal(r.Headers)
+		headers = string(b)
+	}
+	return c.w.Write([]string{strconv.Itoa(int(r.Partition)), strconv.FormatInt(r.Offset, 10), r.Timestamp.UTC().Format(time.RFC3339Nano), key, value, headers})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type parquetWriter struct {
+	w *parquet.GenericWriter[exportRecord]
+}
+
+func (p *parquetWriter) Write(r *exportRecord) error {
+	_, err := p.w.Write([]exportRecord{*r})
+	return err
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.Close()
+}
+
+// newExportRecord converts a message, applying the redaction rules of its topic and then the extra
+// rules of the export.
+func newExportRecord(message *sarama.ConsumerMessage, redactor, extra *redact.Redactor) *exportRecord {
+	r := &exportRecord{Partition: message.Partition, Offset: message.Offset, Timestamp: message.Timestamp}
+	payload := func(b []byte) *string {
+		if b == nil {
+			return nil
+		}
+		s, _ := redactor.Message(message.Topic, b)
+		s, _ = extra.Message(message.Topic, []byte(s))
+		if !utf8.ValidString(s) {
+			s = base64.StdEncoding.EncodeToString([]byte(s))
+		}
+		return &s
+	}
+	r.Key, r.Value = payload(message.Key), payload(message.Value)
+	for _, h := range message.Headers {
+		if redactor.DropHeader(message.Topic, string(h.Key)) || extra.DropHeader(message.Topic, string(h.Key)) {
+			continue
+		}
+		if r.Headers == nil {
+			r.Headers = map[string]string{}
+		}
+		r.Headers[string(h.Key)] = string(h.Value)
+	}
+	return r
+}
+
+// exportFormat returns the format argument, or the format of the path's extension.
+func exportFormat(format, path string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(path), ".")
+		if format == "" {
+			format = ExportJSONL
+		}
+	}
+	switch format {
+	case ExportJSONL, ExportCSV, ExportParquet:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported export format %q: expected jsonl, csv or parquet", format)
+}
+
+// ExportMessages writes the messages of a topic to a new file at path and describes it. args holds
+// the arguments of exportMessages: the topic name, the partitions, offset and time range, format,
+// decode, maxMessages and the extra redaction rules. When ctx is done first, the file holds the
+// messages exported so far and ctx's error is returned along with the result.
+func (cfg *Config) ExportMessages(ctx context.Context, clusterName, path string, args map[string]any) (*ExportResult, error) {
+	topic, _ := args["name"].(string)
+	if err := cfg.checkTopic(topic); err != nil {
+		return nil, err
+	}
+	formatArg, _ := args["format"].(string)
+	format, err := exportFormat(formatArg, path)
+	if err != nil {
+		return nil, err
+	}
+	decode, _ := args["decode"].(bool)
+	var startTime, endTime time.Time
+	now := time.Now()
+	if s, ok := args["startTime"].(string); ok && s != "" {
+		if startTime, err = parseSearchTime(s, now); err != nil {
+			return nil, err
+		}
+	}
+	if s, ok := args["endTime"].(string); ok && s != "" {
+		if endTime, err = parseSearchTime(s, now); err != nil {
+			return nil, err
+		}
+	}
+	maxMessages := cfg.Export.MaxMessages
+	if n, ok := args["maxMessages"].(float64); ok && n >= 1 && int64(n) < maxMessages {
+		maxMessages = int64(n)
+	}
+	rule := redact.Rule{Fields: toStrings(args["redactFields"]), Patterns: toStrings(args["redactPatterns"])}
+	var extra *redact.Redactor
+	if len(rule.Fields) > 0 || len(rule.Patterns) > 0 {
+		if extra, err = redact.New([]redact.Rule{rule}); err != nil {
+			return nil, err
+		}
+	}
+
+	cluster, config, err := cfg.namedClusterConfig(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(cluster.BootstrapServers, config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create Kafka client: %v", err)
+	}
+	defer metrics.TrackKafkaClient(cluster.Name, "consumer")()
+	defer client.Close()
+
+	var partitions []int32
+	_, span := startKafkaSpan(ctx, cluster, "Partitions")
+	err = awaitContext(ctx, func() (err error) {
+		partitions, err = client.Partitions(topic)
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch partitions: %v", err)
+	}
+	if partitions, err = selectPartitions(topic, partitions, args); err != nil {
+		return nil, err
+	}
+
+	result := &ExportResult{Path: path, Format: format, Complete: true}
+	var total int64
+	for _, partition := range partitions {
+		var r SearchedPartition
+		_, span := startKafkaSpan(ctx, cluster, "GetOffset")
+		err = awaitContext(ctx, func() (err error) {
+			r, err = searchRange(client, topic, partition, args, startTime, endTime)
+			return err
+		})
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		result.Partitions = append(result.Partitions, ExportedPartition{Partition: partition, StartOffset: r.StartOffset, EndOffset: r.EndOffset, NextOffset: r.NextOffset})
+		total += r.EndOffset - r.StartOffset
+	}
+	total = min(total, maxMessages)
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	// the file is not overwritten when it exists, and removed when the export fails
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating export file: %v", err)
+	}
+	var w recordWriter
+	switch format {
+	case ExportCSV:
+		w, err = newCSVWriter(f)
+	case ExportParquet:
+		w = &parquetWriter{w: parquet.NewGenericWriter[exportRecord](f, parquet.Compression(&parquet.Snappy))}
+	default:
+		w = newJSONLWriter(f, decode)
+	}
+	fail := func(err error) (*ExportResult, error) {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err != nil {
+		return fail(fmt.Errorf("Error writing export file: %v", err))
+	}
+
+	progress := progressFromContext(ctx)
+	var writeErr error
+	for i := range result.Partitions {
+		p := &result.Partitions[i]
+		if p.StartOffset >= p.EndOffset || !result.Complete {
+			continue
+		}
+		err = scanPartition(ctx, cluster, consumer, topic, p.Partition, p.StartOffset, p.EndOffset, func(message *sarama.ConsumerMessage) bool {
+			if result.Records >= maxMessages {
+				result.Complete, result.Reason = false, fmt.Sprintf("maxMessages of %d exported", maxMessages)
+				return false
+			}
+			if writeErr = w.Write(newExportRecord(message, cfg.Redactor, extra)); writeErr != nil {
+				return false
+			}
+			result.Records++
+			p.Exported++
+			p.NextOffset = message.Offset + 1
+			progress(float64(result.Records), float64(total))
+			return true
+		})
+		if writeErr != nil {
+			return fail(fmt.Errorf("Error writing export file: %v", writeErr))
+		}
+		if err != nil && ctx.Err() == nil {
+			return fail(fmt.Errorf("Error from consumer: %v", err))
+		}
+		if ctx.Err() != nil {
+			result.Complete, result.Reason = false, fmt.Sprintf("%d of %d messages exported", result.Records, total)
+			break
+		}
+	}
+
+	if err := errors.Join(w.Close(), f.Close()); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("Error writing export file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	result.Bytes = info.Size()
+	return result, ctx.Err()
+}
+
+// exportPath returns the path of a file of the export directory, which name must stay inside. With
+// create, it creates the directories of the path.
+func (cfg *Config) exportPath(name string, create bool) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid path %q: it must be relative to the export directory and stay inside it", name)
+	}
+	path := filepath.Join(cfg.Export.Dir, name)
+	if create {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return "", fmt.Errorf("Error creating export directory: %v", err)
+		}
+	}
+	return path, nil
+}
+
+// toStrings returns the strings of a JSON array argument.
+func toStrings(v any) []string {
+	var s []string
+	items, _ := v.([]any)
+	for _, item := range items {
+		if str, ok := item.(string); ok {
+			s = append(s, str)
+		}
+	}
+	return s
+}
+
+func ExportMessagesTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("exportMessages",
+			mcp.WithDescription("Writes the messages of a topic, or some of its partitions, between offsets or times to a JSONL, CSV or Parquet file on the server, "+
+				"with their key, value, headers, partition, offset and timestamp, and returns the file's path, record count and size instead of the messages. "+
+				"Messages are redacted by the server's rules plus the given redactFields and redactPatterns."),
+			mcp.WithString("name",
+				mcp.Required(),
+				mcp.Description("The name of the topic to export."),
+			),
+			mcp.WithString("path",
+				mcp.Description(fmt.Sprintf("Path of the file to create, relative to the export directory %s. Defaults to <topic>-<time>.<format>.", cfg.Export.Dir)),
+			),
+			mcp.WithString("format",
+				mcp.Description("Format of the file. Defaults to the extension of path, or jsonl."),
+				mcp.Enum(ExportJSONL, ExportCSV, ExportParquet),
+			),
+			mcp.WithArray("partitions",
+				mcp.Description("The partitions to export. Defaults to every partition."),
+				mcp.WithNumberItems(),
+			),
+			mcp.WithNumber("startOffset",
+				mcp.Description("Offset to start from in each partition. Defaults to the oldest message."),
+			),
+			mcp.WithNumber("endOffset",
+				mcp.Description("Offset to stop before in each partition. Defaults to the end of the partition."),
+			),
+			mcp.WithString("startTime",
+				mcp.Description("Only export messages with a timestamp at or after this time, in RFC 3339 format or as a duration back from now such as 1h."),
+			),
+			mcp.WithString("endTime",
+				mcp.Description("Only export messages with a timestamp before this time, in RFC 3339 format or as a duration back from now."),
+			),
+			mcp.WithNumber("maxMessages",
+				mcp.Description(fmt.Sprintf("Maximum number of messages to export. Defaults to and is capped at %d.", cfg.Export.MaxMessages)),
+			),
+			mcp.WithBoolean("decode",
+				mcp.Description("Write the keys and values holding JSON as JSON rather than as strings. Only applies to jsonl."),
+			),
+			mcp.WithArray("redactFields",
+				mcp.Description("Dot-separated paths of JSON fields masked in the values, such as customer.email, on top of the server's redaction rules."),
+				mcp.WithStringItems(),
+			),
+			mcp.WithArray("redactPatterns",
+				mcp.Description("Regular expressions, or email, card-number or token, masked in the keys and values, on top of the server's redaction rules."),
+				mcp.WithStringItems(),
+			),
+			withCluster(),
+		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			args := request.GetArguments()
+			topic, _ := args["name"].(string)
+			name, _ := args["path"].(string)
+			if name == "" {
+				formatArg, _ := args["format"].(string)
+				format, err := exportFormat(formatArg, "")
+				if err != nil {
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				name = fmt.Sprintf("%s-%s.%s", topic, time.Now().UTC().Format("20060102T150405"), format)
+			}
+			path, err := cfg.exportPath(name, true)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+
+			cluster, _ := args["cluster"].(string)
+			result, err := cfg.ExportMessages(ctx, cluster, path, args)
+			if result == nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			resultJSON, _ := json.Marshal(result)
+			if err != nil {
+				return newPartialToolResult(resultJSON, err, result.Reason), nil
+			}
+			return mcp.NewToolResultText(string(resultJSON)), nil
+		}
+}
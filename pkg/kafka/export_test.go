@@ -0,0 +1,208 @@
+package kafka
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/redact"
+	"github.com/IBM/sarama"
+	"github.com/parquet-go/parquet-go"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+var testExportRecords = []exportRecord{
+	{Partition: 0, Offset: 7, Timestamp: time.Date(2025, 5, 1, 10, 0, 0, 123e6, time.UTC), Key: stringPtr("a"), Value: stringPtr(`{"id":1}`), Headers: map[string]string{"source": "web"}},
+	{Partition: 2, Offset: 9, Timestamp: time.Date(2025, 5, 1, 10, 0, 1, 0, time.UTC), Value: stringPtr("plain, \"quoted\"")},
+	{Partition: 2, Offset: 10, Timestamp: time.Date(2025, 5, 1, 10, 0, 2, 0, time.UTC), Key: stringPtr("b")},
+}
+
+// writeExport writes the test records to a new file with the writer newWriter returns for it, and
+// returns the path of the file.
+func writeExport(t *testing.T, newWriter func(f *os.File) (recordWriter, error)) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "export")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := newWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range testExportRecords {
+		if err := w.Write(&testExportRecords[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJSONLWriter(t *testing.T) {
+	tests := []struct {
+		decode bool
+		first  string
+	}{
+		{false, `{"partition":0,"offset":7,"timestamp":"2025-05-01T10:00:00.123Z","key":"a","value":"{\"id\":1}","headers":{"source":"web"}}`},
+		{true, `{"partition":0,"offset":7,"timestamp":"2025-05-01T10:00:00.123Z","key":"a","value":{"id":1},"headers":{"source":"web"}}`},
+	}
+	for _, tt := range tests {
+		path := writeExport(t, func(f *os.File) (recordWriter, error) { return newJSONLWriter(f, tt.decode), nil })
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			tt.first,
+			`{"partition":2,"offset":9,"timestamp":"2025-05-01T10:00:01Z","key":null,"value":"plain, \"quoted\""}`,
+			`{"partition":2,"offset":10,"timestamp":"2025-05-01T10:00:02Z","key":"b","value":null}`,
+		}
+		if got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); !reflect.DeepEqual(got, want) {
+			t.Errorf("decode %v: lines = %q, want %q", tt.decode, got, want)
+		}
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	path := writeExport(t, func(f *os.File) (recordWriter, error) { return newCSVWriter(f) })
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"partition", "offset", "timestamp", "key", "value", "headers"},
+		{"0", "7", "2025-05-01T10:00:00.123Z", "a", `{"id":1}`, `{"source":"web"}`},
+		{"2", "9", "2025-05-01T10:00:01Z", "", `plain, "quoted"`, ""},
+		{"2", "10", "2025-05-01T10:00:02Z", "b", "", ""},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	path := writeExport(t, func(f *os.File) (recordWriter, error) {
+		return &parquetWriter{w: parquet.NewGenericWriter[exportRecord](f, parquet.Compression(&parquet.Snappy))}, nil
+	})
+	rows, err := parquet.ReadFile[exportRecord](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(testExportRecords) {
+		t.Fatalf("read %d rows, want %d", len(rows), len(testExportRecords))
+	}
+	for i, got := range rows {
+		want := testExportRecords[i]
+		if !got.Timestamp.Equal(want.Timestamp) {
+			t.Errorf("row %d timestamp = %v, want %v", i, got.Timestamp, want.Timestamp)
+		}
+		got.Timestamp = want.Timestamp
+		if len(got.Headers) == 0 && len(want.Headers) == 0 {
+			got.Headers = want.Headers
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("row %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestNewExportRecord(t *testing.T) {
+	redactor, err := redact.New([]redact.Rule{{Topics: []string{"orders"}, Fields: []string{"email"}, DropHeaders: []string{"authorization"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra, err := redact.New([]redact.Rule{{Fields: []string{"name"}, DropHeaders: []string{"x-secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &sarama.ConsumerMessage{
+		Topic:     "orders",
+		Partition: 1,
+		Offset:    5,
+		Key:       []byte{0xff, 0x00},
+		Value:     []byte(`{"email":"ann@example.com","name":"Ann","id":1}`),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("Authorization"), Value: []byte("Bearer x")},
+			{Key: []byte("x-secret"), Value: []byte("s")},
+			{Key: []byte("source"), Value: []byte("web")},
+		},
+	}
+	got := newExportRecord(message, redactor, extra)
+	want := &exportRecord{
+		Partition: 1,
+		Offset:    5,
+		Key:       stringPtr(base64.StdEncoding.EncodeToString([]byte{0xff, 0x00})),
+		Value:     stringPtr(`{"email":"[REDACTED]","id":1,"name":"[REDACTED]"}`),
+		Headers:   map[string]string{"source": "web"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newExportRecord() = %+v, want %+v", got, want)
+	}
+	if got := newExportRecord(&sarama.ConsumerMessage{Topic: "orders"}, nil, nil); got.Key != nil || got.Value != nil || got.Headers != nil {
+		t.Errorf("newExportRecord() of a tombstone = %+v", got)
+	}
+}
+
+func TestExportFormat(t *testing.T) {
+	tests := []struct {
+		format, path, want string
+	}{
+		{"", "orders.jsonl", ExportJSONL},
+		{"", "orders.csv", ExportCSV},
+		{"", "dir/orders.parquet", ExportParquet},
+		{"", "orders", ExportJSONL},
+		{ExportCSV, "orders.jsonl", ExportCSV},
+	}
+	for _, tt := range tests {
+		if got, err := exportFormat(tt.format, tt.path); err != nil || got != tt.want {
+			t.Errorf("exportFormat(%q, %q) = %s, %v, want %s", tt.format, tt.path, got, err, tt.want)
+		}
+	}
+	for _, tt := range []struct{ format, path string }{{"", "orders.txt"}, {"xml", "orders.jsonl"}} {
+		if got, err := exportFormat(tt.format, tt.path); err == nil {
+			t.Errorf("exportFormat(%q, %q) = %s, want an error", tt.format, tt.path, got)
+		}
+	}
+}
+
+func TestExportPath(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{Export: ExportConfig{Dir: dir}}
+
+	got, err := cfg.exportPath("2025/05/orders.jsonl", false)
+	if want := filepath.Join(dir, "2025", "05", "orders.jsonl"); err != nil || got != want {
+		t.Errorf("exportPath() = %s, %v, want %s", got, err, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2025")); !os.IsNotExist(err) {
+		t.Errorf("exportPath() without create created the directory: %v", err)
+	}
+	if _, err := cfg.exportPath("2025/05/orders.jsonl", true); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "2025", "05")); err != nil || !info.IsDir() {
+		t.Errorf("exportPath() with create did not create the directory: %v", err)
+	}
+
+	for _, name := range []string{"", "../orders.jsonl", "a/../../orders.jsonl", "/tmp/orders.jsonl"} {
+		if got, err := cfg.exportPath(name, true); err == nil {
+			t.Errorf("exportPath(%q) = %s, want an error", name, got)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// instrumentTool wraps a tool handler to record call counts, errors and latency.
+func instrumentTool(tool mcp.Tool, handler server.ToolHandlerFunc) (mcp.Tool, server.ToolHandlerFunc) {
+	return tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		start := time.Now()
+		result, err := handler(ctx, request)
+		metrics.ObserveTool(tool.Name, start, err != nil || (result != nil && result.IsError))
+		return result, err
+	}
+}
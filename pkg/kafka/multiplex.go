@@ -6,23 +6,56 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 
+	"github.com/CefBoud/kafka-mcp-server/pkg/llm"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
-type ModelType string
+// promptArgumentPrefix marks the arguments of multiplexed calls that are inferred by an LLM.
+const promptArgumentPrefix = "PROMPT_ARGUMENT:"
 
+// Bounds of the requests and responses of a batch that are given to the LLM as context. The oldest
+// entries are dropped first.
 const (
-	GeminiModel ModelType = "gemini"
+	maxBatchContextBytes = 64 << 10
+	maxBatchEntryBytes   = 16 << 10
 )
 
-const ToolContextKey = "tool"
+type batchKey struct{}
 
-var ToolContext = ""
+// batch is the state of a MultiplexTools call, shared by the hooks of its nested calls through
+// their context, so concurrent batches of different sessions do not see each other's calls.
+type batch struct {
+	// provider infers PROMPT_ARGUMENTs when the client does not support sampling.
+	provider llm.Provider
 
-func getModel(ctx context.Context) ModelType {
-	return ModelType(ctx.Value("MultiplexModel").(string))
+	mu      sync.Mutex
+	entries []string
+	size    int
+}
+
+// batchFromContext returns the batch of a nested call, or nil for calls outside of MultiplexTools.
+func batchFromContext(ctx context.Context) *batch {
+	b, _ := ctx.Value(batchKey{}).(*batch)
+	return b
+}
+
+// add appends an entry to the context of the batch and returns the context.
+func (b *batch) add(entry string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(entry) > maxBatchEntryBytes {
+		entry = entry[:maxBatchEntryBytes] + "... (truncated)"
+	}
+	b.entries = append(b.entries, entry)
+	b.size += len(entry)
+	for b.size > maxBatchContextBytes && len(b.entries) > 1 {
+		b.size -= len(b.entries[0])
+		b.entries = b.entries[1:]
+	}
+	return strings.Join(b.entries, "\n")
 }
 
 func InferArgumentPrompt(previousContext string, argument string) string {
@@ -38,48 +71,62 @@ Return the argument only with nothing else. This will be given as input to a fun
 	`, previousContext, argument)
 }
 
-func addStringToToolCallContext(s string) string {
-	ToolContext += s + "\n"
-	return ToolContext
-}
-
-func getToolCallContext() string {
-	return ToolContext
-}
-func clearToolContext() {
-	ToolContext = ""
-}
-
-// BeforeToolCallPromptArgumentHook is called before tool calls. It queries the configured LLM to infer PROMPT_ARGUMENT from the previous context. It also appends the messages to the context.
+// BeforeToolCallPromptArgumentHook is called before tool calls. For calls made by MultiplexTools, it
+// appends the request to the batch's context and queries the LLM to infer the `PROMPT_ARGUMENT:`
+// arguments from it.
 func BeforeToolCallPromptArgumentHook(ctx context.Context, id any, message *mcp.CallToolRequest) {
+	b := batchFromContext(ctx)
+	if b == nil {
+		return
+	}
 	messageJson, _ := json.Marshal(message)
-	toolContext := addStringToToolCallContext("Tool call request:\n" + string(messageJson))
+	toolContext := b.add("Tool call request:\n" + string(messageJson))
 
-	for k, v := range message.Params.Arguments {
-		if arg, ok := v.(string); ok {
-			strings.HasPrefix(arg, "PROMPT_ARGUMENT")
-			prompt := InferArgumentPrompt(toolContext, arg)
-			inferredArg, err := QueryLLM(prompt, getModel(ctx))
-			if err != nil {
-				return
-			}
-			fmt.Fprintf(os.Stderr, "inferredArg %v", inferredArg)
-			message.Params.Arguments[k] = inferredArg
+	for k, v := range message.GetArguments() {
+		arg, ok := v.(string)
+		if !ok || !strings.HasPrefix(arg, promptArgumentPrefix) {
+			continue
 		}
-
+		prompt := InferArgumentPrompt(toolContext, strings.TrimSpace(strings.TrimPrefix(arg, promptArgumentPrefix)))
+		inferredArg, err := QueryLLM(ctx, prompt, b.provider)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to infer argument %s: %v\n", k, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "inferred argument %s: %v\n", k, inferredArg)
+		message.GetArguments()[k] = inferredArg
 	}
 }
 
-// AfterToolCallPromptArgumentHook adds the tool result to the context.
-func AfterToolCallPromptArgumentHook(ctx context.Context, id any, message *mcp.CallToolRequest, result *mcp.CallToolResult) {
-	fmt.Fprintf(os.Stderr, "inside AfterToolCallPromptArgumentHook %v", message)
+// AfterToolCallPromptArgumentHook adds the result of calls made by MultiplexTools to the batch's context.
+func AfterToolCallPromptArgumentHook(ctx context.Context, id any, message *mcp.CallToolRequest, result any) {
+	b := batchFromContext(ctx)
+	if b == nil {
+		return
+	}
 	resultJson, _ := json.Marshal(result)
-	addStringToToolCallContext("Tool call result:\n" + string(resultJson))
+	b.add("Tool call result:\n" + string(resultJson))
 }
 
-func MultiplexToolsTool(cfg *Config, multiplexModel string, s *server.MCPServer) (tool mcp.Tool, handler server.ToolHandlerFunc) {
+func MultiplexToolsTool(cfg *Config, provider llm.Provider, s *server.MCPServer) (tool mcp.Tool, handler server.ToolHandlerFunc) {
 	return mcp.NewTool("MultiplexTools",
-			mcp.WithDescription("Takes a list of tool requests and executes each one, returning a list of their results. Use this tool when you need to call multiple tools in sequence. If an argument for a tool at position N depends on the result of a previous tool [1...N-1], you can express that argument as a prompt to the LLM using the format `PROMPT_ARGUMENT: your prompt here`. For example: `PROMPT_ARGUMENT: the ID of the created resource.`"),
+			mcp.WithDescription("Takes a list of tool requests and executes them, returning an object of their results keyed by request ID, each with its status (ok, error or skipped), response and timing. "+
+				"By default each tool runs after the previous one. When any request declares `dependsOn`, a list of request IDs, requests run concurrently as soon as the requests they depend on succeed. "+
+				"If an argument of a tool depends on the result of another tool, reference that result with `{{results[i].path}}`, where i is the 0-based position of the other tool (negative counts back, results[-1] is the previous tool) or `results.<id>` its request ID, and path selects into its JSON result, e.g. `{{results[0].brokers[0].id}}` or `{{results[1][*].name}}` for a list. "+
+				"A reference also makes the tool depend on the referenced one. An argument that is a single reference keeps the referenced value's type. "+
+				"When the value cannot be selected mechanically, express the argument as a prompt to the LLM using the format `PROMPT_ARGUMENT: your prompt here`. For example: `PROMPT_ARGUMENT: the ID of the created resource.` "+
+				"A request with `forEach`, a list or a reference to a list such as `{{results[0].keys()}}`, runs once per item, with `{{item}}` and `{{index}}` referencing the item and its position. "+
+				"A request with `when` runs only if its condition holds, or for forEach only for the items it holds for. Conditions compare references and literals with ==, !=, <, <=, >, >=, =~ (regular expression match) and !~, joined by && and ||, e.g. `{{item}} =~ ^orders\\.` or `{{results.lag.offsets[*].lag.sum()}} > 1000`. "+
+				"References can end with .len(), .sum(), .min() or .max() to aggregate a list."),
+			mcp.WithNumber("maxConcurrency",
+				mcp.Description(fmt.Sprintf("Maximum number of tools running at once. Defaults to and is capped at %d.", cfg.Multiplex.MaxConcurrency)),
+			),
+			mcp.WithNumber("maxSteps",
+				mcp.Description(fmt.Sprintf("Maximum number of tool calls the batch makes, forEach iterations included. Defaults to and is capped at %d. The call that would exceed it fails and the calls not started are skipped.", cfg.Multiplex.MaxSteps)),
+			),
+			mcp.WithBoolean("failFast",
+				mcp.Description("Stop at the first failed tool: running tools are cancelled and the others skipped. Otherwise only the tools depending on a failed tool are skipped."),
+			),
 			mcp.WithArray("tools",
 				mcp.Description("List of tool requests"),
 				mcp.Required(),
@@ -93,9 +140,21 @@ func MultiplexToolsTool(cfg *Config, multiplexModel string, s *server.MCPServer)
 						},
 						"id": map[string]interface{}{
 							"type":        "number",
-							"description": "the request ID.",
+							"description": "the request ID, unique in the batch.",
 							"required":    true,
 						},
+						"dependsOn": map[string]interface{}{
+							"type":        "array",
+							"description": "IDs of the requests that must succeed before this one runs.",
+							"items":       map[string]interface{}{"type": "number"},
+						},
+						"forEach": map[string]interface{}{
+							"description": "A list, or a reference to a list, of items to run the request for.",
+						},
+						"when": map[string]interface{}{
+							"type":        "string",
+							"description": "A condition the request, or each forEach item, only runs on.",
+						},
 						"method": map[string]interface{}{
 							"type":        "string",
 							"description": "The MCP Method. 'tools/call' in this case.",
@@ -120,16 +179,26 @@ func MultiplexToolsTool(cfg *Config, multiplexModel string, s *server.MCPServer)
 				},
 				)),
 		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			clearToolContext()
-			tools := request.Params.Arguments["tools"].([]interface{})
-			var result []any
-			for _, tool := range tools {
-				payload, _ := json.Marshal(tool)
-				response := s.HandleMessage(ctx, payload)
-				result = append(result, response)
+			tools, ok := request.GetArguments()["tools"].([]any)
+			if !ok {
+				return mcp.NewToolResultError("tools must be a list of tool requests"), nil
+			}
+			concurrency := cfg.Multiplex.MaxConcurrency
+			if n, ok := request.GetArguments()["maxConcurrency"].(float64); ok && n >= 1 && int(n) < concurrency {
+				concurrency = int(n)
+			}
+			maxSteps := cfg.Multiplex.MaxSteps
+			if n, ok := request.GetArguments()["maxSteps"].(float64); ok && n >= 0 && int(n) < maxSteps {
+				maxSteps = int(n)
+			}
+			failFast, _ := request.GetArguments()["failFast"].(bool)
+			executor, err := newMultiplexExecutor(s, tools, max(concurrency, 1), maxSteps, failFast)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
 			}
 
-			jsonResult, _ := json.Marshal(result)
+			ctx = context.WithValue(ctx, batchKey{}, &batch{provider: provider})
+			jsonResult, _ := json.Marshal(executor.run(ctx))
 			return mcp.NewToolResultText(string(jsonResult)), nil
 		}
 }
@@ -0,0 +1,198 @@
+package kafka
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// conditionOperators are the comparison operators of `when` conditions, longest first so that
+// `>=` is not read as `>`.
+var conditionOperators = []string{"==", "!=", ">=", "<=", "=~", "!~", ">", "<"}
+
+// evaluateCondition evaluates the `when` condition of a multiplexed call. A condition is a boolean,
+// or a string of comparisons joined by `&&` and `||` (`&&` binds tighter, there are no
+// parentheses), such as `{{results.lag.offsets[*].lag.sum()}} > 1000` or `{{item}} =~ ^orders\.`.
+// A comparison without operator is true when its value is not empty, zero, false or null.
+//
+// Operands are references, quoted strings, JSON literals or bare words. `==`, `!=`, `<`, `<=`, `>`
+// and `>=` compare numbers when both operands are numbers or numeric strings and text otherwise;
+// `=~` and `!~` match the left operand against the regular expression on the right.
+func evaluateCondition(condition any, scope *referenceScope) (bool, error) {
+	switch condition := condition.(type) {
+	case bool:
+		return condition, nil
+	case string:
+		for _, alternative := range splitCondition(condition, "||") {
+			result := true
+			for _, comparison := range splitCondition(alternative, "&&") {
+				ok, err := evaluateComparison(comparison, scope)
+				if err != nil {
+					return false, fmt.Errorf("invalid condition %q: %w", condition, err)
+				}
+				if !ok {
+					result = false
+					break
+				}
+			}
+			if result {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid condition %v: it must be a boolean or a string", condition)
+}
+
+// splitCondition splits s on separator, ignoring separators inside references and quotes.
+func splitCondition(s, separator string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if skip := skipQuoted(s, i); skip > i {
+			i = skip - 1
+			continue
+		}
+		if strings.HasPrefix(s[i:], separator) {
+			parts = append(parts, s[start:i])
+			start = i + len(separator)
+			i = start - 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// skipQuoted returns the position after the reference or quoted string starting at i, or i when
+// none starts there.
+func skipQuoted(s string, i int) int {
+	switch {
+	case strings.HasPrefix(s[i:], "{{"):
+		if end := strings.Index(s[i:], "}}"); end >= 0 {
+			return i + end + 2
+		}
+	case s[i] == '"' || s[i] == '\'':
+		if end := strings.IndexByte(s[i+1:], s[i]); end >= 0 {
+			return i + end + 2
+		}
+	}
+	return i
+}
+
+func evaluateComparison(comparison string, scope *referenceScope) (bool, error) {
+	left, operator, right := splitComparison(comparison)
+	l, err := evaluateOperand(left, scope)
+	if err != nil {
+		return false, err
+	}
+	if operator == "" {
+		return truthy(l), nil
+	}
+	r, err := evaluateOperand(right, scope)
+	if err != nil {
+		return false, err
+	}
+
+	switch operator {
+	case "=~", "!~":
+		re, err := regexp.Compile(referenceText(r))
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(referenceText(l)) == (operator == "=~"), nil
+	}
+	ln, lok := number(l)
+	rn, rok := number(r)
+	var c int
+	switch {
+	case lok && rok:
+		c = cmp.Compare(ln, rn)
+	case (l == nil) != (r == nil):
+		// null only equals null, not "null"
+		if operator == "==" || operator == "!=" {
+			return operator == "!=", nil
+		}
+		c = strings.Compare(referenceText(l), referenceText(r))
+	default:
+		c = strings.Compare(referenceText(l), referenceText(r))
+	}
+	switch operator {
+	case "==":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	default:
+		return c <= 0, nil
+	}
+}
+
+// splitComparison splits a comparison at its first operator outside references and quotes.
+func splitComparison(comparison string) (left, operator, right string) {
+	for i := 0; i < len(comparison); i++ {
+		if skip := skipQuoted(comparison, i); skip > i {
+			i = skip - 1
+			continue
+		}
+		for _, op := range conditionOperators {
+			if strings.HasPrefix(comparison[i:], op) {
+				return comparison[:i], op, comparison[i+len(op):]
+			}
+		}
+	}
+	return comparison, "", ""
+}
+
+// evaluateOperand returns the value of an operand: a reference keeps the referenced value's type,
+// a quoted string is unquoted, JSON literals are decoded and other text has its references replaced.
+func evaluateOperand(operand string, scope *referenceScope) (any, error) {
+	operand = strings.TrimSpace(operand)
+	if operand == "" {
+		return nil, fmt.Errorf("missing operand")
+	}
+	if n := len(operand); n >= 2 && (operand[0] == '"' || operand[0] == '\'') && operand[n-1] == operand[0] {
+		return operand[1 : n-1], nil
+	}
+	var literal any
+	if err := json.Unmarshal([]byte(operand), &literal); err == nil {
+		return literal, nil
+	}
+	return resolveReferences(operand, scope)
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	return true
+}
+
+// number returns v as a number when it is one or is a numeric string.
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
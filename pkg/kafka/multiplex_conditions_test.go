@@ -0,0 +1,58 @@
+package kafka
+
+import "testing"
+
+func TestEvaluateCondition(t *testing.T) {
+	scope := testScope(t).forItem("orders.eu", 0)
+	tests := []struct {
+		condition any
+		want      bool
+	}{
+		{true, true},
+		{false, false},
+		{"{{results.lag.offsets[*].lag.sum()}} > 10", true},
+		{"{{results.lag.offsets[*].lag.sum()}} > 100", false},
+		{"{{results.lag.offsets[*].lag.sum()}} >= 17", true},
+		{"{{results.lag.offsets[*].lag.sum()}} <= 16", false},
+		{"{{results.lag.group}} == billing", true},
+		{"{{results.lag.group}} == 'billing'", true},
+		{`{{results.lag.group}} != "billing"`, false},
+		{"'10' > 9", true},
+		{"'b' > 'a'", true},
+		{"{{item}} =~ ^orders\\.", true},
+		{"{{item}} !~ ^orders\\.", false},
+		{"{{results.lag.offsets[*].missing}}", false},
+		{"{{results.lag.offsets}}", true},
+		{"null == null", true},
+		{"null == 'null'", false},
+		{"null != 'null'", true},
+		{"{{results.lag.group}} == other || {{item}} == orders.eu", true},
+		{"{{results.lag.group}} == billing && {{item}} == orders.us", false},
+		{"1 == 2 && 1 == 1 || 2 == 2", true},
+		{"'a||b' == 'a||b'", true},
+	}
+	for _, tt := range tests {
+		got, err := evaluateCondition(tt.condition, scope)
+		if err != nil {
+			t.Errorf("evaluateCondition(%v) error = %v", tt.condition, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("evaluateCondition(%v) = %v, want %v", tt.condition, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateConditionErrors(t *testing.T) {
+	scope := testScope(t)
+	for _, condition := range []any{
+		1.0,
+		"{{results.missing}} > 1",
+		"{{results.lag.group}} ==",
+		"{{results.lag.group}} =~ (",
+	} {
+		if _, err := evaluateCondition(condition, scope); err == nil {
+			t.Errorf("evaluateCondition(%v) succeeded, want an error", condition)
+		}
+	}
+}
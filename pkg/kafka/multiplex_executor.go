@@ -0,0 +1,443 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// MultiplexConfig bounds the execution of MultiplexTools batches.
+type MultiplexConfig struct {
+	// MaxConcurrency is the default and maximum number of calls of a batch running at once.
+	MaxConcurrency int
+	// MaxSteps is the default and maximum number of tool calls a batch makes, forEach iterations
+	// included.
+	MaxSteps int
+}
+
+// Status of a multiplexed call.
+const (
+	CallOK      = "ok"
+	CallError   = "error"
+	CallSkipped = "skipped"
+)
+
+// errMaxSteps fails the call that would exceed the batch's maxSteps. The calls not started yet are
+// skipped.
+var errMaxSteps = errors.New("the batch reached its maxSteps")
+
+// CallOutcome is the result of a multiplexed call, keyed by the call's ID in the MultiplexTools result.
+type CallOutcome struct {
+	// Index is the position of the call in the batch.
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	// Error is the reason a call was skipped or could not run.
+	Error    string `json:"error,omitempty"`
+	Response any    `json:"response,omitempty"`
+	// Iterations are the calls made by a forEach call, for the items meeting its condition.
+	Iterations []*IterationOutcome `json:"iterations,omitempty"`
+	// StartMs is the time the call started at, relative to the start of the batch.
+	StartMs    int64 `json:"startMs"`
+	DurationMs int64 `json:"durationMs"`
+}
+
+// IterationOutcome is the result of a forEach call for one item.
+type IterationOutcome struct {
+	Item       any    `json:"item"`
+	Status     string `json:"status"`
+	Response   any    `json:"response"`
+	StartMs    int64  `json:"startMs"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// multiplexCall is a call of a batch and the calls it waits for.
+type multiplexCall struct {
+	index   int
+	key     string
+	request map[string]any
+	// forEach resolves to the list of items the call runs for, when set.
+	forEach any
+	// when is the condition the call, or each forEach item, runs on, when set.
+	when any
+	// dependsOn are the calls that must succeed before this one runs: the `dependsOn` IDs and the
+	// calls its references point to.
+	dependsOn []int
+	// after are the calls that must complete before this one runs, successfully or not.
+	after []int
+}
+
+// multiplexExecutor runs the calls of a batch as a DAG.
+type multiplexExecutor struct {
+	s        *server.MCPServer
+	calls    []*multiplexCall
+	results  *batchResults
+	failFast bool
+	// params are the arguments of the recipe running the batch, if any.
+	params map[string]any
+	begin  time.Time
+
+	// slots bounds the tool calls running at once, forEach iterations included.
+	slots chan struct{}
+
+	mu       sync.Mutex
+	steps    int
+	maxSteps int
+}
+
+// newMultiplexExecutor builds the DAG of a batch. When no call declares `dependsOn`, each call
+// runs after the previous one, as batches always did; otherwise calls run as soon as their
+// dependencies succeed. References to other calls' results add implicit dependencies in both cases.
+func newMultiplexExecutor(s *server.MCPServer, tools []any, concurrency, maxSteps int, failFast bool) (*multiplexExecutor, error) {
+	e := &multiplexExecutor{
+		s:        s,
+		results:  &batchResults{results: make([]callResult, len(tools)), ids: map[string]int{}},
+		failFast: failFast,
+		slots:    make(chan struct{}, concurrency),
+		maxSteps: maxSteps,
+	}
+	sequential := true
+	for i, tool := range tools {
+		request, ok := tool.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("call %d is not an object", i)
+		}
+		key := fmt.Sprint(i)
+		if id, ok := request["id"]; ok && id != nil {
+			key = fmt.Sprint(id)
+		}
+		if _, ok := e.results.ids[key]; ok {
+			return nil, fmt.Errorf("duplicate call ID %s", key)
+		}
+		e.results.ids[key] = i
+		if _, ok := request["dependsOn"]; ok {
+			sequential = false
+		}
+		e.calls = append(e.calls, &multiplexCall{index: i, key: key, request: request, forEach: request["forEach"], when: request["when"]})
+	}
+
+	for i, call := range e.calls {
+		dependsOn, _ := call.request["dependsOn"].([]any)
+		for _, id := range dependsOn {
+			dep, ok := e.results.ids[fmt.Sprint(id)]
+			if !ok {
+				return nil, fmt.Errorf("call %s depends on unknown call %v", call.key, id)
+			}
+			call.dependsOn = append(call.dependsOn, dep)
+		}
+		// these are not part of the JSON-RPC request
+		delete(call.request, "dependsOn")
+		delete(call.request, "forEach")
+		delete(call.request, "when")
+		if params, ok := call.request["params"].(map[string]any); ok {
+			call.dependsOn = append(call.dependsOn, referencedCalls(params["arguments"], e.results, i)...)
+		}
+		call.dependsOn = append(call.dependsOn, referencedCalls(call.forEach, e.results, i)...)
+		call.dependsOn = append(call.dependsOn, referencedCalls(call.when, e.results, i)...)
+		if sequential && i > 0 {
+			call.after = []int{i - 1}
+		}
+		if slices.Contains(call.dependsOn, i) {
+			return nil, fmt.Errorf("call %s depends on itself", call.key)
+		}
+	}
+	if cycle := e.cycle(); cycle != "" {
+		return nil, fmt.Errorf("dependency cycle: %s", cycle)
+	}
+	return e, nil
+}
+
+// cycle returns the calls of a dependency cycle, or "" when there is none.
+func (e *multiplexExecutor) cycle() string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(e.calls))
+	var path []string
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		switch state[i] {
+		case visiting:
+			path = append(path, e.calls[i].key)
+			return true
+		case visited:
+			return false
+		}
+		state[i] = visiting
+		path = append(path, e.calls[i].key)
+		for _, dep := range append(slices.Clone(e.calls[i].dependsOn), e.calls[i].after...) {
+			if visit(dep) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return false
+	}
+	for i := range e.calls {
+		if visit(i) {
+			return fmt.Sprint(path)
+		}
+	}
+	return ""
+}
+
+type callDone struct {
+	index   int
+	outcome *CallOutcome
+	err     error
+}
+
+// run executes the calls and returns their outcomes by ID. The calls still running when ctx is done
+// are cancelled, and the calls not started are skipped.
+func (e *multiplexExecutor) run(ctx context.Context) map[string]*CallOutcome {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	progress := progressFromContext(ctx)
+	e.begin = time.Now()
+
+	outcomes := map[string]*CallOutcome{}
+	pending := map[int]bool{}
+	for i := range e.calls {
+		pending[i] = true
+	}
+	done := make(chan callDone)
+	running := 0
+	var stopReason string
+	finish := func(d callDone) {
+		running--
+		d.outcome.Index = d.index
+		outcomes[e.calls[d.index].key] = d.outcome
+		progress(float64(len(outcomes)), float64(len(e.calls)))
+	}
+
+	for len(outcomes) < len(e.calls) {
+		for _, call := range e.calls {
+			if !pending[call.index] {
+				continue
+			}
+			skip, ready := stopReason, true
+			for _, dep := range call.dependsOn {
+				switch outcome := outcomes[e.calls[dep].key]; {
+				case outcome == nil:
+					ready = false
+				case outcome.Status != CallOK && skip == "":
+					skip = fmt.Sprintf("dependency %s did not succeed", e.calls[dep].key)
+				}
+			}
+			for _, dep := range call.after {
+				if outcomes[e.calls[dep].key] == nil {
+					ready = false
+				}
+			}
+			if skip == "" && !ready {
+				continue
+			}
+			delete(pending, call.index)
+			if skip != "" {
+				e.results.results[call.index] = callResult{err: errors.New(skip)}
+				outcomes[call.key] = &CallOutcome{Index: call.index, Status: CallSkipped, Error: skip}
+				progress(float64(len(outcomes)), float64(len(e.calls)))
+				continue
+			}
+			running++
+			go func(call *multiplexCall) {
+				outcome, result := e.execute(ctx, call)
+				e.results.results[call.index] = result
+				done <- callDone{call.index, outcome, result.err}
+			}(call)
+		}
+		if running == 0 {
+			// the calls left were skipped, or are ready to start on the next pass
+			continue
+		}
+
+		select {
+		case d := <-done:
+			finish(d)
+			switch {
+			case stopReason != "":
+			case errors.Is(d.err, errMaxSteps):
+				stopReason = errMaxSteps.Error()
+			case e.failFast && d.outcome.Status == CallError:
+				stopReason = fmt.Sprintf("call %s failed", e.calls[d.index].key)
+				cancel()
+			}
+		case <-ctx.Done():
+			if stopReason == "" {
+				stopReason = partialReason(ctx.Err(), "the batch was interrupted")
+			}
+			// the running calls return promptly once cancelled
+			for running > 0 {
+				finish(<-done)
+			}
+		}
+	}
+	return outcomes
+}
+
+// execute runs a call, once or for each of its items, and returns its outcome and the result later
+// calls can reference. A forEach call's result is the list of the results of its successful
+// iterations.
+func (e *multiplexExecutor) execute(ctx context.Context, call *multiplexCall) (*CallOutcome, callResult) {
+	start := time.Now()
+	scope := &referenceScope{results: e.results, current: call.index, params: e.params}
+	outcome := &CallOutcome{StartMs: start.Sub(e.begin).Milliseconds()}
+	fail := func(status string, err error) (*CallOutcome, callResult) {
+		outcome.Status, outcome.Error = status, err.Error()
+		outcome.DurationMs = time.Since(start).Milliseconds()
+		return outcome, callResult{err: err}
+	}
+
+	if call.forEach == nil {
+		if call.when != nil {
+			ok, err := evaluateCondition(call.when, scope)
+			if err != nil {
+				return fail(CallError, err)
+			}
+			if !ok {
+				return fail(CallSkipped, errors.New("condition not met"))
+			}
+		}
+		if err := e.takeSteps(1); err != nil {
+			return fail(CallError, err)
+		}
+		response, result := e.invoke(ctx, call, scope)
+		outcome.Status, outcome.Response = CallOK, response
+		if result.err != nil {
+			outcome.Status = CallError
+		}
+		outcome.DurationMs = time.Since(start).Milliseconds()
+		return outcome, result
+	}
+
+	items, err := resolveReferences(call.forEach, scope)
+	if err != nil {
+		return fail(CallError, err)
+	}
+	list, ok := items.([]any)
+	if !ok {
+		return fail(CallError, fmt.Errorf("forEach must be a list or a reference to a list, not %T", items))
+	}
+	// the index of an item is its position in the forEach list, not among the items meeting the condition
+	var indices []int
+	for i, item := range list {
+		if call.when != nil {
+			ok, err := evaluateCondition(call.when, scope.forItem(item, i))
+			if err != nil {
+				return fail(CallError, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		indices = append(indices, i)
+	}
+	if err := e.takeSteps(len(indices)); err != nil {
+		return fail(CallError, err)
+	}
+	for _, i := range indices {
+		outcome.Iterations = append(outcome.Iterations, &IterationOutcome{Item: list[i]})
+	}
+
+	results := make([]callResult, len(indices))
+	var wg sync.WaitGroup
+	for i, iteration := range outcome.Iterations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			iterationStart := time.Now()
+			iteration.Response, results[i] = e.invoke(ctx, call, scope.forItem(iteration.Item, indices[i]))
+			iteration.Status = CallOK
+			if results[i].err != nil {
+				iteration.Status = CallError
+			}
+			iteration.StartMs = iterationStart.Sub(e.begin).Milliseconds()
+			iteration.DurationMs = time.Since(iterationStart).Milliseconds()
+		}()
+	}
+	wg.Wait()
+
+	outcome.Status = CallOK
+	values := []any{}
+	var errs []error
+	for i, result := range results {
+		if result.err != nil {
+			errs = append(errs, fmt.Errorf("item %d: %w", indices[i], result.err))
+			continue
+		}
+		values = append(values, result.value)
+	}
+	if len(errs) > 0 {
+		outcome.Status = CallError
+	}
+	outcome.DurationMs = time.Since(start).Milliseconds()
+	return outcome, callResult{value: values, err: errors.Join(errs...)}
+}
+
+// takeSteps reserves n tool calls of the batch's maxSteps.
+func (e *multiplexExecutor) takeSteps(n int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.steps+n > e.maxSteps {
+		return fmt.Errorf("%w of %d: the call needs %d more tool calls, %d are left", errMaxSteps, e.maxSteps, n, e.maxSteps-e.steps)
+	}
+	e.steps += n
+	return nil
+}
+
+// invoke makes one tool call of a multiplexed call, waiting for a free slot.
+func (e *multiplexExecutor) invoke(ctx context.Context, call *multiplexCall, scope *referenceScope) (mcp.JSONRPCMessage, callResult) {
+	select {
+	case e.slots <- struct{}{}:
+		defer func() { <-e.slots }()
+	case <-ctx.Done():
+		response := mcp.NewJSONRPCError(mcp.NewRequestId(call.request["id"]), mcp.INTERNAL_ERROR, ctx.Err().Error(), nil)
+		return response, newCallResult(response)
+	}
+	response := handleMultiplexedCall(ctx, e.s, call.request, scope)
+	return response, newCallResult(response)
+}
+
+// handleMultiplexedCall resolves the references in the call's arguments and handles the call.
+// A reference that cannot be resolved fails the call with an invalid params error, and a panic,
+// typically a tool receiving an argument of the wrong type, fails the call only.
+func handleMultiplexedCall(ctx context.Context, s *server.MCPServer, call map[string]any, scope *referenceScope) (response mcp.JSONRPCMessage) {
+	id := mcp.NewRequestId(call["id"])
+	// forEach iterations share the call, so the resolved arguments go to a copy
+	request := maps.Clone(call)
+	if params, ok := call["params"].(map[string]any); ok && params["arguments"] != nil {
+		arguments, err := resolveReferences(params["arguments"], scope)
+		if err != nil {
+			return mcp.NewJSONRPCError(id, mcp.INVALID_PARAMS, err.Error(), nil)
+		}
+		params = maps.Clone(params)
+		params["arguments"] = arguments
+		request["params"] = params
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			response = mcp.NewJSONRPCError(id, mcp.INTERNAL_ERROR, fmt.Sprintf("internal panic: %v", r), nil)
+		}
+	}()
+
+	payload, _ := json.Marshal(request)
+	attributes := []attribute.KeyValue{attribute.Int("multiplex.index", scope.current)}
+	if scope.iterating {
+		attributes = append(attributes, attribute.Int("multiplex.item", scope.index))
+	}
+	callCtx, span := tracing.Start(ctx, "multiplex call", attributes...)
+	defer span.End()
+	return s.HandleMessage(callCtx, payload)
+}
@@ -0,0 +1,173 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/llm"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// newTestMultiplexServer returns a server with the PROMPT_ARGUMENT hooks and two tools: topics,
+// returning two topics, and echo, returning its arguments.
+func newTestMultiplexServer() *server.MCPServer {
+	hooks := &server.Hooks{}
+	hooks.OnBeforeCallTool = append(hooks.OnBeforeCallTool, BeforeToolCallPromptArgumentHook)
+	hooks.OnAfterCallTool = append(hooks.OnAfterCallTool, AfterToolCallPromptArgumentHook)
+	s := server.NewMCPServer("test", "1", server.WithToolCapabilities(false), server.WithHooks(hooks))
+	s.AddTool(mcp.NewTool("topics"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(`{"orders":{"partitions":3},"payments":{"partitions":1}}`), nil
+	})
+	s.AddTool(mcp.NewTool("echo"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args, _ := json.Marshal(request.GetArguments())
+		return mcp.NewToolResultText(string(args)), nil
+	})
+	return s
+}
+
+func toolCall(id any, name string, arguments map[string]any, extra map[string]any) map[string]any {
+	call := map[string]any{"jsonrpc": "2.0", "id": id, "method": "tools/call", "params": map[string]any{"name": name, "arguments": arguments}}
+	for k, v := range extra {
+		call[k] = v
+	}
+	return call
+}
+
+type testOutcome struct {
+	Status     string
+	Error      string
+	Iterations []struct{ Item any }
+	Response   struct {
+		Result struct {
+			Content []struct{ Text string }
+		}
+	}
+}
+
+// runMultiplex runs a batch through MultiplexTools and returns the outcomes by call ID.
+func runMultiplex(t *testing.T, provider llm.Provider, args map[string]any) map[string]testOutcome {
+	t.Helper()
+	cfg := &Config{Multiplex: MultiplexConfig{MaxConcurrency: 4, MaxSteps: 10}}
+	_, handler := MultiplexToolsTool(cfg, provider, newTestMultiplexServer())
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = args
+	result, err := handler(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if result.IsError {
+		t.Fatalf("MultiplexTools failed: %s", text)
+	}
+	var outcomes map[string]testOutcome
+	if err := json.Unmarshal([]byte(text), &outcomes); err != nil {
+		t.Fatalf("invalid result %s: %v", text, err)
+	}
+	return outcomes
+}
+
+func responseText(o testOutcome) string {
+	if len(o.Response.Result.Content) == 0 {
+		return ""
+	}
+	return o.Response.Result.Content[0].Text
+}
+
+func TestMultiplexPromptArgument(t *testing.T) {
+	fake := llm.NewFake("orders")
+	outcomes := runMultiplex(t, fake, map[string]any{"tools": []any{
+		toolCall(1, "topics", map[string]any{}, nil),
+		toolCall(2, "echo", map[string]any{"name": "PROMPT_ARGUMENT: the topic with the most partitions", "limit": 5.0}, nil),
+	}})
+
+	if got, want := responseText(outcomes["2"]), `{"limit":5,"name":"orders"}`; got != want {
+		t.Errorf("echo response = %s, want %s", got, want)
+	}
+	requests := fake.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("the provider got %d requests, want 1", len(requests))
+	}
+	for _, want := range []string{"the topic with the most partitions", `\"payments\":{\"partitions\":1}`} {
+		if !strings.Contains(requests[0].Prompt, want) {
+			t.Errorf("the prompt does not contain %s:\n%s", want, requests[0].Prompt)
+		}
+	}
+}
+
+func TestMultiplexFailures(t *testing.T) {
+	outcomes := runMultiplex(t, llm.NewFake(), map[string]any{"tools": []any{
+		toolCall(1, "missing", map[string]any{}, nil),
+		toolCall(2, "echo", map[string]any{}, map[string]any{"dependsOn": []any{1.0}}),
+		toolCall(3, "echo", map[string]any{"x": "{{results[0].x}}"}, map[string]any{"dependsOn": []any{}}),
+		toolCall(4, "topics", map[string]any{}, map[string]any{"dependsOn": []any{}}),
+	}})
+
+	want := map[string]string{"1": CallError, "2": CallSkipped, "3": CallSkipped, "4": CallOK}
+	for id, status := range want {
+		if got := outcomes[id].Status; got != status {
+			t.Errorf("call %s status = %s (%s), want %s", id, got, outcomes[id].Error, status)
+		}
+	}
+}
+
+func TestNewMultiplexExecutorErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		tools []any
+	}{
+		{"not an object", []any{"topics"}},
+		{"duplicate ID", []any{toolCall(1, "topics", nil, nil), toolCall(1, "topics", nil, nil)}},
+		{"unknown dependency", []any{toolCall(1, "topics", nil, map[string]any{"dependsOn": []any{2.0}})}},
+		{"self reference", []any{toolCall(1, "echo", map[string]any{"x": "{{results[0]}}"}, nil)}},
+		{"cycle", []any{
+			toolCall(1, "echo", map[string]any{"x": "{{results[1]}}"}, map[string]any{"dependsOn": []any{}}),
+			toolCall(2, "echo", map[string]any{"x": "{{results[0]}}"}, nil),
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := newMultiplexExecutor(nil, tt.tools, 1, 10, false); err == nil {
+			t.Errorf("%s: newMultiplexExecutor() succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestMultiplexForEach(t *testing.T) {
+	outcomes := runMultiplex(t, llm.NewFake(), map[string]any{"tools": []any{
+		toolCall("topics", "topics", map[string]any{}, nil),
+		toolCall("each", "echo", map[string]any{"name": "{{item}}", "index": "{{index}}"}, map[string]any{
+			"forEach": "{{results.topics.keys()}}",
+			"when":    "{{item}} =~ ^ord",
+		}),
+		toolCall("total", "echo", map[string]any{"partitions": "{{results.topics[*].partitions.sum()}}"}, nil),
+		toolCall("skipped", "echo", map[string]any{}, map[string]any{"when": "{{results.topics.orders.partitions}} > 5"}),
+	}})
+
+	each := outcomes["each"]
+	if each.Status != CallOK || len(each.Iterations) != 1 || each.Iterations[0].Item != "orders" {
+		t.Errorf("forEach outcome = %+v, want one iteration for orders", each)
+	}
+	if got, want := responseText(outcomes["total"]), `{"partitions":4}`; got != want {
+		t.Errorf("total response = %s, want %s", got, want)
+	}
+	if got := outcomes["skipped"].Status; got != CallSkipped {
+		t.Errorf("skipped status = %s, want %s", got, CallSkipped)
+	}
+}
+
+func TestMultiplexMaxSteps(t *testing.T) {
+	outcomes := runMultiplex(t, llm.NewFake(), map[string]any{"maxSteps": 2.0, "tools": []any{
+		toolCall(1, "topics", map[string]any{}, nil),
+		toolCall(2, "echo", map[string]any{"name": "{{item}}"}, map[string]any{"forEach": []any{"a", "b"}}),
+		toolCall(3, "echo", map[string]any{}, nil),
+	}})
+
+	want := map[string]string{"1": CallOK, "2": CallError, "3": CallSkipped}
+	for id, status := range want {
+		if got := outcomes[id].Status; got != status {
+			t.Errorf("call %s status = %s (%s), want %s", id, got, outcomes[id].Error, status)
+		}
+	}
+}
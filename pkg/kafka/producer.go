@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
 	"github.com/IBM/sarama"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func ProducerMessagesTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
@@ -22,18 +25,34 @@ func ProducerMessagesTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandle
 				mcp.Required(),
 				mcp.Description("List of messages to produce."),
 			),
+			mcp.WithBoolean("propagateTraceContext",
+				mcp.Description("When true, the W3C trace context of this call is added to each message as `traceparent`/`tracestate` headers."),
+			),
+			withCluster(),
 		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 
-			topic := request.Params.Arguments["name"].(string)
-			messages := request.Params.Arguments["messages"].([]any)
+			topic := request.GetArguments()["name"].(string)
+			messages := request.GetArguments()["messages"].([]any)
+			propagateTraceContext, _ := request.GetArguments()["propagateTraceContext"].(bool)
+			if err := cfg.checkTopic(topic); err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
 
-			config := sarama.NewConfig()
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			if cluster.ReadOnly {
+				err = fmt.Errorf("cluster %s is read-only", cluster.Name)
+				return mcp.NewToolResultError(err.Error()), err
+			}
 			config.Producer.Return.Successes = true
-			producer, err := sarama.NewSyncProducer(cfg.BootstrapServers, config)
+			producer, err := sarama.NewSyncProducer(cluster.BootstrapServers, config)
 			if err != nil {
 				err = fmt.Errorf("Failed to start Sarama producer: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
+			defer metrics.TrackKafkaClient(cluster.Name, "producer")()
 			defer func() {
 				if err := producer.Close(); err != nil {
 					log.Println("Failed to close Kafka producer cleanly:", err)
@@ -42,16 +61,31 @@ func ProducerMessagesTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandle
 
 			partitionOffsets := []MessagePartitionOffset{}
 			for _, m := range messages {
+				if ctx.Err() != nil {
+					partitionOffsetsJson, _ := json.Marshal(partitionOffsets)
+					result := fmt.Sprintf("MessagePartitionOffset tuples of produced messages: %v", string(partitionOffsetsJson))
+					return newPartialToolResult([]byte(result), ctx.Err(), fmt.Sprintf("%d of %d messages sent", len(partitionOffsets), len(messages))), nil
+				}
 				msg := &sarama.ProducerMessage{
 					Topic: topic,
 					Value: sarama.StringEncoder(m.(string)),
 				}
 
+				spanCtx, span := startKafkaSpan(ctx, cluster, "SendMessage", attribute.String("messaging.destination.name", topic))
+				if propagateTraceContext {
+					headers := map[string]string{}
+					tracing.Inject(spanCtx, headers)
+					for k, v := range headers {
+						msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+					}
+				}
 				partition, offset, err := producer.SendMessage(msg)
+				tracing.End(span, err)
 				partitionOffsets = append(partitionOffsets, MessagePartitionOffset{Partition: int(partition), Offset: int(offset)})
 				if err != nil {
 					log.Printf("Failed to send message: %v", err)
 				} else {
+					metrics.ObserveMessage(cluster.Name, metrics.Produced, msg.Value.Length())
 					log.Printf("Message sent to partition %d at offset %d\n", partition, offset)
 				}
 			}
@@ -0,0 +1,561 @@
+package kafka
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
+	"github.com/IBM/sarama"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	defaultProfileMessages = 100
+	maxProfileMessages     = 10000
+	// maxProfileFields bounds the number of fields of the inferred schema.
+	maxProfileFields = 500
+)
+
+// Formats of the message values profileTopic detects.
+const (
+	formatJSON     = "json"
+	formatAvro     = "avro"
+	formatProtobuf = "protobuf"
+	formatText     = "text"
+	formatBinary   = "binary"
+	formatEmpty    = "empty"
+)
+
+// ProfileResult describes the messages of a sample of a topic.
+type ProfileResult struct {
+	Messages   int                 `json:"messages"`
+	Partitions []ProfiledPartition `json:"partitions"`
+	// Format is the most common format of the values that are not null, and Formats counts the
+	// values of each format.
+	Format  string         `json:"format,omitempty"`
+	Formats map[string]int `json:"formats,omitempty"`
+	// SchemaIDs are the Schema Registry IDs of the values in the Schema Registry wire format.
+	SchemaIDs []uint32 `json:"schemaIds,omitempty"`
+	// Schema is inferred from the JSON values. SchemaTruncated is set when it has more fields than
+	// are reported.
+	Schema          *FieldProfile `json:"schema,omitempty"`
+	SchemaTruncated bool          `json:"schemaTruncated,omitempty"`
+	Keys            KeyProfile    `json:"keys"`
+	KeySize         *SizeProfile  `json:"keySize,omitempty"`
+	ValueSize       *SizeProfile  `json:"valueSize,omitempty"`
+	// Tombstones counts the messages with a null value.
+	Tombstones     int     `json:"tombstones"`
+	TombstoneRatio float64 `json:"tombstoneRatio"`
+	// Headers counts the messages carrying each header key.
+	Headers map[string]int `json:"headers,omitempty"`
+	// TimestampType is the topic's message.timestamp.type: CreateTime or LogAppendTime.
+	TimestampType   string `json:"timestampType,omitempty"`
+	OldestTimestamp string `json:"oldestTimestamp,omitempty"`
+	NewestTimestamp string `json:"newestTimestamp,omitempty"`
+	// MissingTimestamps counts the messages without a timestamp.
+	MissingTimestamps int `json:"missingTimestamps,omitempty"`
+}
+
+// ProfiledPartition is the range of a partition a profile samples.
+type ProfiledPartition struct {
+	Partition   int32 `json:"partition"`
+	StartOffset int64 `json:"startOffset"`
+	EndOffset   int64 `json:"endOffset"`
+	Sampled     int   `json:"sampled"`
+}
+
+// KeyProfile describes the keys of a sample.
+type KeyProfile struct {
+	Null     int `json:"null"`
+	Distinct int `json:"distinct"`
+	// UniqueRatio is the ratio of distinct keys to keys that are not null: 1 when no key repeats.
+	UniqueRatio float64 `json:"uniqueRatio"`
+	// EstimatedDistinct extrapolates the number of distinct keys of the topic from how often the keys
+	// of the sample repeat, with the Chao1 estimator. It assumes the sample is representative.
+	EstimatedDistinct int `json:"estimatedDistinct"`
+}
+
+// SizeProfile holds statistics of sizes in bytes.
+type SizeProfile struct {
+	Min int     `json:"min"`
+	P50 int     `json:"p50"`
+	P90 int     `json:"p90"`
+	P99 int     `json:"p99"`
+	Max int     `json:"max"`
+	Avg float64 `json:"avg"`
+}
+
+// FieldProfile describes a field of the JSON values in the manner of a JSON Schema.
+type FieldProfile struct {
+	// Type is the JSON Schema type of the field's values, or the list of their types, the most
+	// common first.
+	Type any `json:"type"`
+	// Presence is the fraction of the objects holding the field that have it. For the schema itself,
+	// it is the fraction of the messages with a JSON value.
+	Presence   float64                  `json:"presence"`
+	Properties map[string]*FieldProfile `json:"properties,omitempty"`
+	Items      *FieldProfile            `json:"items,omitempty"`
+
+	count int
+	types map[string]int
+	// objects and elements count the objects and array elements among the values, of which the
+	// presence of the properties and items is a fraction.
+	objects  int
+	elements int
+}
+
+// topicProfiler accumulates the profile of the messages of a sample.
+type topicProfiler struct {
+	result     *ProfileResult
+	cfg        *Config
+	keys       map[string]int
+	keySizes   []int
+	valueSizes []int
+	schemaIDs  map[uint32]bool
+	fields     int
+	oldest     time.Time
+	newest     time.Time
+}
+
+func newTopicProfiler(cfg *Config) *topicProfiler {
+	return &topicProfiler{
+		result:    &ProfileResult{Formats: map[string]int{}, Headers: map[string]int{}},
+		cfg:       cfg,
+		keys:      map[string]int{},
+		schemaIDs: map[uint32]bool{},
+	}
+}
+
+func (p *topicProfiler) add(message *sarama.ConsumerMessage) {
+	r := p.result
+	r.Messages++
+
+	if message.Key == nil {
+		r.Keys.Null++
+	} else {
+		p.keys[string(message.Key)]++
+		p.keySizes = append(p.keySizes, len(message.Key))
+	}
+
+	for _, h := range message.Headers {
+		// dropped headers are not reported, not even their key
+		if !p.cfg.Redactor.DropHeader(message.Topic, string(h.Key)) {
+			r.Headers[string(h.Key)]++
+		}
+	}
+
+	if message.Timestamp.UnixMilli() <= 0 {
+		r.MissingTimestamps++
+	} else {
+		if p.oldest.IsZero() || message.Timestamp.Before(p.oldest) {
+			p.oldest = message.Timestamp
+		}
+		if message.Timestamp.After(p.newest) {
+			p.newest = message.Timestamp
+		}
+	}
+
+	if message.Value == nil {
+		r.Tombstones++
+		return
+	}
+	p.valueSizes = append(p.valueSizes, len(message.Value))
+	format := detectFormat(message.Value)
+	r.Formats[format]++
+	payload := message.Value
+	if len(payload) > 5 && payload[0] == 0 {
+		p.schemaIDs[binary.BigEndian.Uint32(payload[1:5])] = true
+		payload = payload[5:]
+	}
+	if format == formatJSON {
+		var v any
+		if err := json.Unmarshal(payload, &v); err == nil {
+			if r.Schema == nil {
+				r.Schema = &FieldProfile{}
+			}
+			p.addField(r.Schema, v)
+		}
+	}
+}
+
+func (p *topicProfiler) addField(f *FieldProfile, v any) {
+	f.count++
+	if f.types == nil {
+		f.types = map[string]int{}
+	}
+	f.types[jsonType(v)]++
+	switch v := v.(type) {
+	case map[string]any:
+		f.objects++
+		for name, value := range v {
+			property, ok := f.Properties[name]
+			if !ok {
+				if p.fields >= maxProfileFields {
+					p.result.SchemaTruncated = true
+					continue
+				}
+				p.fields++
+				if f.Properties == nil {
+					f.Properties = map[string]*FieldProfile{}
+				}
+				property = &FieldProfile{}
+				f.Properties[name] = property
+			}
+			p.addField(property, value)
+		}
+	case []any:
+		for _, item := range v {
+			if f.Items == nil {
+				f.Items = &FieldProfile{}
+			}
+			f.elements++
+			p.addField(f.Items, item)
+		}
+	}
+}
+
+// finish sets the type and presence of a field and its descendants, of total values of its parent.
+func (f *FieldProfile) finish(total int) {
+	f.Presence = ratio(f.count, total)
+	types := slices.SortedFunc(maps.Keys(f.types), func(a, b string) int {
+		if c := cmp.Compare(f.types[b], f.types[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	f.Type = types
+	if len(types) == 1 {
+		f.Type = types[0]
+	}
+	for _, property := range f.Properties {
+		property.finish(f.objects)
+	}
+	if f.Items != nil {
+		f.Items.finish(f.elements)
+	}
+}
+
+func (p *topicProfiler) finish() *ProfileResult {
+	r := p.result
+	for format, n := range r.Formats {
+		if n > r.Formats[r.Format] || n == r.Formats[r.Format] && format < r.Format {
+			r.Format = format
+		}
+	}
+	r.SchemaIDs = slices.Sorted(maps.Keys(p.schemaIDs))
+	if r.Schema != nil {
+		r.Schema.finish(r.Messages)
+	}
+
+	r.Keys.Distinct = len(p.keys)
+	r.Keys.UniqueRatio = ratio(len(p.keys), r.Messages-r.Keys.Null)
+	// Chao1: the keys seen once and twice hint at the keys not seen
+	var once, twice int
+	for _, n := range p.keys {
+		switch n {
+		case 1:
+			once++
+		case 2:
+			twice++
+		}
+	}
+	r.Keys.EstimatedDistinct = len(p.keys) + once*(once-1)/(2*(twice+1))
+	r.KeySize = sizeProfile(p.keySizes)
+	r.ValueSize = sizeProfile(p.valueSizes)
+	r.TombstoneRatio = ratio(r.Tombstones, r.Messages)
+
+	if !p.oldest.IsZero() {
+		r.OldestTimestamp = p.oldest.UTC().Format(time.RFC3339Nano)
+		r.NewestTimestamp = p.newest.UTC().Format(time.RFC3339Nano)
+	}
+	return r
+}
+
+// ratio returns n/total rounded to 3 decimals, or 0 when total is 0.
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(total)*1000) / 1000
+}
+
+func sizeProfile(sizes []int) *SizeProfile {
+	if len(sizes) == 0 {
+		return nil
+	}
+	slices.Sort(sizes)
+	// nearest-rank percentiles
+	percentile := func(p float64) int {
+		return sizes[int(math.Ceil(p/100*float64(len(sizes))))-1]
+	}
+	sum := 0
+	for _, s := range sizes {
+		sum += s
+	}
+	return &SizeProfile{
+		Min: sizes[0],
+		P50: percentile(50),
+		P90: percentile(90),
+		P99: percentile(99),
+		Max: sizes[len(sizes)-1],
+		Avg: math.Round(float64(sum)/float64(len(sizes))*10) / 10,
+	}
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+// detectFormat guesses the serialization format of a message value from its bytes.
+func detectFormat(value []byte) string {
+	trimmed := bytes.TrimSpace(value)
+	switch {
+	case len(value) == 0:
+		return formatEmpty
+	case len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed):
+		return formatJSON
+	case len(value) > 5 && value[0] == 0:
+		// the Schema Registry wire format: a zero magic byte and a 4-byte schema ID, followed for
+		// Protobuf by the indexes of the message type in the schema
+		if format := detectFormat(value[5:]); format == formatJSON {
+			return format
+		}
+		if indexes, n := binary.Varint(value[5:]); n > 0 && indexes >= 0 {
+			rest := value[5+n:]
+			for ; indexes > 0 && len(rest) > 0; indexes-- {
+				if _, n = binary.Varint(rest); n <= 0 {
+					break
+				}
+				rest = rest[n:]
+			}
+			if indexes == 0 && isProtobuf(rest) {
+				return formatProtobuf
+			}
+		}
+		return formatAvro
+	case isText(value):
+		return formatText
+	case isProtobuf(value):
+		return formatProtobuf
+	}
+	return formatBinary
+}
+
+// isText reports whether b is UTF-8 text without control characters other than whitespace.
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isProtobuf reports whether b parses as a Protobuf message: a sequence of fields with valid numbers
+// and wire types ending exactly at the end of b.
+func isProtobuf(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 || tag>>3 == 0 || tag>>3 > 1<<29-1 {
+			return false
+		}
+		b = b[n:]
+		switch tag & 7 {
+		case 0: // varint
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return false
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return false
+			}
+			b = b[8:]
+		case 2: // length-delimited
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return false
+			}
+			b = b[n+int(length):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return false
+			}
+			b = b[4:]
+		default: // groups are deprecated
+			return false
+		}
+	}
+	return true
+}
+
+func ProfileTopicTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("profileTopic",
+			mcp.WithDescription("Samples the messages of each partition of a topic and describes them without returning them: "+
+				"the detected value format (json, avro for the Schema Registry wire format, protobuf, text or binary), a JSON Schema inferred from the JSON values with the presence rate and types of each field, "+
+				"the number of distinct and null keys with an estimate of the topic's distinct keys, key and value size percentiles, the tombstone ratio, the header keys seen and the timestamp type and range."),
+			mcp.WithString("name",
+				mcp.Required(),
+				mcp.Description("The name of the topic to profile."),
+			),
+			mcp.WithNumber("numMessages",
+				mcp.Description(fmt.Sprintf("Number of messages sampled per partition. Defaults to %d, at most %d.", defaultProfileMessages, maxProfileMessages)),
+			),
+			mcp.WithString("from",
+				mcp.Description("Whether to sample the newest messages of each partition (the default) or the oldest."),
+				mcp.Enum("newest", "oldest"),
+			),
+			withCluster(),
+		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			args := request.GetArguments()
+			topic, _ := args["name"].(string)
+			if err := cfg.checkTopic(topic); err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			numMessages := int64(defaultProfileMessages)
+			if n, ok := args["numMessages"].(float64); ok && n >= 1 {
+				numMessages = min(int64(n), maxProfileMessages)
+			}
+			oldest := args["from"] == "oldest"
+
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			client, err := sarama.NewClient(cluster.BootstrapServers, config)
+			if err != nil {
+				err = fmt.Errorf("Failed to create Kafka client: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer metrics.TrackKafkaClient(cluster.Name, "consumer")()
+			// closing the admin closes the client
+			admin, err := sarama.NewClusterAdminFromClient(client)
+			if err != nil {
+				client.Close()
+				err = fmt.Errorf("Error init kafka admin client: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer admin.Close()
+
+			var partitions []int32
+			_, span := startKafkaSpan(ctx, cluster, "Partitions")
+			err = awaitContext(ctx, func() (err error) {
+				partitions, err = client.Partitions(topic)
+				return err
+			})
+			tracing.End(span, err)
+			if err != nil {
+				err = fmt.Errorf("Failed to fetch partitions: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+
+			profiler := newTopicProfiler(cfg)
+			var total int64
+			for _, partition := range partitions {
+				p := ProfiledPartition{Partition: partition}
+				_, span := startKafkaSpan(ctx, cluster, "GetOffset")
+				err = awaitContext(ctx, func() (err error) {
+					if p.StartOffset, err = client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+						return fmt.Errorf("Error getting start offset of partition %d: %v", partition, err)
+					}
+					if p.EndOffset, err = client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+						return fmt.Errorf("Error getting end offset of partition %d: %v", partition, err)
+					}
+					return nil
+				})
+				tracing.End(span, err)
+				if err != nil {
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				if oldest {
+					p.EndOffset = min(p.EndOffset, p.StartOffset+numMessages)
+				} else {
+					p.StartOffset = max(p.StartOffset, p.EndOffset-numMessages)
+				}
+				profiler.result.Partitions = append(profiler.result.Partitions, p)
+				total += p.EndOffset - p.StartOffset
+			}
+
+			var resources []sarama.ConfigEntry
+			_, span = startKafkaSpan(ctx, cluster, "DescribeConfig")
+			err = awaitContext(ctx, func() (err error) {
+				resources, err = admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: topic, ConfigNames: []string{"message.timestamp.type"}})
+				return err
+			})
+			tracing.End(span, err)
+			if err != nil {
+				err = fmt.Errorf("Error describing %s configuration: %v", topic, err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			for _, e := range resources {
+				if e.Name == "message.timestamp.type" {
+					profiler.result.TimestampType = e.Value
+				}
+			}
+
+			consumer, err := sarama.NewConsumerFromClient(client)
+			if err != nil {
+				err = fmt.Errorf("Error creating consumer: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer consumer.Close()
+
+			progress := progressFromContext(ctx)
+			for i := range profiler.result.Partitions {
+				p := &profiler.result.Partitions[i]
+				if p.StartOffset >= p.EndOffset {
+					continue
+				}
+				err = scanPartition(ctx, cluster, consumer, topic, p.Partition, p.StartOffset, p.EndOffset, func(message *sarama.ConsumerMessage) bool {
+					profiler.add(message)
+					p.Sampled++
+					progress(float64(profiler.result.Messages), float64(total))
+					return true
+				})
+				if err != nil && ctx.Err() == nil {
+					err = fmt.Errorf("Error from consumer: %v", err)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				if ctx.Err() != nil {
+					resultJSON, _ := json.Marshal(profiler.finish())
+					return newPartialToolResult(resultJSON, ctx.Err(), fmt.Sprintf("%d of %d messages sampled", profiler.result.Messages, total)), nil
+				}
+			}
+
+			resultJSON, _ := json.Marshal(profiler.finish())
+			return mcp.NewToolResultText(string(resultJSON)), nil
+		}
+}
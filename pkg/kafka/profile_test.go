@@ -0,0 +1,107 @@
+package kafka
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestDetectFormat(t *testing.T) {
+	wire := func(payload ...byte) []byte {
+		return append([]byte{0, 0, 0, 0, 7}, payload...)
+	}
+	tests := []struct {
+		name  string
+		value []byte
+		want  string
+	}{
+		{"empty", []byte{}, formatEmpty},
+		{"JSON object", []byte(`{"id":1}`), formatJSON},
+		{"JSON array with spaces", []byte(" [1, 2]\n"), formatJSON},
+		{"invalid JSON", []byte(`{"id":`), formatText},
+		{"text", []byte("payment failed: timeout"), formatText},
+		{"protobuf", []byte{0x08, 0x96, 0x01, 0x12, 0x02, 'h', 'i'}, formatProtobuf},
+		{"binary", []byte{'a', 0x01, 'b'}, formatBinary},
+		{"Schema Registry JSON", wire([]byte(`{"id":1}`)...), formatJSON},
+		{"Schema Registry Protobuf", wire(0x00, 0x08, 0x01), formatProtobuf},
+		{"Schema Registry Avro", wire(0x06, 'f', 'o', 'o'), formatAvro},
+	}
+	for _, tt := range tests {
+		if got := detectFormat(tt.value); got != tt.want {
+			t.Errorf("%s: detectFormat(%q) = %s, want %s", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestTopicProfiler(t *testing.T) {
+	start := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	p := newTopicProfiler(&Config{})
+	for i, m := range []struct {
+		key, value string
+		null       bool
+	}{
+		{key: "a", value: `{"id":1,"tags":["x","y"],"customer":{"email":"e"}}`},
+		{key: "a", value: `{"id":2.5,"tags":[],"customer":null}`},
+		{key: "b", value: `{"id":3}`},
+		{key: "c", value: "plain text"},
+		{null: true},
+	} {
+		message := &sarama.ConsumerMessage{Topic: "orders", Timestamp: start.Add(time.Duration(i) * time.Minute)}
+		if m.null {
+			message.Timestamp = time.Time{}
+		} else {
+			message.Key, message.Value = []byte(m.key), []byte(m.value)
+		}
+		if i == 0 {
+			message.Headers = []*sarama.RecordHeader{{Key: []byte("source"), Value: []byte("web")}}
+		}
+		p.add(message)
+	}
+	r := p.finish()
+
+	if r.Format != formatJSON || !reflect.DeepEqual(r.Formats, map[string]int{formatJSON: 3, formatText: 1}) {
+		t.Errorf("format = %s, formats = %v, want json with 3 JSON and 1 text values", r.Format, r.Formats)
+	}
+	if want := (KeyProfile{Null: 1, Distinct: 3, UniqueRatio: 0.75, EstimatedDistinct: 3}); r.Keys != want {
+		t.Errorf("keys = %+v, want %+v", r.Keys, want)
+	}
+	if r.Tombstones != 1 || r.TombstoneRatio != 0.2 || r.MissingTimestamps != 1 {
+		t.Errorf("tombstones = %d (%v), missing timestamps = %d, want 1 (0.2) and 1", r.Tombstones, r.TombstoneRatio, r.MissingTimestamps)
+	}
+	if r.OldestTimestamp != "2025-05-01T10:00:00Z" || r.NewestTimestamp != "2025-05-01T10:03:00Z" {
+		t.Errorf("timestamps = %s to %s", r.OldestTimestamp, r.NewestTimestamp)
+	}
+	if !reflect.DeepEqual(r.Headers, map[string]int{"source": 1}) {
+		t.Errorf("headers = %v", r.Headers)
+	}
+
+	schema, _ := json.Marshal(r.Schema)
+	var got any
+	_ = json.Unmarshal(schema, &got)
+	var want any
+	_ = json.Unmarshal([]byte(`{"type":"object","presence":0.6,"properties":{
+		"id":{"type":["integer","number"],"presence":1},
+		"tags":{"type":"array","presence":0.667,"items":{"type":"string","presence":1}},
+		"customer":{"type":["null","object"],"presence":0.667,"properties":{"email":{"type":"string","presence":1}}}
+	}}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("schema = %s", schema)
+	}
+}
+
+func TestSizeProfile(t *testing.T) {
+	sizes := make([]int, 100)
+	for i := range sizes {
+		sizes[i] = 100 - i
+	}
+	want := &SizeProfile{Min: 1, P50: 50, P90: 90, P99: 99, Max: 100, Avg: 50.5}
+	if got := sizeProfile(sizes); !reflect.DeepEqual(got, want) {
+		t.Errorf("sizeProfile() = %+v, want %+v", got, want)
+	}
+	if sizeProfile(nil) != nil {
+		t.Error("sizeProfile(nil) != nil")
+	}
+}
@@ -0,0 +1,62 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// progressInterval is the minimum delay between two progress notifications of a call, so that a
+// fast consumer does not flood the client. The final notification is always sent.
+const progressInterval = 250 * time.Millisecond
+
+type progressKey struct{}
+
+// Progress reports how much of a tool call is done. total is 0 when unknown.
+type Progress func(progress, total float64)
+
+// progressTool gives the handler a Progress that sends `notifications/progress` to the client when
+// the request carries a progress token, and does nothing otherwise.
+func progressTool(s *server.MCPServer) func(mcp.Tool, server.ToolHandlerFunc) (mcp.Tool, server.ToolHandlerFunc) {
+	return func(tool mcp.Tool, handler server.ToolHandlerFunc) (mcp.Tool, server.ToolHandlerFunc) {
+		return tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			var progress Progress = func(float64, float64) {}
+			if request.Params.Meta != nil && request.Params.Meta.ProgressToken != nil {
+				progress = newProgress(ctx, s, request.Params.Meta.ProgressToken)
+			}
+			// calls nested in a MultiplexTools batch replace the batch's Progress, keeping its notifications monotonic
+			return handler(context.WithValue(ctx, progressKey{}, progress), request)
+		}
+	}
+}
+
+func newProgress(ctx context.Context, s *server.MCPServer, token mcp.ProgressToken) Progress {
+	var mu sync.Mutex
+	var last time.Time
+	var sent float64
+	return func(progress, total float64) {
+		mu.Lock()
+		defer mu.Unlock()
+		if progress <= sent || (progress != total && time.Since(last) < progressInterval) {
+			return
+		}
+		params := map[string]any{"progressToken": token, "progress": progress}
+		if total > 0 {
+			params["total"] = total
+		}
+		// a full notification channel only costs the client an update
+		_ = s.SendNotificationToClient(ctx, "notifications/progress", params)
+		last, sent = time.Now(), progress
+	}
+}
+
+// progressFromContext returns the Progress of the current tool call.
+func progressFromContext(ctx context.Context) Progress {
+	if progress, ok := ctx.Value(progressKey{}).(Progress); ok {
+		return progress
+	}
+	return func(float64, float64) {}
+}
@@ -0,0 +1,247 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// promptTools lists the tools returning the data each prompt embeds. A prompt shares their checks: it
+// is only registered when they are all enabled, only available to the roles allowed to call them all,
+// and its data is fetched and audited as calls of the tools.
+var promptTools = map[string][]string{
+	"diagnoseConsumerLag":    {"describeConsumerGroups"},
+	"investigateTopicHealth": {"topicOffsets", "describeCluster"},
+	"explainPoisonMessage":   {"consumerMessages"},
+	"planPartitionIncrease":  {"topicOffsets", "describeCluster", "describeConsumerGroups"},
+}
+
+// addPrompts registers the runbook prompts. Each prompt fetches the data the runbook starts from and
+// embeds it in the prompt, so the conversation begins with the current state of the cluster.
+func addPrompts(s *server.MCPServer, cfg *Config) {
+	addPrompt := func(prompt mcp.Prompt, handler server.PromptHandlerFunc) {
+		for _, toolName := range promptTools[prompt.Name] {
+			if !cfg.Tools.enabled(toolName) {
+				return
+			}
+		}
+		s.AddPrompt(prompt, authorizePrompt(prompt.Name, handler))
+	}
+	clusterArgument := mcp.WithArgument("cluster",
+		mcp.ArgumentDescription("The name of the cluster, as returned by listClusters. Defaults to the default cluster."),
+	)
+
+	addPrompt(mcp.NewPrompt("diagnoseConsumerLag",
+		mcp.WithPromptDescription("Diagnose why a consumer group is lagging, from its state, members and per-partition lag."),
+		mcp.WithArgument("group", mcp.RequiredArgument(), mcp.ArgumentDescription("The consumer group ID.")),
+		clusterArgument,
+	), cfg.diagnoseConsumerLagPrompt)
+
+	addPrompt(mcp.NewPrompt("investigateTopicHealth",
+		mcp.WithPromptDescription("Check a topic for offline or under-replicated partitions, leader imbalance and risky configuration."),
+		mcp.WithArgument("topic", mcp.RequiredArgument(), mcp.ArgumentDescription("The topic name.")),
+		clusterArgument,
+	), cfg.investigateTopicHealthPrompt)
+
+	addPrompt(mcp.NewPrompt("explainPoisonMessage",
+		mcp.WithPromptDescription("Explain why the message at a partition and offset could break its consumers, comparing it with its neighbours."),
+		mcp.WithArgument("topic", mcp.RequiredArgument(), mcp.ArgumentDescription("The topic name.")),
+		mcp.WithArgument("partition", mcp.RequiredArgument(), mcp.ArgumentDescription("The partition of the message.")),
+		mcp.WithArgument("offset", mcp.RequiredArgument(), mcp.ArgumentDescription("The offset of the message.")),
+		clusterArgument,
+	), cfg.explainPoisonMessagePrompt)
+
+	addPrompt(mcp.NewPrompt("planPartitionIncrease",
+		mcp.WithPromptDescription("Plan increasing the partition count of a topic, including the impact on keyed messages and consumer groups."),
+		mcp.WithArgument("topic", mcp.RequiredArgument(), mcp.ArgumentDescription("The topic name.")),
+		mcp.WithArgument("partitions", mcp.ArgumentDescription("The target partition count, if already decided.")),
+		clusterArgument,
+	), cfg.planPartitionIncreasePrompt)
+}
+
+func (cfg *Config) diagnoseConsumerLagPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	args := request.Params.Arguments
+	group := args["group"]
+	if group == "" {
+		return nil, fmt.Errorf("group is required")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Diagnose the lag of the Kafka consumer group %q.\n\n", group)
+	b.WriteString(cfg.promptData(ctx, "Consumer group", resourceURI(args, "groups", url.PathEscape(group)), "groups", "describeConsumerGroups", cfg.readGroup))
+	b.WriteString(`
+Work through these steps:
+1. Summarize the total lag and which topics and partitions hold most of it.
+2. Check the group state and member count: an Empty or rebalancing group, or fewer members than partitions, points at the consumers rather than the brokers.
+3. Tell apart lag spread evenly across partitions (consumers too slow for the produce rate) from lag concentrated on a few partitions (a stuck consumer, a poison message or a hot key).
+4. For a stuck partition, suggest reading the messages at its committed offset with the kafka://topics/{name}/partitions/{p}/messages resource.
+5. Recommend next steps, from scaling consumers to fixing or skipping a message, and say what to monitor afterwards.
+`)
+	return promptResult("Consumer lag diagnosis for "+group, b.String()), nil
+}
+
+func (cfg *Config) investigateTopicHealthPrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	args := request.Params.Arguments
+	topic := args["topic"]
+	if topic == "" {
+		return nil, fmt.Errorf("topic is required")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Investigate the health of the Kafka topic %q.\n\n", topic)
+	b.WriteString(cfg.promptData(ctx, "Topic", resourceURI(args, "topics", url.PathEscape(topic)), "topics", "topicOffsets", cfg.readTopic))
+	b.WriteString(cfg.promptData(ctx, "Cluster", resourceURI(args, "cluster", ""), "cluster", "describeCluster", cfg.readCluster))
+	b.WriteString(`
+Check and report:
+1. Offline partitions (leader -1) and under-replicated partitions (ISR smaller than the replicas).
+2. Leader distribution across the brokers, and replicas placed on brokers missing from the cluster.
+3. Partitions with no data or far more data than the others (end minus start offset), a sign of skewed keys.
+4. Configuration overrides that put durability or retention at risk, such as min.insync.replicas, unclean.leader.election.enable, retention and cleanup.policy.
+Finish with a short verdict (healthy, degraded or at risk) and the actions to take, most urgent first.
+`)
+	return promptResult("Health investigation of topic "+topic, b.String()), nil
+}
+
+func (cfg *Config) explainPoisonMessagePrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	args := request.Params.Arguments
+	topic := args["topic"]
+	partition, err := strconv.ParseInt(args["partition"], 10, 32)
+	if topic == "" || err != nil {
+		return nil, fmt.Errorf("topic and a numeric partition are required")
+	}
+	offset, err := strconv.ParseInt(args["offset"], 10, 64)
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("offset must be a non-negative number")
+	}
+
+	// two messages on each side give a baseline of what valid messages look like
+	from := max(offset-2, 0)
+	uri := resourceURI(args, "topics", fmt.Sprintf("%s/partitions/%d/messages", url.PathEscape(topic), partition))
+	uri = addQuery(uri, "offset", strconv.FormatInt(from, 10))
+	uri = addQuery(uri, "limit", strconv.FormatInt(offset-from+3, 10))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "A consumer fails on the message at offset %d of partition %d of the Kafka topic %q. Explain why this message could be a poison message.\n\n", offset, partition, topic)
+	b.WriteString(cfg.promptData(ctx, "The message and its neighbours", uri, "topics", "consumerMessages", cfg.readMessages))
+	fmt.Fprintf(&b, `
+Compare the message at offset %d with its neighbours:
+1. Format: is it valid JSON, Avro or Protobuf like the others, or truncated, empty or a tombstone (null value)?
+2. Schema: missing or extra fields, changed types, unexpected nulls, out-of-range values or encodings.
+3. Key and headers: a missing key, an unexpected content type or schema ID.
+4. Size: is it much larger than the others?
+Then state the most likely cause, how a consumer should handle such messages (validation, dead-letter topic, skipping the offset) and how to fix the producer.
+`, offset)
+	return promptResult(fmt.Sprintf("Poison message analysis of %s-%d@%d", topic, partition, offset), b.String()), nil
+}
+
+func (cfg *Config) planPartitionIncreasePrompt(ctx context.Context, request mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+	args := request.Params.Arguments
+	topic := args["topic"]
+	if topic == "" {
+		return nil, fmt.Errorf("topic is required")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Plan increasing the partition count of the Kafka topic %q", topic)
+	if target := args["partitions"]; target != "" {
+		fmt.Fprintf(&b, " to %s partitions", target)
+	}
+	b.WriteString(".\n\n")
+	b.WriteString(cfg.promptData(ctx, "Topic", resourceURI(args, "topics", url.PathEscape(topic)), "topics", "topicOffsets", cfg.readTopic))
+	b.WriteString(cfg.promptData(ctx, "Cluster", resourceURI(args, "cluster", ""), "cluster", "describeCluster", cfg.readCluster))
+	b.WriteString(cfg.topicConsumersData(ctx, args["cluster"], topic))
+	b.WriteString(`
+The plan should cover:
+1. The target partition count, if not given, from the current throughput per partition, the consumer groups' member counts and the broker count.
+2. Ordering: keyed messages are assigned by hash modulo the partition count, so most keys move to another partition after the increase. Say whether the consumers depend on per-key ordering and how to drain or pause producers if they do.
+3. The consumer groups above: they rebalance, and new partitions start from their auto.offset.reset position, which can skip messages produced before the group sees them.
+4. Replica placement and broker load for the new partitions.
+5. The rollout steps, verification and what cannot be rolled back (partitions cannot be removed).
+`)
+	return promptResult("Partition increase plan for "+topic, b.String()), nil
+}
+
+// topicConsumersData lists the consumer groups with committed offsets on the topic, using the
+// describeConsumerGroups tool.
+func (cfg *Config) topicConsumersData(ctx context.Context, cluster, topic string) string {
+	_, handler := cfg.auditTool(cfg.timeoutTool(authorizeTool(DescribeConsumerGroupsTool(cfg))))
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "describeConsumerGroups"
+	request.Params.Arguments = map[string]any{"cluster": cluster}
+	result, err := handler(ctx, request)
+	if err == nil && result.IsError {
+		err = fmt.Errorf("%s", resultText(result))
+	}
+	if err != nil {
+		return fmt.Sprintf("Consumer groups of the topic: unavailable (%v)\n\n", err)
+	}
+
+	var groups []GroupInfo
+	if err := json.Unmarshal([]byte(resultText(result)), &groups); err != nil {
+		return fmt.Sprintf("Consumer groups of the topic: unavailable (%v)\n\n", err)
+	}
+	consumers := []GroupInfo{}
+	for _, g := range groups {
+		var offsets []GroupPartitionInfo
+		for _, o := range g.Offsets {
+			if o.Topic == topic {
+				offsets = append(offsets, o)
+			}
+		}
+		if len(offsets) > 0 {
+			g.Offsets = offsets
+			consumers = append(consumers, g)
+		}
+	}
+	data, _ := json.MarshalIndent(consumers, "", "  ")
+	return fmt.Sprintf("Consumer groups of the topic:\n```json\n%s\n```\n\n", data)
+}
+
+// promptData reads a resource, checked as a call of toolName, and formats it for a prompt. A read
+// error is embedded instead, so the prompt is still usable when part of the data is unavailable.
+func (cfg *Config) promptData(ctx context.Context, title, uri, kind, toolName string, read resourceReader) string {
+	request := mcp.ReadResourceRequest{}
+	request.Params.URI = uri
+	contents, err := cfg.resourceHandler(kind, toolName, read)(ctx, request)
+	if err != nil {
+		return fmt.Sprintf("%s (%s): unavailable (%v)\n\n", title, uri, err)
+	}
+	var text bytes.Buffer
+	if err := json.Indent(&text, []byte(contents[0].(mcp.TextResourceContents).Text), "", "  "); err != nil {
+		return fmt.Sprintf("%s (%s): unavailable (%v)\n\n", title, uri, err)
+	}
+	return fmt.Sprintf("%s (%s):\n```json\n%s\n```\n\n", title, uri, text.String())
+}
+
+// resourceURI builds the URI of a resource of the prompt's cluster.
+func resourceURI(args map[string]string, kind, path string) string {
+	uri := "kafka://" + kind
+	if path != "" {
+		uri += "/" + path
+	}
+	if cluster := args["cluster"]; cluster != "" {
+		uri = addQuery(uri, "cluster", cluster)
+	}
+	return uri
+}
+
+func addQuery(uri, key, value string) string {
+	separator := "?"
+	if strings.Contains(uri, "?") {
+		separator = "&"
+	}
+	return uri + separator + key + "=" + url.QueryEscape(value)
+}
+
+func promptResult(description, text string) *mcp.GetPromptResult {
+	return mcp.NewGetPromptResult(description, []mcp.PromptMessage{
+		mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(text)),
+	})
+}
@@ -0,0 +1,282 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/llm"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Types of recipe parameters.
+const (
+	ParamString  = "string"
+	ParamNumber  = "number"
+	ParamInteger = "integer"
+	ParamBoolean = "boolean"
+)
+
+var recipeNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// Recipe is a saved workflow: a sequence of tool calls registered as a tool of its own, whose
+// arguments are the recipe's parameters. Its steps run like the calls of a MultiplexTools batch and
+// reference the arguments with `{{params.<name>}}`.
+type Recipe struct {
+	Name        string            `mapstructure:"name"`
+	Description string            `mapstructure:"description"`
+	Parameters  []RecipeParameter `mapstructure:"parameters"`
+	Steps       []RecipeStep      `mapstructure:"steps"`
+	// FailFast stops the recipe at the first failed step.
+	FailFast bool `mapstructure:"fail-fast"`
+}
+
+// RecipeParameter is a typed argument of a recipe.
+type RecipeParameter struct {
+	Name string `mapstructure:"name"`
+	// Type is string (the default), number, integer or boolean.
+	Type        string `mapstructure:"type"`
+	Description string `mapstructure:"description"`
+	Required    bool   `mapstructure:"required"`
+	// Default is used when the argument is not passed.
+	Default any `mapstructure:"default"`
+	// Enum lists the allowed values of a string parameter.
+	Enum []string `mapstructure:"enum"`
+}
+
+// RecipeStep is a tool call of a recipe. DependsOn, ForEach and When have the meaning of
+// `dependsOn`, `forEach` and `when` in MultiplexTools.
+type RecipeStep struct {
+	ID        string         `mapstructure:"id"`
+	Tool      string         `mapstructure:"tool"`
+	Arguments map[string]any `mapstructure:"arguments"`
+	DependsOn []string       `mapstructure:"depends-on"`
+	ForEach   any            `mapstructure:"for-each"`
+	When      any            `mapstructure:"when"`
+}
+
+// Validate checks the recipe's name, parameters and steps.
+func (r Recipe) Validate() error {
+	if !recipeNamePattern.MatchString(r.Name) {
+		return fmt.Errorf("invalid recipe name %q: it must start with a letter and only contain letters, digits, _ and -", r.Name)
+	}
+	var errs []error
+	seen := map[string]bool{}
+	for i, p := range r.Parameters {
+		switch {
+		case p.Name == "":
+			errs = append(errs, fmt.Errorf("parameter #%d has no name", i))
+		case seen[p.Name]:
+			errs = append(errs, fmt.Errorf("parameter %s is defined twice", p.Name))
+		}
+		seen[p.Name] = true
+		switch p.Type {
+		case "", ParamString, ParamNumber, ParamInteger, ParamBoolean:
+		default:
+			errs = append(errs, fmt.Errorf("parameter %s: unknown type %q (expected string, number, integer or boolean)", p.Name, p.Type))
+			continue
+		}
+		if len(p.Enum) > 0 && p.Type != "" && p.Type != ParamString {
+			errs = append(errs, fmt.Errorf("parameter %s: enum is only supported for strings", p.Name))
+		}
+		if p.Default != nil {
+			if _, err := p.value(p.Default); err != nil {
+				errs = append(errs, fmt.Errorf("parameter %s: invalid default: %w", p.Name, err))
+			}
+		}
+	}
+	if len(r.Steps) == 0 {
+		errs = append(errs, fmt.Errorf("it has no steps"))
+	}
+	ids := map[string]bool{}
+	for i, step := range r.Steps {
+		if step.Tool == "" {
+			errs = append(errs, fmt.Errorf("step #%d has no tool", i))
+		}
+		if step.ID != "" && ids[step.ID] {
+			errs = append(errs, fmt.Errorf("step ID %s is used twice", step.ID))
+		}
+		ids[step.ID] = true
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("recipe %s: %w", r.Name, err)
+	}
+	return nil
+}
+
+// value checks the type of an argument. Numbers of YAML and TOML config files are converted to
+// float64, as in JSON arguments.
+func (p RecipeParameter) value(v any) (any, error) {
+	switch p.Type {
+	case ParamNumber, ParamInteger:
+		var n float64
+		switch v := v.(type) {
+		case float64:
+			n = v
+		case int:
+			n = float64(v)
+		case int64:
+			n = float64(v)
+		default:
+			return nil, fmt.Errorf("expected a number, got %T", v)
+		}
+		if p.Type == ParamInteger && n != math.Trunc(n) {
+			return nil, fmt.Errorf("expected an integer, got %v", n)
+		}
+		return n, nil
+	case ParamBoolean:
+		if _, ok := v.(bool); !ok {
+			return nil, fmt.Errorf("expected a boolean, got %T", v)
+		}
+		return v, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a string, got %T", v)
+	}
+	if len(p.Enum) > 0 && !slices.Contains(p.Enum, s) {
+		return nil, fmt.Errorf("%q is not one of %s", s, strings.Join(p.Enum, ", "))
+	}
+	return s, nil
+}
+
+func (p RecipeParameter) toolOption() mcp.ToolOption {
+	opts := []mcp.PropertyOption{mcp.Description(p.Description)}
+	if p.Required {
+		opts = append(opts, mcp.Required())
+	}
+	switch p.Type {
+	case ParamNumber, ParamInteger:
+		if p.Default != nil {
+			n, _ := p.value(p.Default)
+			opts = append(opts, mcp.DefaultNumber(n.(float64)))
+		}
+		if p.Type == ParamInteger {
+			return mcp.WithInteger(p.Name, opts...)
+		}
+		return mcp.WithNumber(p.Name, opts...)
+	case ParamBoolean:
+		if b, ok := p.Default.(bool); ok {
+			opts = append(opts, mcp.DefaultBool(b))
+		}
+		return mcp.WithBoolean(p.Name, opts...)
+	}
+	if s, ok := p.Default.(string); ok {
+		opts = append(opts, mcp.DefaultString(s))
+	}
+	if len(p.Enum) > 0 {
+		opts = append(opts, mcp.Enum(p.Enum...))
+	}
+	return mcp.WithString(p.Name, opts...)
+}
+
+// bindTools checks that the tools of the recipe's steps are registered and restores the case of
+// the argument names, which the config file loader lowercases, from the tools' input schemas.
+func (r Recipe) bindTools(s *server.MCPServer) (Recipe, error) {
+	steps := make([]RecipeStep, len(r.Steps))
+	for i, step := range r.Steps {
+		tool := s.GetTool(step.Tool)
+		if tool == nil {
+			return r, fmt.Errorf("tool %s is not available", step.Tool)
+		}
+		arguments := make(map[string]any, len(step.Arguments))
+		for name, value := range step.Arguments {
+			for property := range tool.Tool.InputSchema.Properties {
+				if strings.EqualFold(property, name) {
+					name = property
+					break
+				}
+			}
+			arguments[name] = value
+		}
+		step.Arguments = arguments
+		steps[i] = step
+	}
+	r.Steps = steps
+	return r, nil
+}
+
+// requests returns the steps as the tool requests of a MultiplexTools batch.
+func (r Recipe) requests() []any {
+	requests := make([]any, len(r.Steps))
+	for i, step := range r.Steps {
+		request := map[string]any{
+			"jsonrpc": "2.0",
+			"id":      step.ID,
+			"method":  "tools/call",
+			"params":  map[string]any{"name": step.Tool, "arguments": step.Arguments},
+		}
+		if step.ID == "" {
+			request["id"] = i
+		}
+		if len(step.DependsOn) > 0 {
+			dependsOn := make([]any, len(step.DependsOn))
+			for j, id := range step.DependsOn {
+				dependsOn[j] = id
+			}
+			request["dependsOn"] = dependsOn
+		}
+		if step.ForEach != nil {
+			request["forEach"] = step.ForEach
+		}
+		if step.When != nil {
+			request["when"] = step.When
+		}
+		requests[i] = request
+	}
+	return requests
+}
+
+// RecipeTool registers a recipe as a tool. The result has the format of MultiplexTools results.
+func RecipeTool(cfg *Config, recipe Recipe, provider llm.Provider, s *server.MCPServer) (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	description := recipe.Description
+	if description == "" {
+		steps := make([]string, len(recipe.Steps))
+		for i, step := range recipe.Steps {
+			steps[i] = step.Tool
+		}
+		description = fmt.Sprintf("Runs the %s recipe: %s.", recipe.Name, strings.Join(steps, ", "))
+	}
+	opts := []mcp.ToolOption{mcp.WithDescription(description + " Returns an object of the steps' results keyed by step ID, each with its status (ok, error or skipped), response and timing.")}
+	for _, p := range recipe.Parameters {
+		opts = append(opts, p.toolOption())
+	}
+
+	return mcp.NewTool(recipe.Name, opts...), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		params := map[string]any{}
+		for _, p := range recipe.Parameters {
+			v, ok := request.GetArguments()[p.Name]
+			if !ok || v == nil {
+				switch {
+				case p.Default != nil:
+					v = p.Default
+				case p.Required:
+					return mcp.NewToolResultError(fmt.Sprintf("missing required parameter %s", p.Name)), nil
+				default:
+					continue
+				}
+			}
+			value, err := p.value(v)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid parameter %s: %v", p.Name, err)), nil
+			}
+			params[p.Name] = value
+		}
+
+		executor, err := newMultiplexExecutor(s, recipe.requests(), cfg.Multiplex.MaxConcurrency, cfg.Multiplex.MaxSteps, recipe.FailFast)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("recipe %s: %v", recipe.Name, err)), nil
+		}
+		executor.params = params
+
+		ctx = context.WithValue(ctx, batchKey{}, &batch{provider: provider})
+		jsonResult, _ := json.Marshal(executor.run(ctx))
+		return mcp.NewToolResultText(string(jsonResult)), nil
+	}
+}
@@ -0,0 +1,475 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// referencePattern matches the `{{expression}}` references to earlier results in the arguments of
+// multiplexed calls.
+var referencePattern = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+
+// callResult is the structured result of a multiplexed call that later calls can reference.
+type callResult struct {
+	value any
+	err   error
+}
+
+// batchResults holds the results of the calls of a batch by position, and the position of each
+// call ID. Calls only read the results of the calls they depend on, which are complete before
+// they start.
+type batchResults struct {
+	results []callResult
+	ids     map[string]int
+}
+
+// callIndex returns the position of the call a reference's first step designates: a position, or
+// counting back from the current call when negative, or a call ID.
+func (r *batchResults) callIndex(step referenceStep, current int) (int, error) {
+	if step.field != "" {
+		i, ok := r.ids[step.field]
+		if !ok {
+			return 0, fmt.Errorf("there is no call with ID %q", step.field)
+		}
+		return i, nil
+	}
+	if step.wildcard || step.function != "" {
+		return 0, fmt.Errorf("it must start with the position or ID of a call, e.g. results[0]")
+	}
+	i := step.index
+	if i < 0 {
+		i += current
+	}
+	if i < 0 || i >= len(r.results) {
+		return 0, fmt.Errorf("there is no call %d", step.index)
+	}
+	return i, nil
+}
+
+// referenceScope is what the references of a call can designate: the results of the other calls,
+// the arguments of the recipe running the batch and, in a forEach iteration, the current item and
+// its index.
+type referenceScope struct {
+	results *batchResults
+	// current is the position of the call whose references are resolved.
+	current int
+	// params are the arguments of the recipe, or nil for MultiplexTools batches.
+	params map[string]any
+
+	iterating bool
+	item      any
+	index     int
+}
+
+// forItem returns the scope of an iteration of a forEach call.
+func (sc *referenceScope) forItem(item any, index int) *referenceScope {
+	iteration := *sc
+	iteration.iterating, iteration.item, iteration.index = true, item, index
+	return &iteration
+}
+
+// newCallResult extracts the value of a JSON-RPC response to a tools/call: its structured content
+// when set, otherwise its first text content, decoded when it holds JSON.
+func newCallResult(response mcp.JSONRPCMessage) callResult {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return callResult{err: err}
+	}
+	var message struct {
+		Error  *mcp.JSONRPCErrorDetails `json:"error"`
+		Result *struct {
+			Content           []mcp.TextContent `json:"content"`
+			StructuredContent any               `json:"structuredContent"`
+			IsError           bool              `json:"isError"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return callResult{err: err}
+	}
+	switch {
+	case message.Error != nil:
+		return callResult{err: fmt.Errorf("%s", message.Error.Message)}
+	case message.Result == nil:
+		return callResult{err: fmt.Errorf("no result")}
+	case message.Result.IsError:
+		text := ""
+		if len(message.Result.Content) > 0 {
+			text = message.Result.Content[0].Text
+		}
+		return callResult{err: fmt.Errorf("%s", text)}
+	case message.Result.StructuredContent != nil:
+		return callResult{value: message.Result.StructuredContent}
+	case len(message.Result.Content) == 0:
+		return callResult{}
+	}
+	text := message.Result.Content[0].Text
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return callResult{value: text}
+	}
+	return callResult{value: value}
+}
+
+// resolveReferences replaces the references in the string values of v, recursively. A string that
+// is a single reference is replaced by the referenced value, keeping its type; references embedded
+// in a longer string are replaced by their text, or their JSON encoding for non-string values.
+func resolveReferences(v any, scope *referenceScope) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if match := referencePattern.FindStringSubmatchIndex(v); match != nil && match[0] == 0 && match[1] == len(v) {
+			return evaluateReference(v[match[2]:match[3]], scope)
+		}
+		var err error
+		resolved := referencePattern.ReplaceAllStringFunc(v, func(reference string) string {
+			value, e := evaluateReference(referencePattern.FindStringSubmatch(reference)[1], scope)
+			if e != nil {
+				err = e
+				return reference
+			}
+			return referenceText(value)
+		})
+		return resolved, err
+	case map[string]any:
+		resolved := make(map[string]any, len(v))
+		for k, item := range v {
+			r, err := resolveReferences(item, scope)
+			if err != nil {
+				return nil, err
+			}
+			resolved[k] = r
+		}
+		return resolved, nil
+	case []any:
+		resolved := make([]any, len(v))
+		for i, item := range v {
+			r, err := resolveReferences(item, scope)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = r
+		}
+		return resolved, nil
+	}
+	return v, nil
+}
+
+// referenceText is the text a reference embedded in a longer string is replaced by.
+func referenceText(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// referencedCalls returns the positions of the calls referenced in the string values of v.
+// Invalid references are ignored here and reported when resolved.
+func referencedCalls(v any, results *batchResults, current int) []int {
+	var calls []int
+	switch v := v.(type) {
+	case string:
+		for _, match := range referencePattern.FindAllStringSubmatch(v, -1) {
+			root, steps, err := parseReference(match[1])
+			if err != nil || root != "results" || len(steps) == 0 {
+				continue
+			}
+			if i, err := results.callIndex(steps[0], current); err == nil {
+				calls = append(calls, i)
+			}
+		}
+	case map[string]any:
+		for _, item := range v {
+			calls = append(calls, referencedCalls(item, results, current)...)
+		}
+	case []any:
+		for _, item := range v {
+			calls = append(calls, referencedCalls(item, results, current)...)
+		}
+	}
+	return calls
+}
+
+// evaluateReference evaluates a path into the results of the other calls, such as
+// `results[0].brokers[0].id` or its JSONPath form `$[0].brokers[0].id`. The first step designates
+// the call, by position or by ID with `results.<id>` or `results["<id>"]`. In a forEach iteration,
+// the path can instead start with `item`, the current item, or be `index`, its position. In a
+// recipe, `params.<name>` selects an argument of the recipe. Then:
+//
+//   - `[n]` selects an array element, counting from the end when negative, so `results[-1]` is
+//     the previous call
+//   - `.name` and `["name"]` select an object field, compared case-insensitively when there is no
+//     exact match
+//   - `.keys()` selects the sorted field names of an object, e.g. `results[0].keys()[0]` for the
+//     first topic returned by listTopics
+//   - `[*]` selects every element of an array or every value of an object in key order, making
+//     the reference evaluate to the list of values the rest of the path selects. Elements missing
+//     that path are skipped.
+//   - `.len()`, `.sum()`, `.min()` and `.max()` aggregate a list, e.g.
+//     `results.lag.offsets[*].lag.sum()` for the total lag of a consumer group. `.len()` also
+//     counts the fields of an object and the characters of a string.
+func evaluateReference(expression string, scope *referenceScope) (any, error) {
+	root, steps, err := parseReference(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %q: %w", expression, err)
+	}
+
+	var value any
+	switch root {
+	case "item", "index":
+		if !scope.iterating {
+			return nil, fmt.Errorf("invalid reference %q: %s is only defined in forEach calls", expression, root)
+		}
+		value = scope.item
+		if root == "index" {
+			value = float64(scope.index)
+		}
+	case "params":
+		if scope.params == nil {
+			return nil, fmt.Errorf("invalid reference %q: params are only defined in recipes", expression)
+		}
+		value = scope.params
+	default:
+		if len(steps) == 0 {
+			return nil, fmt.Errorf("invalid reference %q: it must start with the position or ID of a call, e.g. results[0]", expression)
+		}
+		call, err := scope.results.callIndex(steps[0], scope.current)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reference %q: %w", expression, err)
+		}
+		if call == scope.current {
+			return nil, fmt.Errorf("invalid reference %q: a call cannot reference its own result", expression)
+		}
+		if err := scope.results.results[call].err; err != nil {
+			return nil, fmt.Errorf("invalid reference %q: call %d failed: %v", expression, call, err)
+		}
+		value, steps = scope.results.results[call].value, steps[1:]
+	}
+
+	values, multiple := []any{value}, false
+	for _, step := range steps {
+		if step.function != "" {
+			// a wildcard can select nothing, whose sum is 0 and length 0
+			var v any = values
+			if !multiple {
+				v = values[0]
+			}
+			result, err := step.call(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid reference %q: %w", expression, err)
+			}
+			values, multiple = []any{result}, false
+			continue
+		}
+		var next []any
+		for _, v := range values {
+			selected, err := step.apply(v)
+			if err != nil {
+				if multiple {
+					continue
+				}
+				return nil, fmt.Errorf("invalid reference %q: %w", expression, err)
+			}
+			next = append(next, selected...)
+		}
+		values, multiple = next, multiple || step.wildcard
+	}
+	if multiple {
+		if values == nil {
+			values = []any{}
+		}
+		return values, nil
+	}
+	return values[0], nil
+}
+
+type referenceStep struct {
+	field    string
+	index    int
+	wildcard bool
+	// function is one of keys, len, sum, min and max.
+	function string
+}
+
+// apply returns the values the step selects in v.
+func (s referenceStep) apply(v any) ([]any, error) {
+	switch v := v.(type) {
+	case []any:
+		if s.wildcard {
+			return v, nil
+		}
+		if s.field != "" {
+			return nil, fmt.Errorf("cannot select field %q of an array", s.field)
+		}
+		i := s.index
+		if i < 0 {
+			i += len(v)
+		}
+		if i < 0 || i >= len(v) {
+			return nil, fmt.Errorf("index %d out of range (length %d)", s.index, len(v))
+		}
+		return []any{v[i]}, nil
+	case map[string]any:
+		if s.wildcard {
+			keys := sortedKeys(v)
+			values := make([]any, len(keys))
+			for i, k := range keys {
+				values[i] = v[k]
+			}
+			return values, nil
+		}
+		if s.field == "" {
+			return nil, fmt.Errorf("cannot index an object with %d", s.index)
+		}
+		if item, ok := v[s.field]; ok {
+			return []any{item}, nil
+		}
+		for k, item := range v {
+			if strings.EqualFold(k, s.field) {
+				return []any{item}, nil
+			}
+		}
+		return nil, fmt.Errorf("field %q not found", s.field)
+	}
+	return nil, fmt.Errorf("cannot select from %T", v)
+}
+
+// call applies the step's function to v.
+func (s referenceStep) call(v any) (any, error) {
+	switch s.function {
+	case "keys":
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("keys() needs an object, not %T", v)
+		}
+		keys := sortedKeys(m)
+		values := make([]any, len(keys))
+		for i, k := range keys {
+			values[i] = k
+		}
+		return values, nil
+	case "len":
+		switch v := v.(type) {
+		case []any:
+			return float64(len(v)), nil
+		case map[string]any:
+			return float64(len(v)), nil
+		case string:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("len() needs a list, an object or a string, not %T", v)
+	}
+
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s() needs a list, not %T", s.function, v)
+	}
+	if len(list) == 0 && s.function != "sum" {
+		return nil, fmt.Errorf("%s() of an empty list", s.function)
+	}
+	var result float64
+	for i, item := range list {
+		n, ok := item.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s() needs numbers, not %T", s.function, item)
+		}
+		switch {
+		case s.function == "sum" || i == 0:
+			result += n
+		case s.function == "min":
+			result = min(result, n)
+		case s.function == "max":
+			result = max(result, n)
+		}
+	}
+	return result, nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// referenceFunctions are the functions a reference step can call.
+var referenceFunctions = map[string]bool{"keys": true, "len": true, "sum": true, "min": true, "max": true}
+
+// parseReference splits a reference into its root, `results` (or `$`), `item`, `index` or
+// `params`, and the steps that follow. For `results`, the first step designates the call.
+func parseReference(expression string) (string, []referenceStep, error) {
+	root, rest := "", expression
+	for _, prefix := range []string{"results", "$", "item", "index", "params"} {
+		if r, ok := strings.CutPrefix(expression, prefix); ok && (r == "" || r[0] == '.' || r[0] == '[') {
+			root, rest = prefix, r
+			break
+		}
+	}
+	switch root {
+	case "":
+		return "", nil, fmt.Errorf("it must start with results, $, item, index or params")
+	case "$":
+		root = "results"
+	case "index":
+		if rest != "" {
+			return "", nil, fmt.Errorf("index is a number")
+		}
+	}
+
+	var steps []referenceStep
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			field := rest[1 : end+1]
+			if field == "" {
+				return "", nil, fmt.Errorf("empty field name")
+			}
+			name, isCall := strings.CutSuffix(field, "()")
+			switch {
+			case field == "*":
+				steps = append(steps, referenceStep{wildcard: true})
+			case isCall:
+				if !referenceFunctions[name] {
+					return "", nil, fmt.Errorf("unknown function %s", field)
+				}
+				steps = append(steps, referenceStep{function: name})
+			default:
+				steps = append(steps, referenceStep{field: field})
+			}
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return "", nil, fmt.Errorf("unclosed [")
+			}
+			selector := strings.TrimSpace(rest[1:end])
+			switch {
+			case selector == "*":
+				steps = append(steps, referenceStep{wildcard: true})
+			case len(selector) >= 2 && (selector[0] == '"' || selector[0] == '\'') && selector[len(selector)-1] == selector[0]:
+				steps = append(steps, referenceStep{field: selector[1 : len(selector)-1]})
+			default:
+				i, err := strconv.Atoi(selector)
+				if err != nil {
+					return "", nil, fmt.Errorf("invalid index %q", selector)
+				}
+				steps = append(steps, referenceStep{index: i})
+			}
+			rest = rest[end+1:]
+		default:
+			return "", nil, fmt.Errorf("unexpected %q", rest[0])
+		}
+	}
+	return root, steps, nil
+}
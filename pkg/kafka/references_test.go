@@ -0,0 +1,198 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testScope returns the scope of the third call of a batch whose first call listed topics and whose
+// second call, with ID lag, described a consumer group.
+func testScope(t *testing.T) *referenceScope {
+	t.Helper()
+	var topics, lag any
+	if err := json.Unmarshal([]byte(`{"orders":{"partitions":3},"payments":{"partitions":1}}`), &topics); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"group":"billing","offsets":[{"partition":0,"lag":5},{"partition":1,"lag":12},{"partition":2}]}`), &lag); err != nil {
+		t.Fatal(err)
+	}
+	return &referenceScope{
+		results: &batchResults{
+			results: []callResult{{value: topics}, {value: lag}, {}, {err: errors.New("boom")}},
+			ids:     map[string]int{"topics": 0, "lag": 1, "failed": 3},
+		},
+		current: 2,
+	}
+}
+
+func TestEvaluateReference(t *testing.T) {
+	scope := testScope(t)
+	tests := []struct {
+		expression string
+		want       any
+	}{
+		{"results[0].orders.partitions", float64(3)},
+		{"$[0].orders.partitions", float64(3)},
+		{`results["lag"].Group`, "billing"},
+		{"results[-1].group", "billing"},
+		{"results.lag.offsets[-1].partition", float64(2)},
+		{"results.lag.offsets[1]", map[string]any{"partition": float64(1), "lag": float64(12)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			got, err := evaluateReference(tt.expression, scope)
+			if err != nil {
+				t.Fatalf("evaluateReference() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evaluateReference() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateReferenceErrors(t *testing.T) {
+	scope := testScope(t)
+	for _, expression := range []string{
+		"topics[0]",                     // unknown root
+		"results",                       // no call
+		"results[2]",                    // its own result
+		"results[9]",                    // no such call
+		"results.unknown",               // no such ID
+		"results.failed.value",          // failed call
+		"results[0].missing",            // missing field
+		"results.lag.offsets[5]",        // out of range
+		"results.lag.offsets.partition", // field of an array
+		"results.lag.offsets[0",         // unclosed [
+	} {
+		if got, err := evaluateReference(expression, scope); err == nil {
+			t.Errorf("evaluateReference(%q) = %#v, want an error", expression, got)
+		}
+	}
+}
+
+func TestEvaluateReferenceFunctions(t *testing.T) {
+	scope := testScope(t)
+	tests := []struct {
+		expression string
+		want       any
+	}{
+		{"results.topics.keys()", []any{"orders", "payments"}},
+		{"results.topics.keys()[0]", "orders"},
+		{"results.topics.keys().len()", float64(2)},
+		{"results.lag.offsets[*].lag", []any{float64(5), float64(12)}},
+		{"results.lag.offsets[*].lag.sum()", float64(17)},
+		{"results.lag.offsets[*].lag.max()", float64(12)},
+		{"results.lag.offsets[*].lag.min()", float64(5)},
+		{"results.lag.offsets[*].missing", []any{}},
+		{"results.lag.offsets[*].missing.sum()", float64(0)},
+		{"results[0][*].partitions", []any{float64(3), float64(1)}},
+		{"results.lag.group.len()", float64(7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			got, err := evaluateReference(tt.expression, scope)
+			if err != nil {
+				t.Fatalf("evaluateReference() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evaluateReference() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	for _, expression := range []string{
+		"results.lag.offsets[*].lag.median()", // unknown function
+		"results.lag.group.sum()",             // sum of a string
+	} {
+		if got, err := evaluateReference(expression, scope); err == nil {
+			t.Errorf("evaluateReference(%q) = %#v, want an error", expression, got)
+		}
+	}
+}
+
+func TestEvaluateReferenceItem(t *testing.T) {
+	scope := testScope(t)
+	if got, err := evaluateReference("item", scope); err == nil {
+		t.Errorf("evaluateReference(item) outside forEach = %#v, want an error", got)
+	}
+	iteration := scope.forItem(map[string]any{"name": "orders"}, 4)
+	tests := []struct {
+		expression string
+		want       any
+	}{
+		{"item.name", "orders"},
+		{"index", float64(4)},
+		{"results[0].payments.partitions", float64(1)},
+	}
+	for _, tt := range tests {
+		got, err := evaluateReference(tt.expression, iteration)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("evaluateReference(%q) = %#v, %v, want %#v", tt.expression, got, err, tt.want)
+		}
+	}
+}
+
+func TestEvaluateReferenceParams(t *testing.T) {
+	scope := testScope(t)
+	if got, err := evaluateReference("params.topic", scope); err == nil {
+		t.Errorf("evaluateReference(params.topic) outside recipes = %#v, want an error", got)
+	}
+	scope.params = map[string]any{"topic": "orders", "partitions": float64(3)}
+	tests := []struct {
+		expression string
+		want       any
+	}{
+		{"params.topic", "orders"},
+		{"params.partitions", float64(3)},
+		{"params", map[string]any{"topic": "orders", "partitions": float64(3)}},
+	}
+	for _, tt := range tests {
+		got, err := evaluateReference(tt.expression, scope)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("evaluateReference(%q) = %#v, %v, want %#v", tt.expression, got, err, tt.want)
+		}
+	}
+	if got, err := evaluateReference("params.missing", scope); err == nil {
+		t.Errorf("evaluateReference(params.missing) = %#v, want an error", got)
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	scope := testScope(t)
+	args := map[string]any{
+		"name":       "{{results.lag.group}}",
+		"partitions": "{{ results[0].orders.partitions }}",
+		"label":      "lag of {{results.lag.group}}: {{results.lag.offsets[0]}}",
+		"nested":     []any{"{{results.lag.offsets[0].lag}}", true},
+	}
+	got, err := resolveReferences(args, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"name":       "billing",
+		"partitions": float64(3),
+		"label":      `lag of billing: {"lag":5,"partition":0}`,
+		"nested":     []any{float64(5), true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveReferences() = %#v, want %#v", got, want)
+	}
+	if _, err := resolveReferences("lag of {{results.missing}}", scope); err == nil {
+		t.Error("resolveReferences() of an invalid embedded reference succeeded")
+	}
+}
+
+func TestReferencedCalls(t *testing.T) {
+	scope := testScope(t)
+	args := map[string]any{"a": "{{results.lag.group}} {{$[0].orders}}", "b": []any{"{{item.x}}", "{{results.unknown}}"}}
+	got := map[int]bool{}
+	for _, i := range referencedCalls(args, scope.results, scope.current) {
+		got[i] = true
+	}
+	if want := map[int]bool{0: true, 1: true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("referencedCalls() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,424 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
+	"github.com/IBM/sarama"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// defaultResourceMessages is the number of messages returned by a messages resource without `limit`.
+	defaultResourceMessages = 10
+	maxResourceMessages     = 100
+)
+
+// resourceReader returns the content of a resource. path holds the URI segments after the resource
+// kind, e.g. [orders partitions 0 messages] for kafka://topics/orders/partitions/0/messages.
+type resourceReader func(ctx context.Context, cluster *ClusterConfig, config *sarama.Config, path []string, query url.Values) (any, error)
+
+// addResources registers the Kafka resources and resource templates. Every URI takes an optional
+// `cluster` query parameter that defaults to the default cluster.
+//
+// Each resource returns the data of a tool, whose checks it shares: it is only registered when the
+// tool is enabled, and reads are rejected for callers whose role cannot call the tool and are audited
+// as calls of the tool.
+func addResources(s *server.MCPServer, cfg *Config) {
+	if cfg.Tools.enabled("describeCluster") {
+		s.AddResource(mcp.NewResource("kafka://cluster", "Cluster",
+			mcp.WithResourceDescription("Brokers, controller and accessible topics of the default cluster."),
+			mcp.WithMIMEType("application/json"),
+		), server.ResourceHandlerFunc(cfg.resourceHandler("cluster", "describeCluster", cfg.readCluster)))
+	}
+
+	for _, t := range []struct {
+		kind, tool, uri, name, description string
+		read                               resourceReader
+	}{
+		{"cluster", "describeCluster", "kafka://cluster{?cluster}", "Cluster", "Brokers, controller and accessible topics of a cluster.", cfg.readCluster},
+		{"topics", "topicOffsets", "kafka://topics/{name}{?cluster}", "Topic", "Partitions with their leader, replicas, ISR and offsets, and the non-default configuration of a topic.", cfg.readTopic},
+		{"topics", "consumerMessages", "kafka://topics/{name}/partitions/{partition}/messages{?offset,limit,cluster}", "Topic messages", fmt.Sprintf("Messages of a partition from `offset` (the oldest message by default), at most `limit` of them (default %d, max %d).", defaultResourceMessages, maxResourceMessages), cfg.readMessages},
+		{"groups", "describeConsumerGroups", "kafka://groups/{id}{?cluster}", "Consumer group", "State, member count, committed offsets and lag of a consumer group.", cfg.readGroup},
+		{"brokers", "describeCluster", "kafka://brokers/{id}{?cluster}", "Broker", "Address, rack and non-default configuration of a broker.", cfg.readBroker},
+	} {
+		if !cfg.Tools.enabled(t.tool) {
+			continue
+		}
+		s.AddResourceTemplate(mcp.NewResourceTemplate(t.uri, t.name,
+			mcp.WithTemplateDescription(t.description),
+			mcp.WithTemplateMIMEType("application/json"),
+		), cfg.resourceHandler(t.kind, t.tool, t.read))
+	}
+}
+
+// resourceHandler parses the URI of a resource of the given kind, resolves its cluster and returns
+// what read produces as JSON, after checking that the caller may call toolName. The URI is parsed here
+// rather than through the template arguments, which are lost when query parameters come in a
+// different order than in the template.
+func (cfg *Config) resourceHandler(kind, toolName string, read resourceReader) server.ResourceTemplateHandlerFunc {
+	return func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		uri, err := url.Parse(request.Params.URI)
+		if err != nil || uri.Scheme != "kafka" || uri.Host != kind {
+			return nil, fmt.Errorf("invalid %s resource URI %q", kind, request.Params.URI)
+		}
+		var path []string
+		if p := strings.Trim(uri.Path, "/"); p != "" {
+			path = strings.Split(p, "/")
+		}
+		query := uri.Query()
+		if err := checkToolAllowed(ctx, toolName); err != nil {
+			return nil, err
+		}
+
+		cluster, config, err := cfg.namedClusterConfig(query.Get("cluster"))
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Timeouts.Call > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.Timeouts.Call)
+			defer cancel()
+		}
+		ctx, span := tracing.Start(ctx, "resources/read "+kind, attribute.String("mcp.method", string(mcp.MethodResourcesRead)), attribute.String("kafka.cluster", cluster.Name))
+		start := time.Now()
+		content, err := read(ctx, cluster, config, path, query)
+		tracing.End(span, err)
+		if cfg.Audit != nil {
+			cfg.auditResourceRead(ctx, toolName, request.Params.URI, cluster, kind, path, start, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		text, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		return []mcp.ResourceContents{mcp.TextResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: "application/json",
+			Text:     string(text),
+		}}, nil
+	}
+}
+
+// kafkaClients shares one Kafka client per cluster between the reads of the resources subscribed to,
+// instead of every poll connecting to the cluster again. Readers get it from their context.
+type kafkaClients struct {
+	mu      sync.Mutex
+	clients map[string]sarama.Client
+	untrack []func()
+}
+
+type kafkaClientsKey struct{}
+
+func withKafkaClients(ctx context.Context, clients *kafkaClients) context.Context {
+	return context.WithValue(ctx, kafkaClientsKey{}, clients)
+}
+
+// get returns the client of the cluster, creating it on first use or after it was closed.
+func (c *kafkaClients) get(cluster *ClusterConfig, config *sarama.Config) (sarama.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[cluster.Name]; ok && !client.Closed() {
+		return client, nil
+	}
+	client, err := sarama.NewClient(cluster.BootstrapServers, config)
+	if err != nil {
+		return nil, err
+	}
+	if c.clients == nil {
+		c.clients = map[string]sarama.Client{}
+	}
+	c.clients[cluster.Name] = client
+	c.untrack = append(c.untrack, metrics.TrackKafkaClient(cluster.Name, "client"))
+	return client, nil
+}
+
+// close closes the clients.
+func (c *kafkaClients) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, client := range c.clients {
+		client.Close()
+	}
+	for _, untrack := range c.untrack {
+		untrack()
+	}
+	c.clients, c.untrack = nil, nil
+}
+
+// resourceClient returns the shared client of the cluster when ctx has one, or a new client. release
+// closes the client, unless it is shared.
+func resourceClient(ctx context.Context, cluster *ClusterConfig, config *sarama.Config) (client sarama.Client, release func(), err error) {
+	if clients, ok := ctx.Value(kafkaClientsKey{}).(*kafkaClients); ok {
+		client, err = clients.get(cluster, config)
+		return client, func() {}, err
+	}
+	client, err = sarama.NewClient(cluster.BootstrapServers, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	untrack := metrics.TrackKafkaClient(cluster.Name, "client")
+	return client, func() {
+		client.Close()
+		untrack()
+	}, nil
+}
+
+func (cfg *Config) readCluster(ctx context.Context, cluster *ClusterConfig, config *sarama.Config, path []string, query url.Values) (any, error) {
+	client, release, err := resourceClient(ctx, cluster, config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Kafka client: %v", err)
+	}
+	defer release()
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("Error init kafka admin client: %v", err)
+	}
+
+	var brokers []*sarama.Broker
+	var controllerID int32
+	_, span := startKafkaSpan(ctx, cluster, "DescribeCluster")
+	err = awaitContext(ctx, func() (err error) {
+		brokers, controllerID, err = admin.DescribeCluster()
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("Error describing the cluster: %v", err)
+	}
+
+	var topics map[string]sarama.TopicDetail
+	_, span = startKafkaSpan(ctx, cluster, "ListTopics")
+	err = awaitContext(ctx, func() (err error) {
+		topics, err = admin.ListTopics()
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing topics: %v", err)
+	}
+
+	result := struct {
+		Name         string   `json:"name"`
+		Brokers      []Broker `json:"brokers"`
+		ControllerID int32    `json:"controllerId"`
+		Topics       []string `json:"topics"`
+	}{Name: cluster.Name, ControllerID: controllerID, Topics: []string{}}
+	for _, b := range brokers {
+		result.Brokers = append(result.Brokers, Broker{ID: b.ID(), Addr: b.Addr(), Rack: b.Rack()})
+	}
+	for name := range topics {
+		if cfg.Topics.Allowed(name) {
+			result.Topics = append(result.Topics, name)
+		}
+	}
+	sort.Strings(result.Topics)
+	return result, nil
+}
+
+func (cfg *Config) readTopic(ctx context.Context, cluster *ClusterConfig, config *sarama.Config, path []string, query url.Values) (any, error) {
+	if len(path) != 1 {
+		return nil, fmt.Errorf("expected kafka://topics/{name}")
+	}
+	topic := path[0]
+	if err := cfg.checkTopic(topic); err != nil {
+		return nil, err
+	}
+
+	client, release, err := resourceClient(ctx, cluster, config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Kafka client: %v", err)
+	}
+	defer release()
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("Error init kafka admin client: %v", err)
+	}
+
+	var metadata []*sarama.TopicMetadata
+	_, span := startKafkaSpan(ctx, cluster, "DescribeTopics")
+	err = awaitContext(ctx, func() (err error) {
+		metadata, err = admin.DescribeTopics([]string{topic})
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("Error describing topic: %v", err)
+	}
+	if len(metadata) == 0 || metadata[0].Err != sarama.ErrNoError {
+		return nil, fmt.Errorf("topic %q not found", topic)
+	}
+
+	info := TopicInfo{Name: topic}
+	for _, p := range metadata[0].Partitions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		partition := TopicPartitionInfo{Partition: p.ID, Leader: p.Leader, Replicas: p.Replicas, ISR: p.Isr}
+		_, span := startKafkaSpan(ctx, cluster, "GetOffset")
+		partition.StartOffset, err = client.GetOffset(topic, p.ID, sarama.OffsetOldest)
+		if err == nil {
+			partition.EndOffset, err = client.GetOffset(topic, p.ID, sarama.OffsetNewest)
+		}
+		tracing.End(span, err)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting offsets of partition %d: %v", p.ID, err)
+		}
+		info.Partitions = append(info.Partitions, partition)
+	}
+	sort.Slice(info.Partitions, func(i, j int) bool { return info.Partitions[i].Partition < info.Partitions[j].Partition })
+
+	info.Config, err = describeConfig(ctx, cluster, admin, sarama.ConfigResource{Type: sarama.TopicResource, Name: topic})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (cfg *Config) readMessages(ctx context.Context, cluster *ClusterConfig, config *sarama.Config, path []string, query url.Values) (any, error) {
+	if len(path) != 4 || path[1] != "partitions" || path[3] != "messages" {
+		return nil, fmt.Errorf("expected kafka://topics/{name}/partitions/{partition}/messages")
+	}
+	topic := path[0]
+	if err := cfg.checkTopic(topic); err != nil {
+		return nil, err
+	}
+	partition, err := strconv.ParseInt(path[2], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid partition %q", path[2])
+	}
+	offset := int64(sarama.OffsetOldest)
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	limit := defaultResourceMessages
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	limit = min(limit, maxResourceMessages)
+
+	messages, err := cfg.consumePartition(ctx, cluster, config, topic, int32(partition), offset, limit)
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (cfg *Config) readGroup(ctx context.Context, cluster *ClusterConfig, config *sarama.Config, path []string, query url.Values) (any, error) {
+	if len(path) != 1 {
+		return nil, fmt.Errorf("expected kafka://groups/{id}")
+	}
+	if err := cfg.checkGroup(path[0]); err != nil {
+		return nil, err
+	}
+
+	client, release, err := resourceClient(ctx, cluster, config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Kafka client: %v", err)
+	}
+	defer release()
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("Error init kafka admin client: %v", err)
+	}
+
+	var group *GroupInfo
+	err = awaitContext(ctx, func() (err error) {
+		group, err = cfg.describeGroup(ctx, cluster, admin, client, path[0], map[string]int64{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error describing consumer group: %v", err)
+	}
+	return group, nil
+}
+
+func (cfg *Config) readBroker(ctx context.Context, cluster *ClusterConfig, config *sarama.Config, path []string, query url.Values) (any, error) {
+	if len(path) != 1 {
+		return nil, fmt.Errorf("expected kafka://brokers/{id}")
+	}
+	id, err := strconv.ParseInt(path[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker id %q", path[0])
+	}
+
+	admin, err := sarama.NewClusterAdmin(cluster.BootstrapServers, config)
+	if err != nil {
+		return nil, fmt.Errorf("Error init kafka admin client: %v", err)
+	}
+	defer metrics.TrackKafkaClient(cluster.Name, "admin")()
+	defer admin.Close()
+
+	var brokers []*sarama.Broker
+	var controllerID int32
+	_, span := startKafkaSpan(ctx, cluster, "DescribeCluster")
+	err = awaitContext(ctx, func() (err error) {
+		brokers, controllerID, err = admin.DescribeCluster()
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("Error describing the cluster: %v", err)
+	}
+
+	for _, b := range brokers {
+		if b.ID() != int32(id) {
+			continue
+		}
+		info := BrokerInfo{
+			Broker:     Broker{ID: b.ID(), Addr: b.Addr(), Rack: b.Rack()},
+			Controller: b.ID() == controllerID,
+		}
+		info.Config, err = describeConfig(ctx, cluster, admin, sarama.ConfigResource{Type: sarama.BrokerResource, Name: path[0]})
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+	return nil, fmt.Errorf("broker %d not found", id)
+}
+
+// describeConfig returns the non-default configuration entries of a topic or broker, masking sensitive ones.
+func describeConfig(ctx context.Context, cluster *ClusterConfig, admin sarama.ClusterAdmin, resource sarama.ConfigResource) (map[string]string, error) {
+	var entries []sarama.ConfigEntry
+	_, span := startKafkaSpan(ctx, cluster, "DescribeConfig")
+	err := awaitContext(ctx, func() (err error) {
+		entries, err = admin.DescribeConfig(resource)
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("Error describing %s configuration: %v", resource.Name, err)
+	}
+
+	config := map[string]string{}
+	for _, e := range entries {
+		if e.Default || e.Source == sarama.SourceDefault {
+			continue
+		}
+		if e.Sensitive {
+			config[e.Name] = "[SENSITIVE]"
+			continue
+		}
+		config[e.Name] = e.Value
+	}
+	return config, nil
+}
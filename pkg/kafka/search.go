@@ -0,0 +1,418 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
+	"github.com/IBM/sarama"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// SearchConfig bounds the messages a searchMessages call scans.
+type SearchConfig struct {
+	// MaxMessages is the default and maximum number of messages a search scans.
+	MaxMessages int64
+	// MaxBytes is the default and maximum number of key, value and header bytes a search scans.
+	MaxBytes int64
+}
+
+const defaultSearchMatches = 100
+
+// SearchResult holds the messages matching a search and how much of the range was scanned.
+type SearchResult struct {
+	Matches []ConsumerMessage `json:"matches"`
+	// Complete is false when a limit stopped the search before the end of the range.
+	Complete bool   `json:"complete"`
+	Reason   string `json:"reason,omitempty"`
+	// ScannedMessages and ScannedBytes count the messages read, matching or not.
+	ScannedMessages int64               `json:"scannedMessages"`
+	ScannedBytes    int64               `json:"scannedBytes"`
+	Partitions      []SearchedPartition `json:"partitions"`
+	// WhereErrors counts the messages the `where` condition could not be evaluated for, which do
+	// not match, and FirstWhereError describes the first one.
+	WhereErrors     int    `json:"whereErrors,omitempty"`
+	FirstWhereError string `json:"firstWhereError,omitempty"`
+}
+
+// SearchedPartition is the range of a partition a search covers.
+type SearchedPartition struct {
+	Partition   int32 `json:"partition"`
+	StartOffset int64 `json:"startOffset"`
+	EndOffset   int64 `json:"endOffset"`
+	// NextOffset is the first offset that was not scanned, where a search continuing this one starts.
+	NextOffset int64 `json:"nextOffset"`
+	Scanned    int64 `json:"scanned"`
+	Matches    int   `json:"matches"`
+}
+
+// messageFilter holds the predicates a message must all satisfy to match a search.
+type messageFilter struct {
+	contains string
+	pattern  *regexp.Regexp
+	key      *string
+	headers  map[string]string
+	where    any
+}
+
+func newMessageFilter(args map[string]any) (*messageFilter, error) {
+	f := &messageFilter{}
+	f.contains, _ = args["contains"].(string)
+	if pattern, _ := args["pattern"].(string); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		f.pattern = re
+	}
+	if key, ok := args["key"].(string); ok {
+		f.key = &key
+	}
+	if headers, ok := args["headers"].(map[string]any); ok {
+		f.headers = map[string]string{}
+		for name, value := range headers {
+			f.headers[name] = referenceText(value)
+		}
+	}
+	if where, ok := args["where"].(string); ok && where != "" {
+		if err := checkMessageReferences(where); err != nil {
+			return nil, fmt.Errorf("invalid where condition: %w", err)
+		}
+		f.where = where
+	}
+	return f, nil
+}
+
+// checkMessageReferences checks that the references of an expression designate the message, and
+// nothing else.
+func checkMessageReferences(expression string) error {
+	for _, match := range referencePattern.FindAllStringSubmatch(expression, -1) {
+		if root, _, err := parseReference(match[1]); err != nil || root != "item" {
+			return fmt.Errorf("%q must be a reference to the message, such as {{item.value.id}}", match[0])
+		}
+	}
+	return nil
+}
+
+// match reports whether the message satisfies the filter. An error means the `where` condition
+// could not be evaluated for the message.
+func (f *messageFilter) match(m ConsumerMessage) (bool, error) {
+	if f.key != nil && m.Key != *f.key {
+		return false, nil
+	}
+	for name, value := range f.headers {
+		if v, ok := m.Headers[name]; !ok || v != value {
+			return false, nil
+		}
+	}
+	if f.contains != "" && !strings.Contains(m.Value, f.contains) {
+		return false, nil
+	}
+	if f.pattern != nil && !f.pattern.MatchString(m.Value) {
+		return false, nil
+	}
+	if f.where == nil {
+		return true, nil
+	}
+	scope := (&referenceScope{results: &batchResults{}}).forItem(messageItem(m), 0)
+	return evaluateCondition(f.where, scope)
+}
+
+// messageItem is the value `{{item}}` designates in the expressions of searchMessages and
+// aggregateMessages: the message's fields, with the value decoded when it holds JSON.
+func messageItem(m ConsumerMessage) map[string]any {
+	var value any = m.Value
+	var decoded any
+	if err := json.Unmarshal([]byte(m.Value), &decoded); err == nil {
+		value = decoded
+	}
+	headers := make(map[string]any, len(m.Headers))
+	for name, v := range m.Headers {
+		headers[name] = v
+	}
+	return map[string]any{
+		"key":       m.Key,
+		"value":     value,
+		"headers":   headers,
+		"partition": float64(m.Partition),
+		"offset":    float64(m.Offset),
+		"timestamp": m.Timestamp,
+	}
+}
+
+// parseSearchTime parses an RFC 3339 time, or a duration such as `90m` meaning that long ago.
+func parseSearchTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected an RFC 3339 time or a duration", s)
+	}
+	return t, nil
+}
+
+// selectPartitions returns the partitions of the `partitions` argument, checking that the topic has
+// them, or all of the topic's partitions when the argument is missing.
+func selectPartitions(topic string, partitions []int32, args map[string]any) ([]int32, error) {
+	selected, ok := args["partitions"].([]any)
+	if !ok || len(selected) == 0 {
+		return partitions, nil
+	}
+	all := map[int32]bool{}
+	for _, p := range partitions {
+		all[p] = true
+	}
+	partitions = nil
+	for _, p := range selected {
+		n, ok := p.(float64)
+		if !ok || !all[int32(n)] {
+			return nil, fmt.Errorf("topic %s has no partition %v", topic, p)
+		}
+		partitions = append(partitions, int32(n))
+	}
+	return partitions, nil
+}
+
+// searchRange returns the range of a partition between the bounds of a search: the later of its
+// start offset and start time, and the earlier of its end offset and end time, within the
+// partition's offsets.
+func searchRange(client sarama.Client, topic string, partition int32, args map[string]any, startTime, endTime time.Time) (SearchedPartition, error) {
+	r := SearchedPartition{Partition: partition}
+	var err error
+	if r.StartOffset, err = client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+		return r, fmt.Errorf("Error getting start offset of partition %d: %v", partition, err)
+	}
+	if r.EndOffset, err = client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+		return r, fmt.Errorf("Error getting end offset of partition %d: %v", partition, err)
+	}
+	if offset, ok := args["startOffset"].(float64); ok {
+		r.StartOffset = max(r.StartOffset, int64(offset))
+	}
+	if offset, ok := args["endOffset"].(float64); ok {
+		r.EndOffset = min(r.EndOffset, int64(offset))
+	}
+	// the offset of a time is the first offset whose timestamp is at or after it, or -1 when there is none
+	if !startTime.IsZero() {
+		offset, err := client.GetOffset(topic, partition, startTime.UnixMilli())
+		if err != nil {
+			return r, fmt.Errorf("Error getting offset of partition %d at %s: %v", partition, startTime, err)
+		}
+		if offset < 0 {
+			offset = r.EndOffset
+		}
+		r.StartOffset = max(r.StartOffset, offset)
+	}
+	if !endTime.IsZero() {
+		offset, err := client.GetOffset(topic, partition, endTime.UnixMilli())
+		if err != nil {
+			return r, fmt.Errorf("Error getting offset of partition %d at %s: %v", partition, endTime, err)
+		}
+		if offset >= 0 {
+			r.EndOffset = min(r.EndOffset, offset)
+		}
+	}
+	r.EndOffset = max(r.EndOffset, r.StartOffset)
+	r.NextOffset = r.StartOffset
+	return r, nil
+}
+
+func SearchMessagesTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("searchMessages",
+			mcp.WithDescription("Scans a topic, or some of its partitions, between offsets or times and returns the messages matching all the given filters, with how much was scanned. "+
+				"The scan stops at maxMessages or maxBytes scanned, or maxMatches found; then the result is marked incomplete and each partition's nextOffset is where to continue."),
+			mcp.WithString("name",
+				mcp.Required(),
+				mcp.Description("The name of the topic to search."),
+			),
+			mcp.WithArray("partitions",
+				mcp.Description("The partitions to search. Defaults to every partition."),
+				mcp.WithNumberItems(),
+			),
+			mcp.WithNumber("startOffset",
+				mcp.Description("Offset to start from in each partition. Defaults to the oldest message."),
+			),
+			mcp.WithNumber("endOffset",
+				mcp.Description("Offset to stop before in each partition. Defaults to the end of the partition."),
+			),
+			mcp.WithString("startTime",
+				mcp.Description("Only scan messages with a timestamp at or after this time, in RFC 3339 format or as a duration back from now such as 1h."),
+			),
+			mcp.WithString("endTime",
+				mcp.Description("Only scan messages with a timestamp before this time, in RFC 3339 format or as a duration back from now."),
+			),
+			mcp.WithString("contains",
+				mcp.Description("Text the message value must contain."),
+			),
+			mcp.WithString("pattern",
+				mcp.Description("Regular expression the message value must match."),
+			),
+			mcp.WithString("key",
+				mcp.Description("The message key must equal this."),
+			),
+			mcp.WithObject("headers",
+				mcp.Description("Header names and the values the message headers must have."),
+			),
+			mcp.WithString("where",
+				mcp.Description("Condition on the message, with `{{item.key}}`, `{{item.value.<path>}}` (the decoded JSON value), `{{item.headers.<name>}}`, `{{item.partition}}`, `{{item.offset}}` and `{{item.timestamp}}` compared with ==, !=, <, <=, >, >=, =~ (regular expression) or !~ and joined with && and ||, e.g. `{{item.value.order.amount}} > 100 && {{item.value.status}} == PAID`."),
+			),
+			mcp.WithNumber("maxMatches",
+				mcp.Description(fmt.Sprintf("Maximum number of matches to return. Defaults to %d.", defaultSearchMatches)),
+			),
+			mcp.WithNumber("maxMessages",
+				mcp.Description(fmt.Sprintf("Maximum number of messages to scan. Defaults to and is capped at %d.", cfg.Search.MaxMessages)),
+			),
+			mcp.WithNumber("maxBytes",
+				mcp.Description(fmt.Sprintf("Maximum number of key, value and header bytes to scan. Defaults to and is capped at %d.", cfg.Search.MaxBytes)),
+			),
+			withCluster(),
+		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			args := request.GetArguments()
+			topic, _ := args["name"].(string)
+			if err := cfg.checkTopic(topic); err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			filter, err := newMessageFilter(args)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			var startTime, endTime time.Time
+			now := time.Now()
+			if s, ok := args["startTime"].(string); ok && s != "" {
+				if startTime, err = parseSearchTime(s, now); err != nil {
+					return mcp.NewToolResultError(err.Error()), err
+				}
+			}
+			if s, ok := args["endTime"].(string); ok && s != "" {
+				if endTime, err = parseSearchTime(s, now); err != nil {
+					return mcp.NewToolResultError(err.Error()), err
+				}
+			}
+			maxMatches := defaultSearchMatches
+			if n, ok := args["maxMatches"].(float64); ok && n >= 1 {
+				maxMatches = int(n)
+			}
+			maxMessages, maxBytes := cfg.Search.MaxMessages, cfg.Search.MaxBytes
+			if n, ok := args["maxMessages"].(float64); ok && n >= 1 && int64(n) < maxMessages {
+				maxMessages = int64(n)
+			}
+			if n, ok := args["maxBytes"].(float64); ok && n >= 1 && int64(n) < maxBytes {
+				maxBytes = int64(n)
+			}
+
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			client, err := sarama.NewClient(cluster.BootstrapServers, config)
+			if err != nil {
+				err = fmt.Errorf("Failed to create Kafka client: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer metrics.TrackKafkaClient(cluster.Name, "consumer")()
+			defer client.Close()
+
+			var partitions []int32
+			_, span := startKafkaSpan(ctx, cluster, "Partitions")
+			err = awaitContext(ctx, func() (err error) {
+				partitions, err = client.Partitions(topic)
+				return err
+			})
+			tracing.End(span, err)
+			if err != nil {
+				err = fmt.Errorf("Failed to fetch partitions: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			if partitions, err = selectPartitions(topic, partitions, args); err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+
+			result := SearchResult{Matches: []ConsumerMessage{}, Complete: true}
+			var total int64
+			for _, partition := range partitions {
+				var r SearchedPartition
+				_, span := startKafkaSpan(ctx, cluster, "GetOffset")
+				err = awaitContext(ctx, func() (err error) {
+					r, err = searchRange(client, topic, partition, args, startTime, endTime)
+					return err
+				})
+				tracing.End(span, err)
+				if err != nil {
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				result.Partitions = append(result.Partitions, r)
+				total += r.EndOffset - r.StartOffset
+			}
+
+			consumer, err := sarama.NewConsumerFromClient(client)
+			if err != nil {
+				err = fmt.Errorf("Error creating consumer: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer consumer.Close()
+
+			progress := progressFromContext(ctx)
+			for i := range result.Partitions {
+				p := &result.Partitions[i]
+				if p.StartOffset >= p.EndOffset || !result.Complete {
+					continue
+				}
+				err = scanPartition(ctx, cluster, consumer, topic, p.Partition, p.StartOffset, p.EndOffset, func(message *sarama.ConsumerMessage) bool {
+					size := int64(len(message.Key) + len(message.Value))
+					for _, h := range message.Headers {
+						size += int64(len(h.Key) + len(h.Value))
+					}
+					switch {
+					case result.ScannedMessages >= maxMessages:
+						result.Complete, result.Reason = false, fmt.Sprintf("maxMessages of %d scanned", maxMessages)
+					case result.ScannedBytes+size > maxBytes:
+						result.Complete, result.Reason = false, fmt.Sprintf("maxBytes of %d scanned", maxBytes)
+					}
+					if !result.Complete {
+						return false
+					}
+					result.ScannedMessages++
+					result.ScannedBytes += size
+					p.Scanned++
+					p.NextOffset = message.Offset + 1
+					progress(float64(result.ScannedMessages), float64(total))
+
+					m := newConsumerMessage(message, cfg.Redactor)
+					ok, err := filter.match(m)
+					if err != nil {
+						if result.WhereErrors == 0 {
+							result.FirstWhereError = fmt.Sprintf("partition %d offset %d: %v", m.Partition, m.Offset, err)
+						}
+						result.WhereErrors++
+					}
+					if !ok {
+						return true
+					}
+					result.Matches = append(result.Matches, m)
+					p.Matches++
+					if len(result.Matches) >= maxMatches {
+						result.Complete, result.Reason = false, fmt.Sprintf("maxMatches of %d found", maxMatches)
+						return false
+					}
+					return true
+				})
+				if err != nil && ctx.Err() == nil {
+					err = fmt.Errorf("Error from consumer: %v", err)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				if ctx.Err() != nil {
+					resultJSON, _ := json.Marshal(result)
+					return newPartialToolResult(resultJSON, ctx.Err(), fmt.Sprintf("%d of %d messages scanned", result.ScannedMessages, total)), nil
+				}
+			}
+
+			resultJSON, _ := json.Marshal(result)
+			return mcp.NewToolResultText(string(resultJSON)), nil
+		}
+}
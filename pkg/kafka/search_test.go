@@ -0,0 +1,122 @@
+package kafka
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessageFilter(t *testing.T) {
+	messages := []ConsumerMessage{
+		{Key: "c-1", Value: `{"status":"PAID","amount":120}`, Headers: map[string]string{"source": "web"}, Partition: 0, Offset: 3},
+		{Key: "c-2", Value: `{"status":"PAID","amount":80}`, Headers: map[string]string{"source": "batch"}, Partition: 1, Offset: 7},
+		{Key: "c-1", Value: `{"status":"FAILED"}`, Partition: 0, Offset: 4},
+		{Key: "", Value: "not json: timeout", Partition: 2, Offset: 0},
+	}
+	tests := []struct {
+		name string
+		args map[string]any
+		want []int
+	}{
+		{"no filter", map[string]any{}, []int{0, 1, 2, 3}},
+		{"contains", map[string]any{"contains": "PAID"}, []int{0, 1}},
+		{"pattern", map[string]any{"pattern": `"amount":\d{3}`}, []int{0}},
+		{"key", map[string]any{"key": "c-1"}, []int{0, 2}},
+		{"empty key", map[string]any{"key": ""}, []int{3}},
+		{"headers", map[string]any{"headers": map[string]any{"source": "web"}}, []int{0}},
+		{"where on the value", map[string]any{"where": "{{item.value.amount}} > 100"}, []int{0}},
+		{"where on the partition", map[string]any{"where": "{{item.partition}} == 0 && {{item.offset}} >= 4"}, []int{2}},
+		{"where on a text value", map[string]any{"where": "{{item.value}} =~ timeout"}, []int{3}},
+		{"all predicates", map[string]any{"contains": "PAID", "key": "c-2", "where": "{{item.headers.source}} == batch"}, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newMessageFilter(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for i, m := range messages {
+				if ok, _ := f.match(m); ok {
+					got = append(got, i)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matching messages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageFilterWhereError(t *testing.T) {
+	f, err := newMessageFilter(map[string]any{"where": "{{item.value.amount}} > 100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := f.match(ConsumerMessage{Value: `{"status":"FAILED"}`}); ok || err == nil {
+		t.Errorf("match() of a message missing the field = %v, %v, want false and an error", ok, err)
+	}
+}
+
+func TestNewMessageFilterErrors(t *testing.T) {
+	for _, args := range []map[string]any{
+		{"pattern": "("},
+		{"where": "{{results[0].x}} > 1"},
+		{"where": "{{params.topic}} == orders"},
+	} {
+		if _, err := newMessageFilter(args); err == nil {
+			t.Errorf("newMessageFilter(%v) succeeded, want an error", args)
+		}
+	}
+}
+
+func TestCheckMessageReferences(t *testing.T) {
+	tests := []struct {
+		expression string
+		valid      bool
+	}{
+		{"{{item.value.amount}} > 10", true},
+		{"{{item.key}}", true},
+		{"true", true},
+		{"{{results[0].x}} > 1", false},
+		{"{{params.topic}}", false},
+	}
+	for _, tt := range tests {
+		if err := checkMessageReferences(tt.expression); (err == nil) != tt.valid {
+			t.Errorf("checkMessageReferences(%q) error = %v, want valid %v", tt.expression, err, tt.valid)
+		}
+	}
+}
+
+func TestParseSearchTime(t *testing.T) {
+	now := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		s    string
+		want time.Time
+	}{
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2025-04-30T08:00:00Z", time.Date(2025, 4, 30, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseSearchTime(tt.s, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseSearchTime(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+	if _, err := parseSearchTime("yesterday", now); err == nil {
+		t.Error("parseSearchTime(yesterday) succeeded")
+	}
+}
+
+func TestSelectPartitions(t *testing.T) {
+	all := []int32{0, 1, 2}
+	if got, err := selectPartitions("orders", all, map[string]any{}); err != nil || !reflect.DeepEqual(got, all) {
+		t.Errorf("selectPartitions() without partitions = %v, %v, want all", got, err)
+	}
+	if got, err := selectPartitions("orders", all, map[string]any{"partitions": []any{2.0, 0.0}}); err != nil || !reflect.DeepEqual(got, []int32{2, 0}) {
+		t.Errorf("selectPartitions() = %v, %v, want [2 0]", got, err)
+	}
+	if _, err := selectPartitions("orders", all, map[string]any{"partitions": []any{3.0}}); err == nil {
+		t.Error("selectPartitions() of a missing partition succeeded")
+	}
+}
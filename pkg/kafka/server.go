@@ -1,16 +1,45 @@
 package kafka
 
-import "github.com/mark3labs/mcp-go/server"
+import (
+	"fmt"
+	"os"
 
+	"github.com/CefBoud/kafka-mcp-server/pkg/audit"
+	"github.com/CefBoud/kafka-mcp-server/pkg/llm"
+	"github.com/CefBoud/kafka-mcp-server/pkg/redact"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Config holds the clusters the server can talk to.
 type Config struct {
-	BootstrapServers []string
+	Clusters map[string]*ClusterConfig
+	// DefaultCluster is used by tool calls that do not pass a cluster argument.
+	DefaultCluster string
+
+	Tools          ToolsConfig
+	Topics         NameFilter
+	ConsumerGroups NameFilter
+	// Redactor masks sensitive content of consumed messages. It is nil when no redaction is configured.
+	Redactor *redact.Redactor
+	// Audit records every tool call when set.
+	Audit *audit.Logger
+	// Timeouts bounds tool calls and Kafka requests.
+	Timeouts      TimeoutConfig
+	Subscriptions SubscriptionConfig
+	Multiplex     MultiplexConfig
+	Search        SearchConfig
+	Export        ExportConfig
+	// Recipes are registered as tools, unless a tool of a step is not available.
+	Recipes []Recipe
 }
 
 // NewServer creates a new Kafka MCP server with the specified GH client and logger.
-func NewServer(version string, readOnly bool, multiplex bool, multiplexModel string, cfg *Config, opts ...server.ServerOption) *server.MCPServer {
+func NewServer(version string, readOnly bool, multiplex bool, multiplexLLM llm.Provider, cfg *Config, opts ...server.ServerOption) *server.MCPServer {
 	// Add default options
 	defaultOpts := []server.ServerOption{
 		server.WithResourceCapabilities(true, true),
+		server.WithPromptCapabilities(false),
 		server.WithLogging(),
 	}
 	opts = append(defaultOpts, opts...)
@@ -22,23 +51,53 @@ func NewServer(version string, readOnly bool, multiplex bool, multiplexModel str
 		opts...,
 	)
 
-	s.AddTool(ConsumeMessagesTool(cfg))
-	s.AddTool(ListTopicsTool(cfg))
-	s.AddTool(TopicOffsetsTool(cfg))
-	s.AddTool(DescribeClusterTool(cfg))
-	s.AddTool(ListConsumerGroupsTool(cfg))
-	s.AddTool(DescribeConsumerGroupsTool(cfg))
+	addTool := func(tool mcp.Tool, handler server.ToolHandlerFunc) {
+		if !cfg.Tools.enabled(tool.Name) {
+			return
+		}
+		s.AddTool(traceTool(instrumentTool(cfg.auditTool(cfg.timeoutTool(progressTool(s)(authorizeTool(tool, handler)))))))
+	}
+
+	addTool(ListClustersTool(cfg))
+	addTool(ConsumeMessagesTool(cfg))
+	addTool(SearchMessagesTool(cfg))
+	addTool(AggregateMessagesTool(cfg))
+	addTool(ProfileTopicTool(cfg))
+	addTool(PartitionSkewTool(cfg))
+	addTool(ExportMessagesTool(cfg))
+	addTool(BackupTopicTool(cfg))
+	addTool(ListTopicsTool(cfg))
+	addTool(TopicOffsetsTool(cfg))
+	addTool(DescribeClusterTool(cfg))
+	addTool(ListConsumerGroupsTool(cfg))
+	addTool(DescribeConsumerGroupsTool(cfg))
 	if !readOnly {
-		s.AddTool(ProducerMessagesTool(cfg))
-		s.AddTool(CreateTopicTool(cfg))
+		addTool(ProducerMessagesTool(cfg))
+		addTool(CreateTopicTool(cfg))
+		addTool(RestoreTopicTool(cfg))
 	}
 
+	addResources(s, cfg)
+	addPrompts(s, cfg)
+
 	// Multiplexer
+	if multiplex || len(cfg.Recipes) > 0 {
+		s.EnableSampling()
+	}
 	if multiplex {
-		if err := ValidateLLMConfig(multiplexModel); err != nil {
-			panic(err)
+		addTool(MultiplexToolsTool(cfg, multiplexLLM, s))
+	}
+	for _, recipe := range cfg.Recipes {
+		if s.GetTool(recipe.Name) != nil {
+			fmt.Fprintf(os.Stderr, "recipe %s is not registered: a tool has the same name\n", recipe.Name)
+			continue
+		}
+		recipe, err := recipe.bindTools(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "recipe %s is not registered: %v\n", recipe.Name, err)
+			continue
 		}
-		s.AddTool(MultiplexToolsTool(cfg, multiplexModel, s))
+		addTool(RecipeTool(cfg, recipe, multiplexLLM, s))
 	}
 
 	return s
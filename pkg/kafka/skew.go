@@ -0,0 +1,356 @@
+package kafka
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
+	"github.com/IBM/sarama"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	defaultSkewWindow    = time.Hour
+	defaultSkewThreshold = 2.0
+	defaultHotKeys       = 10
+)
+
+// SkewResult compares the partitions of a topic.
+type SkewResult struct {
+	Partitions []PartitionSkew `json:"partitions"`
+	Window     string          `json:"window"`
+	// MessagesSkew and RateSkew are the ratios of the largest partition to the mean.
+	MessagesSkew float64 `json:"messagesSkew"`
+	RateSkew     float64 `json:"rateSkew"`
+	// SkewedPartitions lists the partitions flagged as hot or cold.
+	SkewedPartitions []int32 `json:"skewedPartitions"`
+	// Sampled counts the messages sampled for the keys, and TopKeys are their most frequent keys.
+	Sampled int       `json:"sampled,omitempty"`
+	TopKeys []*HotKey `json:"topKeys,omitempty"`
+	// PartitionerMatch is the fraction of the sampled messages with a key that are in the partition
+	// sarama's default hash partitioner assigns the key to. A low value means the producers use
+	// another partitioner, such as the murmur2 partitioner of the Java client.
+	PartitionerMatch *float64 `json:"partitionerMatch,omitempty"`
+}
+
+// PartitionSkew holds the message count and produce rate of a partition, compared to the mean of the
+// topic's partitions.
+type PartitionSkew struct {
+	Partition   int32 `json:"partition"`
+	StartOffset int64 `json:"startOffset"`
+	EndOffset   int64 `json:"endOffset"`
+	// Messages is the number of offsets between the start and end offsets, an upper bound of the
+	// messages of compacted partitions.
+	Messages int64 `json:"messages"`
+	// WindowMessages are the messages produced during the window, at Rate messages per second.
+	WindowMessages int64   `json:"windowMessages"`
+	Rate           float64 `json:"rate"`
+	MessagesRatio  float64 `json:"messagesRatio"`
+	RateRatio      float64 `json:"rateRatio"`
+	// Skew explains why the partition is flagged, e.g. "hot: 3.1x the mean rate".
+	Skew []string `json:"skew,omitempty"`
+	// Sampled counts the messages sampled from the partition and TopKey is their most frequent key,
+	// with its share of the partition's sample.
+	Sampled     int     `json:"sampled,omitempty"`
+	TopKey      *string `json:"topKey,omitempty"`
+	TopKeyShare float64 `json:"topKeyShare,omitempty"`
+}
+
+// HotKey is a frequent key of a sample.
+type HotKey struct {
+	Key   string  `json:"key"`
+	Count int     `json:"count"`
+	Share float64 `json:"share"`
+	// Partitions are the partitions the key was seen in, and HashPartition the partition sarama's
+	// default hash partitioner assigns it to.
+	Partitions    []int32 `json:"partitions"`
+	HashPartition int32   `json:"hashPartition"`
+}
+
+// flagSkew compares the partitions to their mean message count and rate. Partitions at threshold
+// times the mean or more are hot, and at the mean divided by threshold or less cold.
+func flagSkew(result *SkewResult, threshold float64) {
+	// the rates share the window, so their ratios are those of the window messages
+	var messages, rate float64
+	for _, p := range result.Partitions {
+		messages += float64(p.Messages)
+		rate += float64(p.WindowMessages)
+	}
+	n := float64(len(result.Partitions))
+	meanMessages, meanRate := messages/n, rate/n
+
+	flag := func(value, mean float64, what string) (float64, string) {
+		if mean == 0 {
+			return 0, ""
+		}
+		r := math.Round(value/mean*100) / 100
+		switch {
+		case r >= threshold:
+			return r, fmt.Sprintf("hot: %vx the mean %s", r, what)
+		case r <= 1/threshold:
+			return r, fmt.Sprintf("cold: %vx the mean %s", r, what)
+		}
+		return r, ""
+	}
+	result.SkewedPartitions = []int32{}
+	for i := range result.Partitions {
+		p := &result.Partitions[i]
+		var skew string
+		if p.MessagesRatio, skew = flag(float64(p.Messages), meanMessages, "message count"); skew != "" {
+			p.Skew = append(p.Skew, skew)
+		}
+		if p.RateRatio, skew = flag(float64(p.WindowMessages), meanRate, "rate"); skew != "" {
+			p.Skew = append(p.Skew, skew)
+		}
+		if len(p.Skew) > 0 {
+			result.SkewedPartitions = append(result.SkewedPartitions, p.Partition)
+		}
+		result.MessagesSkew = max(result.MessagesSkew, p.MessagesRatio)
+		result.RateSkew = max(result.RateSkew, p.RateRatio)
+	}
+}
+
+// keySample counts the sampled messages and their keys per partition.
+type keySample struct {
+	counts     map[string]int
+	partitions map[string]map[int32]int
+	sampled    map[int32]int
+	messages   int
+	keyed      int
+}
+
+func (s *keySample) add(message *sarama.ConsumerMessage) {
+	s.messages++
+	s.sampled[message.Partition]++
+	if message.Key == nil {
+		return
+	}
+	s.keyed++
+	key := string(message.Key)
+	s.counts[key]++
+	if s.partitions[key] == nil {
+		s.partitions[key] = map[int32]int{}
+	}
+	s.partitions[key][message.Partition]++
+}
+
+// analyze sets the sampled messages, top keys and partitioner match of the result. Keys are redacted
+// with the rules of topic.
+func (s *keySample) analyze(cfg *Config, result *SkewResult, topic string, topKeys int) {
+	result.Sampled = s.messages
+	numPartitions := int32(len(result.Partitions))
+	partitioner := sarama.NewHashPartitioner(topic)
+	hashPartition := func(key string) int32 {
+		partition, _ := partitioner.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder(key)}, numPartitions)
+		return partition
+	}
+
+	keys := make([]string, 0, len(s.counts))
+	matching := 0
+	for key := range s.counts {
+		keys = append(keys, key)
+		matching += s.partitions[key][hashPartition(key)]
+	}
+	if s.keyed > 0 {
+		match := ratio(matching, s.keyed)
+		result.PartitionerMatch = &match
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		if c := cmp.Compare(s.counts[b], s.counts[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+
+	redactKey := func(key string) string {
+		redacted, _ := cfg.Redactor.Message(topic, []byte(key))
+		return redacted
+	}
+	for i := range result.Partitions {
+		p := &result.Partitions[i]
+		p.Sampled = s.sampled[p.Partition]
+		top, topCount := "", 0
+		for key, partitions := range s.partitions {
+			if n := partitions[p.Partition]; n > topCount || n > 0 && n == topCount && key < top {
+				top, topCount = key, n
+			}
+		}
+		if topCount > 0 {
+			key := redactKey(top)
+			p.TopKey = &key
+			p.TopKeyShare = ratio(topCount, p.Sampled)
+		}
+	}
+
+	for _, key := range keys[:min(topKeys, len(keys))] {
+		hot := &HotKey{
+			Key:           redactKey(key),
+			Count:         s.counts[key],
+			Share:         ratio(s.counts[key], s.messages),
+			HashPartition: hashPartition(key),
+		}
+		for partition := range s.partitions[key] {
+			hot.Partitions = append(hot.Partitions, partition)
+		}
+		slices.Sort(hot.Partitions)
+		result.TopKeys = append(result.TopKeys, hot)
+	}
+}
+
+func PartitionSkewTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("analyzePartitionSkew",
+			mcp.WithDescription("Compares the message counts and produce rates of the partitions of a topic, from their offsets, and flags hot and cold partitions. "+
+				"With sampleMessages, also samples the newest messages of each partition and returns the most frequent keys, the partitions they are in "+
+				"and the partition sarama's default hash partitioner assigns them to."),
+			mcp.WithString("name",
+				mcp.Required(),
+				mcp.Description("The name of the topic to analyze."),
+			),
+			mcp.WithString("window",
+				mcp.Description("Period up to now the produce rates are measured over, e.g. 15m or 24h. Defaults to 1h."),
+			),
+			mcp.WithNumber("threshold",
+				mcp.Description(fmt.Sprintf("Partitions with at least threshold times the mean message count or rate are flagged hot, and with at most the mean divided by threshold cold. Defaults to %v.", defaultSkewThreshold)),
+			),
+			mcp.WithNumber("sampleMessages",
+				mcp.Description(fmt.Sprintf("Number of the newest messages of each partition sampled for the keys, at most %d. Defaults to 0, no sampling.", maxProfileMessages)),
+			),
+			mcp.WithNumber("topKeys",
+				mcp.Description(fmt.Sprintf("Number of most frequent keys returned. Defaults to %d.", defaultHotKeys)),
+			),
+			withCluster(),
+		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			args := request.GetArguments()
+			topic, _ := args["name"].(string)
+			if err := cfg.checkTopic(topic); err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			window := defaultSkewWindow
+			if s, ok := args["window"].(string); ok && s != "" {
+				d, err := time.ParseDuration(s)
+				if err != nil || d <= 0 {
+					err = fmt.Errorf("invalid window %q: expected a positive duration such as 1h", s)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				window = d
+			}
+			threshold := defaultSkewThreshold
+			if n, ok := args["threshold"].(float64); ok {
+				if n <= 1 {
+					err := fmt.Errorf("invalid threshold %v: it must be greater than 1", n)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				threshold = n
+			}
+			var sampleMessages int64
+			if n, ok := args["sampleMessages"].(float64); ok && n >= 1 {
+				sampleMessages = min(int64(n), maxProfileMessages)
+			}
+			topKeys := defaultHotKeys
+			if n, ok := args["topKeys"].(float64); ok && n >= 1 {
+				topKeys = int(n)
+			}
+
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			client, err := sarama.NewClient(cluster.BootstrapServers, config)
+			if err != nil {
+				err = fmt.Errorf("Failed to create Kafka client: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			defer metrics.TrackKafkaClient(cluster.Name, "consumer")()
+			defer client.Close()
+
+			var partitions []int32
+			_, span := startKafkaSpan(ctx, cluster, "Partitions")
+			err = awaitContext(ctx, func() (err error) {
+				partitions, err = client.Partitions(topic)
+				return err
+			})
+			tracing.End(span, err)
+			if err != nil {
+				err = fmt.Errorf("Failed to fetch partitions: %v", err)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+
+			result := &SkewResult{Window: window.String()}
+			since := time.Now().Add(-window)
+			progress := progressFromContext(ctx)
+			for i, partition := range partitions {
+				progress(float64(i), float64(len(partitions)))
+				p := PartitionSkew{Partition: partition}
+				_, span := startKafkaSpan(ctx, cluster, "GetOffset")
+				err = awaitContext(ctx, func() (err error) {
+					if p.StartOffset, err = client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+						return fmt.Errorf("Error getting start offset of partition %d: %v", partition, err)
+					}
+					if p.EndOffset, err = client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+						return fmt.Errorf("Error getting end offset of partition %d: %v", partition, err)
+					}
+					// the first offset produced since the start of the window, or -1 when there is none
+					offset, err := client.GetOffset(topic, partition, since.UnixMilli())
+					if err != nil {
+						return fmt.Errorf("Error getting offset of partition %d at %s: %v", partition, since, err)
+					}
+					if offset >= 0 {
+						p.WindowMessages = p.EndOffset - max(offset, p.StartOffset)
+					}
+					return nil
+				})
+				tracing.End(span, err)
+				if err != nil {
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				p.Messages = p.EndOffset - p.StartOffset
+				p.Rate = math.Round(float64(p.WindowMessages)/window.Seconds()*1000) / 1000
+				result.Partitions = append(result.Partitions, p)
+			}
+			progress(float64(len(partitions)), float64(len(partitions)))
+			if len(result.Partitions) > 0 {
+				flagSkew(result, threshold)
+			}
+
+			if sampleMessages > 0 {
+				consumer, err := sarama.NewConsumerFromClient(client)
+				if err != nil {
+					err = fmt.Errorf("Error creating consumer: %v", err)
+					return mcp.NewToolResultError(err.Error()), err
+				}
+				defer consumer.Close()
+
+				sample := &keySample{counts: map[string]int{}, partitions: map[string]map[int32]int{}, sampled: map[int32]int{}}
+				for _, p := range result.Partitions {
+					start := max(p.StartOffset, p.EndOffset-sampleMessages)
+					if start >= p.EndOffset {
+						continue
+					}
+					err = scanPartition(ctx, cluster, consumer, topic, p.Partition, start, p.EndOffset, func(message *sarama.ConsumerMessage) bool {
+						sample.add(message)
+						return true
+					})
+					if err != nil && ctx.Err() == nil {
+						err = fmt.Errorf("Error from consumer: %v", err)
+						return mcp.NewToolResultError(err.Error()), err
+					}
+					if ctx.Err() != nil {
+						sample.analyze(cfg, result, topic, topKeys)
+						resultJSON, _ := json.Marshal(result)
+						return newPartialToolResult(resultJSON, ctx.Err(), fmt.Sprintf("%d messages sampled", sample.messages)), nil
+					}
+				}
+				sample.analyze(cfg, result, topic, topKeys)
+			}
+
+			resultJSON, _ := json.Marshal(result)
+			return mcp.NewToolResultText(string(resultJSON)), nil
+		}
+}
@@ -0,0 +1,105 @@
+package kafka
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+func TestFlagSkew(t *testing.T) {
+	result := &SkewResult{Partitions: []PartitionSkew{
+		{Partition: 0, Messages: 100, WindowMessages: 10},
+		{Partition: 1, Messages: 100, WindowMessages: 50},
+		{Partition: 2, Messages: 100, WindowMessages: 0},
+		{Partition: 3, Messages: 20, WindowMessages: 20},
+	}}
+	flagSkew(result, 2)
+
+	want := []struct {
+		messagesRatio, rateRatio float64
+		skew                     []string
+	}{
+		{1.25, 0.5, []string{"cold: 0.5x the mean rate"}},
+		{1.25, 2.5, []string{"hot: 2.5x the mean rate"}},
+		{1.25, 0, []string{"cold: 0x the mean rate"}},
+		{0.25, 1, []string{"cold: 0.25x the mean message count"}},
+	}
+	for i, w := range want {
+		p := result.Partitions[i]
+		if p.MessagesRatio != w.messagesRatio || p.RateRatio != w.rateRatio || !reflect.DeepEqual(p.Skew, w.skew) {
+			t.Errorf("partition %d = %v, %v, %q, want %v, %v, %q", i, p.MessagesRatio, p.RateRatio, p.Skew, w.messagesRatio, w.rateRatio, w.skew)
+		}
+	}
+	if !reflect.DeepEqual(result.SkewedPartitions, []int32{0, 1, 2, 3}) || result.MessagesSkew != 1.25 || result.RateSkew != 2.5 {
+		t.Errorf("skewed = %v, messages skew = %v, rate skew = %v", result.SkewedPartitions, result.MessagesSkew, result.RateSkew)
+	}
+}
+
+func TestFlagSkewEmptyTopic(t *testing.T) {
+	result := &SkewResult{Partitions: []PartitionSkew{{Partition: 0}, {Partition: 1}}}
+	flagSkew(result, 2)
+	if len(result.SkewedPartitions) != 0 || result.MessagesSkew != 0 || result.RateSkew != 0 {
+		t.Errorf("flagSkew() of an empty topic = %+v", result)
+	}
+}
+
+func TestKeySampleAnalyze(t *testing.T) {
+	const topic = "orders"
+	partitioner := sarama.NewHashPartitioner(topic)
+	hashPartition := func(key string) int32 {
+		partition, _ := partitioner.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder(key)}, 2)
+		return partition
+	}
+	// a is always in its hash partition and b never is
+	a, b := hashPartition("a"), 1-hashPartition("b")
+
+	sample := &keySample{counts: map[string]int{}, partitions: map[string]map[int32]int{}, sampled: map[int32]int{}}
+	for _, m := range []*sarama.ConsumerMessage{
+		{Partition: a, Key: []byte("a")},
+		{Partition: a, Key: []byte("a")},
+		{Partition: a, Key: []byte("a")},
+		{Partition: b, Key: []byte("b")},
+		{Partition: 0},
+	} {
+		sample.add(m)
+	}
+	result := &SkewResult{Partitions: []PartitionSkew{{Partition: 0}, {Partition: 1}}}
+	sample.analyze(&Config{}, result, topic, 10)
+
+	if result.Sampled != 5 || result.PartitionerMatch == nil || *result.PartitionerMatch != 0.75 {
+		t.Errorf("sampled = %d, partitioner match = %v, want 5 and 0.75", result.Sampled, result.PartitionerMatch)
+	}
+	want := []*HotKey{
+		{Key: "a", Count: 3, Share: 0.6, Partitions: []int32{a}, HashPartition: a},
+		{Key: "b", Count: 1, Share: 0.2, Partitions: []int32{b}, HashPartition: 1 - b},
+	}
+	if !reflect.DeepEqual(result.TopKeys, want) {
+		t.Errorf("top keys = %+v, want %+v", result.TopKeys, want)
+	}
+	for _, p := range result.Partitions {
+		if p.Sampled != sample.sampled[p.Partition] || (p.TopKey == nil) != (p.Sampled == 0) {
+			t.Errorf("partition %d sampled = %d, top key = %v", p.Partition, p.Sampled, p.TopKey)
+			continue
+		}
+		if p.Partition == a && (*p.TopKey != "a" || p.TopKeyShare != ratio(3, p.Sampled)) {
+			t.Errorf("partition %d top key = %s (%v), want a", p.Partition, *p.TopKey, p.TopKeyShare)
+		}
+	}
+
+	result = &SkewResult{Partitions: []PartitionSkew{{Partition: 0}, {Partition: 1}}}
+	sample.analyze(&Config{}, result, topic, 1)
+	if len(result.TopKeys) != 1 || result.TopKeys[0].Key != "a" {
+		t.Errorf("top keys with topKeys 1 = %+v, want a", result.TopKeys)
+	}
+}
+
+func TestKeySampleAnalyzeWithoutKeys(t *testing.T) {
+	sample := &keySample{counts: map[string]int{}, partitions: map[string]map[int32]int{}, sampled: map[int32]int{}}
+	sample.add(&sarama.ConsumerMessage{Partition: 0})
+	result := &SkewResult{Partitions: []PartitionSkew{{Partition: 0}}}
+	sample.analyze(&Config{}, result, "logs", 10)
+	if result.PartitionerMatch != nil || result.TopKeys != nil || result.Partitions[0].TopKey != nil {
+		t.Errorf("analyze() without keys = %+v", result)
+	}
+}
@@ -0,0 +1,258 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// SubscriptionConfig controls the background polling of subscribed resources.
+type SubscriptionConfig struct {
+	PollInterval time.Duration
+	// LagThreshold is the total lag of a consumer group above which it is reported as lagging.
+	LagThreshold int64
+	// MaxPerSession caps the number of resources a session can subscribe to.
+	MaxPerSession int
+}
+
+// Subscriptions polls the resources clients subscribed to with `resources/subscribe` and sends
+// `notifications/resources/updated` when their state changes:
+//
+//   - kafka://cluster: topics created or deleted, brokers joining or leaving
+//   - kafka://topics/{name}: partitions, leaders, replicas, ISR or configuration changes
+//   - kafka://groups/{id}: total lag crossing the threshold, state or member count changes
+//
+// Offsets alone do not trigger notifications. The polls of a cluster share one Kafka client. Its methods
+// are meant to be registered as server hooks.
+type Subscriptions struct {
+	ctx     context.Context
+	cfg     *Config
+	clients *kafkaClients
+
+	mu sync.Mutex
+	// watches holds the cancel function of each polling goroutine, by session ID and URI.
+	watches map[string]map[string]context.CancelFunc
+}
+
+// NewSubscriptions creates the subscription poller. Polling stops, and its Kafka clients are closed,
+// when ctx is done.
+func NewSubscriptions(ctx context.Context, cfg *Config) *Subscriptions {
+	m := &Subscriptions{ctx: ctx, cfg: cfg, clients: &kafkaClients{}, watches: map[string]map[string]context.CancelFunc{}}
+	go func() {
+		<-ctx.Done()
+		m.clients.close()
+	}()
+	return m
+}
+
+// OnRequestInitialization rejects `resources/subscribe` requests for resources that do not support
+// subscriptions or that the caller cannot read, and requests beyond the session's subscription cap.
+// The server only lets this hook fail a request, so subscriptions are checked here and started by
+// AfterSubscribe.
+func (m *Subscriptions) OnRequestInitialization(ctx context.Context, id any, message any) error {
+	raw, ok := message.(json.RawMessage)
+	if !ok {
+		return nil
+	}
+	var request struct {
+		Method mcp.MCPMethod `json:"method"`
+		Params struct {
+			URI string `json:"uri"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(raw, &request); err != nil || request.Method != mcp.MethodResourcesSubscribe {
+		return nil
+	}
+	if _, err := m.snapshotFunc(ctx, request.Params.URI); err != nil {
+		return fmt.Errorf("cannot subscribe to %s: %w", request.Params.URI, err)
+	}
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkCap(session.SessionID(), request.Params.URI); err != nil {
+		return fmt.Errorf("cannot subscribe to %s: %w", request.Params.URI, err)
+	}
+	return nil
+}
+
+// checkCap returns an error when the session cannot subscribe to another resource. It must be called
+// with m.mu held.
+func (m *Subscriptions) checkCap(sessionID, uri string) error {
+	watches := m.watches[sessionID]
+	if _, ok := watches[uri]; !ok && len(watches) >= m.cfg.Subscriptions.MaxPerSession {
+		return fmt.Errorf("the session already has %d subscriptions", len(watches))
+	}
+	return nil
+}
+
+// AfterSubscribe starts polling the subscribed resource for the client session.
+func (m *Subscriptions) AfterSubscribe(ctx context.Context, id any, message *mcp.SubscribeRequest, result *mcp.EmptyResult) {
+	s := server.ServerFromContext(ctx)
+	session := server.ClientSessionFromContext(ctx)
+	if s == nil || session == nil {
+		return
+	}
+	uri := message.Params.URI
+	snapshot, err := m.snapshotFunc(ctx, uri)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ignoring subscription to %s: %v\n", uri, err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessionID := session.SessionID()
+	if m.watches[sessionID] == nil {
+		m.watches[sessionID] = map[string]context.CancelFunc{}
+	}
+	if _, ok := m.watches[sessionID][uri]; ok {
+		return
+	}
+	// concurrent requests of a session can both pass OnRequestInitialization
+	if err := m.checkCap(sessionID, uri); err != nil {
+		fmt.Fprintf(os.Stderr, "ignoring subscription to %s: %v\n", uri, err)
+		return
+	}
+	watchCtx, cancel := context.WithCancel(withKafkaClients(m.ctx, m.clients))
+	m.watches[sessionID][uri] = cancel
+	go m.poll(watchCtx, s, sessionID, uri, snapshot)
+}
+
+// AfterUnsubscribe stops polling the resource for the client session.
+func (m *Subscriptions) AfterUnsubscribe(ctx context.Context, id any, message *mcp.UnsubscribeRequest, result *mcp.EmptyResult) {
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cancel, ok := m.watches[session.SessionID()][message.Params.URI]; ok {
+		cancel()
+		delete(m.watches[session.SessionID()], message.Params.URI)
+	}
+}
+
+// OnUnregisterSession stops polling every resource the closed session subscribed to.
+func (m *Subscriptions) OnUnregisterSession(ctx context.Context, session server.ClientSession) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, cancel := range m.watches[session.SessionID()] {
+		cancel()
+	}
+	delete(m.watches, session.SessionID())
+}
+
+// poll compares the resource's snapshot every poll interval and notifies the session when it differs
+// from the previous one. Failed polls are skipped rather than reported as changes.
+func (m *Subscriptions) poll(ctx context.Context, s *server.MCPServer, sessionID, uri string, snapshot func(context.Context) (string, error)) {
+	previous, _ := snapshot(ctx)
+	ticker := time.NewTicker(m.cfg.Subscriptions.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current, err := snapshot(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "polling %s: %v\n", uri, err)
+			continue
+		}
+		if previous != "" && current != previous {
+			err = s.SendNotificationToSpecificClient(sessionID, mcp.MethodNotificationResourceUpdated, map[string]any{"uri": uri})
+			if err == server.ErrSessionNotFound {
+				return
+			}
+		}
+		previous = current
+	}
+}
+
+// snapshotFunc returns a function computing the state of a subscribable resource that notifications
+// are sent for, as a comparable string. It returns an error when the resource does not support
+// subscriptions, or when the caller in ctx cannot read it: its cluster, topic or consumer group is not
+// accessible, or the tool the resource shares its checks with is disabled or not allowed.
+func (m *Subscriptions) snapshotFunc(ctx context.Context, uri string) (func(context.Context) (string, error), error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "kafka" {
+		return nil, fmt.Errorf("not a kafka resource")
+	}
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	cluster := u.Query().Get("cluster")
+
+	var read resourceReader
+	var state func(any) any
+	var toolName string
+	switch {
+	case u.Host == "cluster" && u.Path == "":
+		read, toolName = m.cfg.readCluster, "describeCluster"
+		state = func(v any) any { return v }
+	case u.Host == "topics" && len(path) == 1 && path[0] != "":
+		if err := m.cfg.checkTopic(path[0]); err != nil {
+			return nil, err
+		}
+		read, toolName = m.cfg.readTopic, "topicOffsets"
+		state = func(v any) any {
+			topic := v.(TopicInfo)
+			for i := range topic.Partitions {
+				topic.Partitions[i].StartOffset, topic.Partitions[i].EndOffset = 0, 0
+			}
+			return topic
+		}
+	case u.Host == "groups" && len(path) == 1 && path[0] != "":
+		if err := m.cfg.checkGroup(path[0]); err != nil {
+			return nil, err
+		}
+		read, toolName = m.cfg.readGroup, "describeConsumerGroups"
+		state = func(v any) any {
+			group := v.(*GroupInfo)
+			var lag int64
+			for _, o := range group.Offsets {
+				lag += o.Lag
+			}
+			return struct {
+				State   string
+				Members int
+				Lagging bool
+			}{group.State, group.Members, lag > m.cfg.Subscriptions.LagThreshold}
+		}
+	default:
+		return nil, fmt.Errorf("only kafka://cluster, kafka://topics/{name} and kafka://groups/{id} support subscriptions")
+	}
+	if !m.cfg.Tools.enabled(toolName) {
+		return nil, fmt.Errorf("%s is disabled", toolName)
+	}
+	if err := checkToolAllowed(ctx, toolName); err != nil {
+		return nil, err
+	}
+	clusterCfg, config, err := m.cfg.namedClusterConfig(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) (string, error) {
+		if m.cfg.Timeouts.Call > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, m.cfg.Timeouts.Call)
+			defer cancel()
+		}
+		v, err := read(ctx, clusterCfg, config, path, u.Query())
+		if err != nil {
+			return "", err
+		}
+		snapshot, err := json.Marshal(state(v))
+		return string(snapshot), err
+	}, nil
+}
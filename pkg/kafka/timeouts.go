@@ -0,0 +1,114 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// TimeoutConfig bounds how long tool calls and the Kafka requests they make may take.
+// Zero values keep the sarama defaults.
+type TimeoutConfig struct {
+	// Call is the timeout of a tool call that does not pass `timeoutMs`.
+	Call time.Duration
+	// MaxCall caps the `timeoutMs` argument.
+	MaxCall time.Duration
+
+	Dial     time.Duration
+	Metadata time.Duration
+	Read     time.Duration
+}
+
+// apply sets the network timeouts on a sarama configuration.
+func (t TimeoutConfig) apply(config *sarama.Config) {
+	if t.Dial > 0 {
+		config.Net.DialTimeout = t.Dial
+	}
+	if t.Read > 0 {
+		config.Net.ReadTimeout = t.Read
+	}
+	if t.Metadata > 0 {
+		config.Metadata.Timeout = t.Metadata
+	}
+}
+
+// callTimeout returns the timeout requested by the `timeoutMs` argument, bounded by MaxCall.
+func (t TimeoutConfig) callTimeout(request mcp.CallToolRequest) time.Duration {
+	timeout := t.Call
+	if ms, ok := request.GetArguments()["timeoutMs"].(float64); ok && ms > 0 {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	if t.MaxCall > 0 && (timeout <= 0 || timeout > t.MaxCall) {
+		timeout = t.MaxCall
+	}
+	return timeout
+}
+
+// timeoutTool adds the `timeoutMs` argument to tools that talk to a cluster and runs their handler
+// with a context that expires after the requested timeout. The context is derived from the request,
+// so cancellation by the client also stops the call.
+func (cfg *Config) timeoutTool(tool mcp.Tool, handler server.ToolHandlerFunc) (mcp.Tool, server.ToolHandlerFunc) {
+	if _, ok := tool.InputSchema.Properties["cluster"]; !ok {
+		return tool, handler
+	}
+	mcp.WithNumber("timeoutMs",
+		mcp.Description(fmt.Sprintf("Maximum duration of the call in milliseconds. Defaults to %d, capped at %d. When it expires, the results gathered so far are returned and marked as partial.",
+			cfg.Timeouts.Call.Milliseconds(), cfg.Timeouts.MaxCall.Milliseconds())),
+	)(&tool)
+
+	return tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if timeout := cfg.Timeouts.callTimeout(request); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return handler(ctx, request)
+	}
+}
+
+// awaitContext runs a blocking Kafka call and returns ctx's error as soon as ctx is done. The call
+// keeps running in the background until it completes or its client is closed, so callers must not
+// read the values it sets when an error is returned.
+func awaitContext(ctx context.Context, call func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- call() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Partial describes why a tool result is incomplete. It is returned as a second content item after
+// the data.
+type Partial struct {
+	Partial bool   `json:"partial"`
+	Reason  string `json:"reason"`
+}
+
+// partialReason describes the context error that interrupted a call.
+func partialReason(err error, progress string) string {
+	reason := "cancelled"
+	if errors.Is(err, context.DeadlineExceeded) {
+		reason = "timed out"
+	}
+	return reason + ": " + progress
+}
+
+// newPartialToolResult returns the data gathered before ctx was done, followed by a Partial marker.
+func newPartialToolResult(data []byte, err error, progress string) *mcp.CallToolResult {
+	marker, _ := json.Marshal(Partial{Partial: true, Reason: partialReason(err, progress)})
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.NewTextContent(string(data)),
+			mcp.NewTextContent(string(marker)),
+		},
+	}
+}
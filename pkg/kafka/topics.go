@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
 	"github.com/IBM/sarama"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -14,18 +16,35 @@ import (
 func ListTopicsTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc) {
 	return mcp.NewTool("listTopics",
 			mcp.WithDescription("List topics present in the cluster"),
+			withCluster(),
 		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			config := sarama.NewConfig()
-			admin, err := sarama.NewClusterAdmin(cfg.BootstrapServers, config)
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			admin, err := sarama.NewClusterAdmin(cluster.BootstrapServers, config)
 			if err != nil {
 				err = fmt.Errorf("Error init kafka admin client: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
-			topics, err := admin.ListTopics()
+			defer metrics.TrackKafkaClient(cluster.Name, "admin")()
+			defer admin.Close()
+			var topics map[string]sarama.TopicDetail
+			_, span := startKafkaSpan(ctx, cluster, "ListTopics")
+			err = awaitContext(ctx, func() (err error) {
+				topics, err = admin.ListTopics()
+				return err
+			})
+			tracing.End(span, err)
 			if err != nil {
 				err = fmt.Errorf("Error listing topics: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
+			for name := range topics {
+				if !cfg.Topics.Allowed(name) {
+					delete(topics, name)
+				}
+			}
 
 			result, err := json.Marshal(topics)
 			if err != nil {
@@ -52,17 +71,35 @@ func CreateTopicTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFunc
 				mcp.Required(),
 				mcp.Description("Number of partitions"),
 			),
+			withCluster(),
 		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			Name := request.Params.Arguments["name"].(string)
-			replicationFactor := request.Params.Arguments["replicationFactor"].(float64)
-			numPartitions := request.Params.Arguments["numPartitions"].(float64)
+			Name := request.GetArguments()["name"].(string)
+			replicationFactor := request.GetArguments()["replicationFactor"].(float64)
+			numPartitions := request.GetArguments()["numPartitions"].(float64)
+			if err := cfg.checkTopic(Name); err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
 
-			admin, err := sarama.NewClusterAdmin(cfg.BootstrapServers, sarama.NewConfig())
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			if cluster.ReadOnly {
+				err = fmt.Errorf("cluster %s is read-only", cluster.Name)
+				return mcp.NewToolResultError(err.Error()), err
+			}
+			admin, err := sarama.NewClusterAdmin(cluster.BootstrapServers, config)
 			if err != nil {
 				err = fmt.Errorf("Error init kafka admin client: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
-			err = admin.CreateTopic(Name, &sarama.TopicDetail{NumPartitions: int32(numPartitions), ReplicationFactor: int16(replicationFactor)}, false)
+			defer metrics.TrackKafkaClient(cluster.Name, "admin")()
+			defer admin.Close()
+			_, span := startKafkaSpan(ctx, cluster, "CreateTopic")
+			err = awaitContext(ctx, func() error {
+				return admin.CreateTopic(Name, &sarama.TopicDetail{NumPartitions: int32(numPartitions), ReplicationFactor: int16(replicationFactor)}, false)
+			})
+			tracing.End(span, err)
 			if err != nil {
 				err = fmt.Errorf("Error creating topic: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
@@ -79,35 +116,65 @@ func TopicOffsetsTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFun
 				mcp.Required(),
 				mcp.Description("The name of the Kafka topic."),
 			),
+			withCluster(),
 		), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 
-			topic := request.Params.Arguments["name"].(string)
+			topic := request.GetArguments()["name"].(string)
+			if err := cfg.checkTopic(topic); err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
 
-			config := sarama.NewConfig()
+			cluster, config, err := cfg.clusterConfig(request)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), err
+			}
 
-			client, err := sarama.NewClient(cfg.BootstrapServers, config)
+			client, err := sarama.NewClient(cluster.BootstrapServers, config)
 			if err != nil {
 				err = fmt.Errorf("Failed to create Kafka client: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
+			defer metrics.TrackKafkaClient(cluster.Name, "client")()
 			defer client.Close()
 
-			partitions, err := client.Partitions(topic)
+			var partitions []int32
+			_, span := startKafkaSpan(ctx, cluster, "Partitions")
+			err = awaitContext(ctx, func() (err error) {
+				partitions, err = client.Partitions(topic)
+				return err
+			})
+			tracing.End(span, err)
 			if err != nil {
 				err = fmt.Errorf("Failed to fetch partitions: %v", err)
 				return mcp.NewToolResultError(err.Error()), err
 			}
 
 			offsets := []PartitionOffset{}
+			progress := progressFromContext(ctx)
 
-			for _, partition := range partitions {
-				startOffset, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+			for i, partition := range partitions {
+				progress(float64(i), float64(len(partitions)))
+				if ctx.Err() != nil {
+					break
+				}
+				var startOffset, endOffset int64
+				_, span := startKafkaSpan(ctx, cluster, "GetOffset")
+				err := awaitContext(ctx, func() (err error) {
+					startOffset, err = client.GetOffset(topic, partition, sarama.OffsetOldest)
+					return err
+				})
+				tracing.End(span, err)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "Error getting start offset for partition %d: %v", partition, err)
 					continue
 				}
 
-				endOffset, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+				_, span = startKafkaSpan(ctx, cluster, "GetOffset")
+				err = awaitContext(ctx, func() (err error) {
+					endOffset, err = client.GetOffset(topic, partition, sarama.OffsetNewest)
+					return err
+				})
+				tracing.End(span, err)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "Error getting end offset for partition %d: %v", partition, err)
 					continue
@@ -121,6 +188,10 @@ func TopicOffsetsTool(cfg *Config) (tool mcp.Tool, handler server.ToolHandlerFun
 			}
 
 			offsetsJson, _ := json.Marshal(offsets)
+			if ctx.Err() != nil {
+				return newPartialToolResult(offsetsJson, ctx.Err(), fmt.Sprintf("offsets of %d of %d partitions fetched", len(offsets), len(partitions))), nil
+			}
+			progress(float64(len(partitions)), float64(len(partitions)))
 			return mcp.NewToolResultText(string(offsetsJson)), nil
 		}
 }
@@ -0,0 +1,43 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CefBoud/kafka-mcp-server/pkg/tracing"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceTool wraps a tool handler in a span covering the whole MCP request. Kafka operations and
+// multiplexed calls made by the handler are recorded as child spans.
+func traceTool(tool mcp.Tool, handler server.ToolHandlerFunc) (mcp.Tool, server.ToolHandlerFunc) {
+	return tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		attrs := []attribute.KeyValue{attribute.String("mcp.method", string(mcp.MethodToolsCall)), attribute.String("mcp.tool", tool.Name)}
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			attrs = append(attrs, attribute.String("mcp.session_id", session.SessionID()))
+		}
+		if cluster, ok := request.GetArguments()["cluster"].(string); ok {
+			attrs = append(attrs, attribute.String("kafka.cluster", cluster))
+		}
+		ctx, span := tracing.Start(ctx, "tools/call "+tool.Name, attrs...)
+
+		result, err := handler(ctx, request)
+		spanErr := err
+		if spanErr == nil && result != nil && result.IsError {
+			// Only the span records the error: returning it would make the server answer with a
+			// protocol error and drop the error result.
+			spanErr = fmt.Errorf("%s", resultText(result))
+		}
+		tracing.End(span, spanErr)
+		return result, err
+	}
+}
+
+// startKafkaSpan starts the span of a Kafka client or admin operation.
+func startKafkaSpan(ctx context.Context, cluster *ClusterConfig, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("messaging.system", "kafka"), attribute.String("kafka.cluster", cluster.Name))
+	return tracing.Start(ctx, "kafka "+operation, attrs...)
+}
@@ -22,9 +22,34 @@ type GroupPartitionInfo struct {
 
 type GroupInfo struct {
 	GroupID string               `json:"groupId"`
+	State   string               `json:"state,omitempty"`
+	Members int                  `json:"members"`
 	Offsets []GroupPartitionInfo `json:"offsets"`
 }
 
+type TopicPartitionInfo struct {
+	Partition   int32   `json:"partition"`
+	Leader      int32   `json:"leader"`
+	Replicas    []int32 `json:"replicas"`
+	ISR         []int32 `json:"isr"`
+	StartOffset int64   `json:"startOffset"`
+	EndOffset   int64   `json:"endOffset"`
+}
+
+type TopicInfo struct {
+	Name       string               `json:"name"`
+	Partitions []TopicPartitionInfo `json:"partitions"`
+	// Config holds the topic's non-default configuration entries.
+	Config map[string]string `json:"config,omitempty"`
+}
+
+type BrokerInfo struct {
+	Broker
+	Controller bool `json:"controller"`
+	// Config holds the broker's non-default configuration entries.
+	Config map[string]string `json:"config,omitempty"`
+}
+
 type MessagePartitionOffset struct {
 	Partition int
 	Offset    int
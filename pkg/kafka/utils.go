@@ -3,12 +3,14 @@ package kafka
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
+	"github.com/CefBoud/kafka-mcp-server/pkg/llm"
+	"github.com/CefBoud/kafka-mcp-server/pkg/metrics"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 // topicPartition takes in a topic and partition and returns `{topic}-{partition}`
@@ -16,54 +18,64 @@ func topicPartition(topic string, partition int32) string {
 	return fmt.Sprintf("%v-%v", topic, partition)
 }
 
-func ValidateLLMConfig(model string) error {
-	if ModelType(model) == GeminiModel {
-		_, ok := os.LookupEnv("GEMINI_API_KEY")
-		if !ok {
-			return fmt.Errorf("GEMINI_API_KEY not defined")
-		}
-		return nil
+// QueryLLM takes in a prompt and returns the model's response. The connected client's model is
+// asked through MCP sampling when the client supports it, and provider otherwise or when sampling
+// fails. A nil provider means PROMPT_ARGUMENTs are only inferred through sampling.
+func QueryLLM(ctx context.Context, prompt string, provider llm.Provider) (string, error) {
+	request := llm.Request{
+		System:    "You infer the arguments of tool calls. Reply with the value of the argument only.",
+		Prompt:    prompt,
+		MaxTokens: 1024,
 	}
-	return fmt.Errorf("Only 'gemini' is supported for now.")
-
-}
 
-// queryLLM takes in a prompt and return the model's response.
-// Only Gemini is supported for now
-func QueryLLM(prompt string, modelLLM ModelType) (string, error) {
+	var samplingErr error
+	if clientSupportsSampling(ctx) {
+		result, err := observeLLM(llm.ProviderSampling, func() (string, error) { return querySampling(ctx, request) })
+		if err == nil {
+			return result, nil
+		}
+		samplingErr = fmt.Errorf("sampling failed: %w", err)
+	} else {
+		samplingErr = fmt.Errorf("the client does not support sampling")
+	}
 
-	if modelLLM == GeminiModel {
-		return queryGemini(prompt)
+	if provider != nil {
+		fmt.Fprintf(os.Stderr, "%v, falling back to %s\n", samplingErr, provider.Name())
+		return observeLLM(provider.Name(), func() (string, error) { return provider.Complete(ctx, request) })
 	}
-	return "", nil
+	return "", samplingErr
 }
 
-func queryGemini(prompt string) (string, error) {
-	GEMINI_API_KEY := os.Getenv("GEMINI_API_KEY")
-
-	// fmt.Fprintf(os.Stderr, "queryGemini prompt %v\n", prompt)
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(GEMINI_API_KEY))
+// observeLLM records the outcome and latency of a query to the model.
+func observeLLM(model string, query func() (string, error)) (string, error) {
+	start := time.Now()
+	result, err := query()
+	outcome := "success"
 	if err != nil {
-		log.Fatal(err)
+		outcome = "error"
 	}
-	defer client.Close()
+	metrics.LLMQueries.WithLabelValues(model, outcome).Inc()
+	metrics.LLMDuration.WithLabelValues(model).Observe(time.Since(start).Seconds())
+	return result, err
+}
+
+// clientSupportsSampling reports whether the client of the current session declared the sampling capability.
+func clientSupportsSampling(ctx context.Context) bool {
+	session, ok := server.ClientSessionFromContext(ctx).(server.SessionWithClientInfo)
+	return ok && server.ServerFromContext(ctx) != nil && session.GetClientCapabilities().Sampling != nil
+}
 
-	model := client.GenerativeModel("gemini-2.0-flash")
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+// querySampling asks the client's model through an MCP `sampling/createMessage` request.
+func querySampling(ctx context.Context, r llm.Request) (string, error) {
+	request := mcp.CreateMessageRequest{}
+	request.CreateMessageParams.SystemPrompt = r.System
+	request.CreateMessageParams.Messages = []mcp.SamplingMessage{{Role: mcp.RoleUser, Content: mcp.NewTextContent(r.Prompt)}}
+	request.CreateMessageParams.MaxTokens = r.MaxTokens
+	request.CreateMessageParams.ModelPreferences = &mcp.ModelPreferences{SpeedPriority: 0.8, CostPriority: 0.5}
 
+	result, err := server.ServerFromContext(ctx).RequestSampling(ctx, request)
 	if err != nil {
-		log.Fatal(err)
-	}
-	for _, cand := range resp.Candidates {
-		if cand.Content != nil {
-			result := ""
-			for _, part := range cand.Content.Parts {
-				result += fmt.Sprintf("%v", part)
-			}
-			// fmt.Fprintf(os.Stderr, "queryGemini result : %v", result)
-			return strings.TrimSpace(result), nil
-		}
+		return "", err
 	}
-	return "", nil
+	return strings.TrimSpace(mcp.GetTextFromContent(result.Content)), nil
 }
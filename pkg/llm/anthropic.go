@@ -0,0 +1,60 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// anthropicVersion is the Messages API version the requests are written for.
+const anthropicVersion = "2023-06-01"
+
+// anthropic talks to the Anthropic Messages API.
+type anthropic struct {
+	cfg Config
+}
+
+func (p *anthropic) Name() string {
+	return ProviderAnthropic + ":" + p.cfg.Model
+}
+
+func (p *anthropic) Complete(ctx context.Context, request Request) (string, error) {
+	maxTokens := request.MaxTokens
+	if maxTokens <= 0 {
+		// the Messages API requires max_tokens
+		maxTokens = 1024
+	}
+	body := map[string]any{
+		"model":       p.cfg.Model,
+		"max_tokens":  maxTokens,
+		"temperature": p.cfg.Temperature,
+		"messages":    []map[string]string{{"role": "user", "content": request.Prompt}},
+	}
+	if request.System != "" {
+		body["system"] = request.System
+	}
+	headers := map[string]string{
+		"x-api-key":         p.cfg.APIKey,
+		"anthropic-version": anthropicVersion,
+	}
+
+	var response struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := postJSON(ctx, p.cfg.Endpoint+"/messages", headers, body, &response); err != nil {
+		return "", fmt.Errorf("anthropic query failed: %w", err)
+	}
+	var result strings.Builder
+	for _, c := range response.Content {
+		if c.Type == "text" {
+			result.WriteString(c.Text)
+		}
+	}
+	if result.Len() == 0 {
+		return "", fmt.Errorf("anthropic returned no text")
+	}
+	return strings.TrimSpace(result.String()), nil
+}
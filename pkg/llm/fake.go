@@ -0,0 +1,45 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake is a deterministic Provider for tests. It returns its replies in order, repeating the last
+// one, and records the requests it receives.
+type Fake struct {
+	mu       sync.Mutex
+	replies  []string
+	requests []Request
+}
+
+// NewFake returns a Fake answering with replies. `--multiplex-model fake:<reply>` selects a Fake
+// that always answers <reply>.
+func NewFake(replies ...string) *Fake {
+	return &Fake{replies: replies}
+}
+
+func (f *Fake) Name() string {
+	return ProviderFake
+}
+
+func (f *Fake) Complete(ctx context.Context, request Request) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, request)
+	if len(f.replies) == 0 {
+		return "", nil
+	}
+	i := min(len(f.requests), len(f.replies)) - 1
+	return f.replies[i], nil
+}
+
+// Requests returns the requests received so far.
+func (f *Fake) Requests() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request(nil), f.requests...)
+}
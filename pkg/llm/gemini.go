@@ -0,0 +1,54 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// gemini talks to the Gemini API through the Google Generative AI client.
+type gemini struct {
+	cfg Config
+}
+
+func (p *gemini) Name() string {
+	return ProviderGemini + ":" + p.cfg.Model
+}
+
+func (p *gemini) Complete(ctx context.Context, request Request) (string, error) {
+	opts := []option.ClientOption{option.WithAPIKey(p.cfg.APIKey)}
+	if p.cfg.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(p.cfg.Endpoint))
+	}
+	client, err := genai.NewClient(ctx, opts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create the Gemini client: %w", err)
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel(p.cfg.Model)
+	model.SetTemperature(float32(p.cfg.Temperature))
+	if request.MaxTokens > 0 {
+		model.SetMaxOutputTokens(int32(request.MaxTokens))
+	}
+	if request.System != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(request.System))
+	}
+	resp, err := model.GenerateContent(ctx, genai.Text(request.Prompt))
+	if err != nil {
+		return "", fmt.Errorf("gemini query failed: %w", err)
+	}
+	for _, cand := range resp.Candidates {
+		if cand.Content != nil {
+			result := ""
+			for _, part := range cand.Content.Parts {
+				result += fmt.Sprintf("%v", part)
+			}
+			return strings.TrimSpace(result), nil
+		}
+	}
+	return "", fmt.Errorf("gemini returned no candidates")
+}
@@ -0,0 +1,156 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Providers supported by New.
+const (
+	ProviderSampling  = "sampling"
+	ProviderOpenAI    = "openai"
+	ProviderOllama    = "ollama"
+	ProviderAnthropic = "anthropic"
+	ProviderGemini    = "gemini"
+	ProviderFake      = "fake"
+)
+
+// Config selects and configures a provider.
+type Config struct {
+	Provider string
+	// Model defaults to a small, fast model of the provider.
+	Model string
+	// Endpoint is the base URL of the API, e.g. http://localhost:11434/v1 for an OpenAI-compatible
+	// local server. Defaults to the provider's public API.
+	Endpoint string
+	// APIKey defaults to the provider's environment variable, e.g. OPENAI_API_KEY.
+	APIKey      string
+	Temperature float64
+	// Timeout bounds each completion. Zero means no timeout beyond the caller's context.
+	Timeout time.Duration
+}
+
+// Request is a single-turn completion request.
+type Request struct {
+	System    string
+	Prompt    string
+	MaxTokens int
+}
+
+// Provider completes prompts with a language model.
+type Provider interface {
+	// Name identifies the provider and model, e.g. `openai:gpt-4o-mini`.
+	Name() string
+	Complete(ctx context.Context, request Request) (string, error)
+}
+
+type defaults struct {
+	model, endpoint, apiKeyEnv string
+	// keyOptional is set for local servers, which usually do not check API keys.
+	keyOptional bool
+}
+
+var providerDefaults = map[string]defaults{
+	ProviderOpenAI:    {model: "gpt-4o-mini", endpoint: "https://api.openai.com/v1", apiKeyEnv: "OPENAI_API_KEY"},
+	ProviderOllama:    {model: "llama3.2", endpoint: "http://localhost:11434/v1", keyOptional: true},
+	ProviderAnthropic: {model: "claude-3-5-haiku-latest", endpoint: "https://api.anthropic.com/v1", apiKeyEnv: "ANTHROPIC_API_KEY"},
+	ProviderGemini:    {model: "gemini-2.0-flash", apiKeyEnv: "GEMINI_API_KEY"},
+}
+
+// ParseModel splits a `provider[:model]` specification, e.g. `ollama:qwen2.5:7b`. The model part
+// may itself contain colons.
+func ParseModel(spec string) (provider, model string) {
+	provider, model, _ = strings.Cut(spec, ":")
+	return provider, model
+}
+
+// New creates the provider described by cfg. It returns nil for the sampling provider, which is
+// served by the MCP client rather than by this package.
+func New(cfg Config) (Provider, error) {
+	if cfg.Provider == ProviderFake {
+		return NewFake(cfg.Model), nil
+	}
+	if cfg.Provider == "" || cfg.Provider == ProviderSampling {
+		return nil, nil
+	}
+	d, ok := providerDefaults[cfg.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q (expected sampling, openai, ollama, anthropic, gemini or fake)", cfg.Provider)
+	}
+	if cfg.Model == "" {
+		cfg.Model = d.model
+	}
+	if cfg.APIKey == "" && d.apiKeyEnv != "" {
+		cfg.APIKey = os.Getenv(d.apiKeyEnv)
+	}
+	// a custom endpoint is usually a local OpenAI-compatible server
+	if cfg.APIKey == "" && !d.keyOptional && (cfg.Endpoint == "" || cfg.Provider != ProviderOpenAI) {
+		return nil, fmt.Errorf("%s requires an API key (%s not defined)", cfg.Provider, d.apiKeyEnv)
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = d.endpoint
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+
+	var p Provider
+	switch cfg.Provider {
+	case ProviderOpenAI, ProviderOllama:
+		p = &openAI{cfg: cfg}
+	case ProviderAnthropic:
+		p = &anthropic{cfg: cfg}
+	case ProviderGemini:
+		p = &gemini{cfg: cfg}
+	}
+	if cfg.Timeout > 0 {
+		p = timeoutProvider{p, cfg.Timeout}
+	}
+	return p, nil
+}
+
+type timeoutProvider struct {
+	Provider
+	timeout time.Duration
+}
+
+func (p timeoutProvider) Complete(ctx context.Context, request Request) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.Provider.Complete(ctx, request)
+}
+
+// postJSON sends body to url and decodes the JSON response into response. Error responses are
+// returned with their body, which is where the APIs explain what went wrong.
+func postJSON(ctx context.Context, url string, headers map[string]string, body, response any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, response)
+}
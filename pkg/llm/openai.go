@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// openAI talks to the chat completions API of OpenAI and of compatible servers such as Ollama,
+// llama.cpp or vLLM.
+type openAI struct {
+	cfg Config
+}
+
+func (p *openAI) Name() string {
+	return p.cfg.Provider + ":" + p.cfg.Model
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+func (p *openAI) Complete(ctx context.Context, request Request) (string, error) {
+	var messages []openAIMessage
+	if request.System != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: request.System})
+	}
+	messages = append(messages, openAIMessage{Role: "user", Content: request.Prompt})
+	body := map[string]any{
+		"model":       p.cfg.Model,
+		"messages":    messages,
+		"temperature": p.cfg.Temperature,
+	}
+	if request.MaxTokens > 0 {
+		body["max_tokens"] = request.MaxTokens
+	}
+	headers := map[string]string{}
+	if p.cfg.APIKey != "" {
+		headers["Authorization"] = "Bearer " + p.cfg.APIKey
+	}
+
+	var response struct {
+		Choices []struct {
+			Message openAIMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := postJSON(ctx, p.cfg.Endpoint+"/chat/completions", headers, body, &response); err != nil {
+		return "", fmt.Errorf("%s query failed: %w", p.cfg.Provider, err)
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("%s returned no choices", p.cfg.Provider)
+	}
+	return strings.TrimSpace(response.Choices[0].Message.Content), nil
+}
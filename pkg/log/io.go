@@ -3,23 +3,26 @@ package log
 import (
 	"io"
 
+	"github.com/CefBoud/kafka-mcp-server/pkg/redact"
 	log "github.com/sirupsen/logrus"
 )
 
 // IOLogger is a wrapper around io.Reader and io.Writer that can be used
 // to log the data being read and written from the underlying streams
 type IOLogger struct {
-	reader io.Reader
-	writer io.Writer
-	logger *log.Logger
+	reader   io.Reader
+	writer   io.Writer
+	logger   *log.Logger
+	redactor *redact.Redactor
 }
 
-// NewIOLogger creates a new IOLogger instance
-func NewIOLogger(r io.Reader, w io.Writer, logger *log.Logger) *IOLogger {
+// NewIOLogger creates a new IOLogger instance. The redaction patterns, if any, are applied to the logged data.
+func NewIOLogger(r io.Reader, w io.Writer, logger *log.Logger, redactor *redact.Redactor) *IOLogger {
 	return &IOLogger{
-		reader: r,
-		writer: w,
-		logger: logger,
+		reader:   r,
+		writer:   w,
+		logger:   logger,
+		redactor: redactor,
 	}
 }
 
@@ -30,7 +33,8 @@ func (l *IOLogger) Read(p []byte) (n int, err error) {
 	}
 	n, err = l.reader.Read(p)
 	if n > 0 {
-		l.logger.Infof("[stdin]: received %d bytes: %s", n, string(p[:n]))
+		data, _ := l.redactor.Text(string(p[:n]))
+		l.logger.Infof("[stdin]: received %d bytes: %s", n, data)
 	}
 	return n, err
 }
@@ -40,6 +44,7 @@ func (l *IOLogger) Write(p []byte) (n int, err error) {
 	if l.writer == nil {
 		return 0, io.ErrClosedPipe
 	}
-	l.logger.Infof("[stdout]: sending %d bytes: %s", len(p), string(p))
+	data, _ := l.redactor.Text(string(p))
+	l.logger.Infof("[stdout]: sending %d bytes: %s", len(p), data)
 	return l.writer.Write(p)
 }
@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+const namespace = "kafka_mcp"
+
+var (
+	ToolCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tool_calls_total",
+		Help:      "Number of tool calls.",
+	}, []string{"tool"})
+
+	ToolErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tool_errors_total",
+		Help:      "Number of tool calls that returned an error.",
+	}, []string{"tool"})
+
+	ToolDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tool_duration_seconds",
+		Help:      "Latency of tool calls.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 20, 30, 60},
+	}, []string{"tool"})
+
+	KafkaClientsOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_clients_open",
+		Help:      "Number of Kafka clients (client, admin, producer, consumer group) currently open.",
+	}, []string{"cluster", "type"})
+
+	KafkaClientsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_clients_created_total",
+		Help:      "Number of Kafka clients created.",
+	}, []string{"cluster", "type"})
+
+	Messages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_total",
+		Help:      "Number of messages consumed or produced through the tools.",
+	}, []string{"cluster", "direction"})
+
+	Bytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "message_bytes_total",
+		Help:      "Key and value bytes consumed or produced through the tools.",
+	}, []string{"cluster", "direction"})
+
+	LLMQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "multiplex_llm_queries_total",
+		Help:      "Number of LLM queries made to infer multiplex PROMPT_ARGUMENTs.",
+	}, []string{"model", "outcome"})
+
+	LLMDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "multiplex_llm_query_duration_seconds",
+		Help:      "Latency of multiplex LLM queries.",
+		Buckets:   prometheus.ExponentialBuckets(.1, 2, 10),
+	}, []string{"model"})
+)
+
+const (
+	Consumed = "consumed"
+	Produced = "produced"
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		ToolCalls, ToolErrors, ToolDuration,
+		KafkaClientsOpen, KafkaClientsCreated,
+		Messages, Bytes,
+		LLMQueries, LLMDuration,
+		saramaCollector{},
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveTool records a tool call.
+func ObserveTool(tool string, start time.Time, failed bool) {
+	ToolCalls.WithLabelValues(tool).Inc()
+	ToolDuration.WithLabelValues(tool).Observe(time.Since(start).Seconds())
+	if failed {
+		ToolErrors.WithLabelValues(tool).Inc()
+	}
+}
+
+// TrackKafkaClient counts a newly created Kafka client and returns the function to call when it is closed.
+func TrackKafkaClient(cluster, clientType string) (closed func()) {
+	KafkaClientsCreated.WithLabelValues(cluster, clientType).Inc()
+	KafkaClientsOpen.WithLabelValues(cluster, clientType).Inc()
+	return func() { KafkaClientsOpen.WithLabelValues(cluster, clientType).Dec() }
+}
+
+// ObserveMessage counts a consumed or produced message.
+func ObserveMessage(cluster, direction string, size int) {
+	Messages.WithLabelValues(cluster, direction).Inc()
+	Bytes.WithLabelValues(cluster, direction).Add(float64(size))
+}
+
+var (
+	saramaRegistriesMu sync.Mutex
+	saramaRegistries   = map[string]gometrics.Registry{}
+)
+
+// SaramaRegistry returns the go-metrics registry shared by the sarama clients of a cluster,
+// whose metrics are exported with a `kafka_mcp_sarama_` prefix.
+func SaramaRegistry(cluster string) gometrics.Registry {
+	saramaRegistriesMu.Lock()
+	defer saramaRegistriesMu.Unlock()
+	r, ok := saramaRegistries[cluster]
+	if !ok {
+		r = gometrics.NewRegistry()
+		saramaRegistries[cluster] = r
+	}
+	return r
+}
+
+// saramaCollector bridges the sarama go-metrics registries. It is an unchecked collector since the set of
+// metrics grows as sarama discovers brokers and topics.
+type saramaCollector struct{}
+
+func (saramaCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (saramaCollector) Collect(ch chan<- prometheus.Metric) {
+	saramaRegistriesMu.Lock()
+	defer saramaRegistriesMu.Unlock()
+	for cluster, r := range saramaRegistries {
+		r.Each(func(name string, m any) {
+			emit := func(suffix string, value float64) {
+				desc := prometheus.NewDesc(saramaMetricName(name)+suffix, "sarama metric "+name, nil, prometheus.Labels{"cluster": cluster})
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+			}
+			switch m := m.(type) {
+			case gometrics.Counter:
+				emit("", float64(m.Count()))
+			case gometrics.Gauge:
+				emit("", float64(m.Value()))
+			case gometrics.GaugeFloat64:
+				emit("", m.Value())
+			case gometrics.Meter:
+				s := m.Snapshot()
+				emit("_count", float64(s.Count()))
+				emit("_rate1m", s.Rate1())
+			case gometrics.Histogram:
+				s := m.Snapshot()
+				emit("_count", float64(s.Count()))
+				emit("_mean", s.Mean())
+				emit("_p99", s.Percentile(0.99))
+			}
+		})
+	}
+}
+
+func saramaMetricName(name string) string {
+	var b strings.Builder
+	b.WriteString(namespace + "_sarama_")
+	for _, r := range name {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
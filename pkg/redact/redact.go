@@ -0,0 +1,264 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Mask replaces redacted content.
+const Mask = "[REDACTED]"
+
+// builtinPatterns can be referenced by name in Rule.Patterns.
+var builtinPatterns = map[string]string{
+	"email":       `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"card-number": `\b(?:\d[ -]?){12,18}\d\b`,
+	"token":       `(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*|\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+|\b(?:sk|pk|ghp|gho|xox[abpr])[-_][A-Za-z0-9_-]{10,}`,
+}
+
+// builtinChecks validate the matches of built-in patterns that would otherwise redact too much:
+// card-number also matches timestamps in milliseconds and numeric IDs.
+var builtinChecks = map[string]func(string) bool{
+	"card-number": luhnValid,
+}
+
+// Rule describes what to redact from the messages of the topics matching Topics (glob patterns,
+// every topic when empty).
+type Rule struct {
+	Topics []string `mapstructure:"topics"`
+	// Fields are dot-separated JSON field paths, e.g. `customer.email`. A `*` segment matches
+	// any object key or array index.
+	Fields []string `mapstructure:"fields"`
+	// Patterns are regular expressions, or one of the built-in names: email, card-number, token.
+	Patterns []string `mapstructure:"patterns"`
+	// DropHeaders are glob patterns of header keys removed from messages, compared case-insensitively.
+	DropHeaders []string `mapstructure:"drop-headers"`
+}
+
+type compiledRule struct {
+	topics      []string
+	fields      [][]string
+	patterns    []pattern
+	dropHeaders []string
+}
+
+// pattern is a compiled pattern whose matches are only redacted when valid accepts them, if set.
+type pattern struct {
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+// Redactor applies redaction rules to consumed messages and command logs.
+// A nil Redactor redacts nothing.
+type Redactor struct {
+	rules []compiledRule
+}
+
+// New compiles the rules, returning nil when there are none.
+func New(rules []Rule) (*Redactor, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Redactor{}
+	for i, rule := range rules {
+		c := compiledRule{topics: rule.Topics}
+		for _, f := range rule.Fields {
+			c.fields = append(c.fields, strings.Split(f, "."))
+		}
+		for _, p := range rule.Patterns {
+			expr := p
+			if builtin, ok := builtinPatterns[p]; ok {
+				expr = builtin
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("redaction rule #%d: invalid pattern %q: %w", i, p, err)
+			}
+			c.patterns = append(c.patterns, pattern{re: re, valid: builtinChecks[p]})
+		}
+		for _, h := range rule.DropHeaders {
+			c.dropHeaders = append(c.dropHeaders, strings.ToLower(h))
+		}
+		r.rules = append(r.rules, c)
+	}
+	return r, nil
+}
+
+func matchesAny(globs []string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) rulesFor(topic string) []compiledRule {
+	if r == nil {
+		return nil
+	}
+	var rules []compiledRule
+	for _, rule := range r.rules {
+		if len(rule.topics) == 0 || matchesAny(rule.topics, topic) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Message redacts a message key or value consumed from topic. JSON documents have their fields masked
+// and patterns applied to their string values; other payloads only have patterns applied.
+// It returns the redacted payload and the number of redacted fields and pattern matches.
+func (r *Redactor) Message(topic string, payload []byte) (string, int) {
+	rules := r.rulesFor(topic)
+	if len(rules) == 0 {
+		return string(payload), 0
+	}
+
+	var doc any
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err == nil && !decoder.More() {
+		if _, isContainer := doc.(map[string]any); isContainer || isArray(doc) {
+			count := 0
+			for _, rule := range rules {
+				for _, field := range rule.fields {
+					count += maskField(doc, field)
+				}
+			}
+			var n int
+			doc, n = redactStrings(doc, rules)
+			count += n
+			if count == 0 {
+				return string(payload), 0
+			}
+			redacted, _ := json.Marshal(doc)
+			return string(redacted), count
+		}
+	}
+
+	return redactText(string(payload), rules)
+}
+
+// DropHeader reports whether the header key must be removed from messages of topic.
+func (r *Redactor) DropHeader(topic, key string) bool {
+	key = strings.ToLower(key)
+	for _, rule := range r.rulesFor(topic) {
+		if matchesAny(rule.dropHeaders, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Text applies the patterns of every rule to s, e.g. a command log line whose topic is unknown.
+func (r *Redactor) Text(s string) (string, int) {
+	if r == nil {
+		return s, 0
+	}
+	return redactText(s, r.rules)
+}
+
+func redactText(s string, rules []compiledRule) (string, int) {
+	count := 0
+	for _, rule := range rules {
+		for _, p := range rule.patterns {
+			s = p.re.ReplaceAllStringFunc(s, func(match string) string {
+				if p.valid != nil && !p.valid(match) {
+					return match
+				}
+				count++
+				return Mask
+			})
+		}
+	}
+	return s, count
+}
+
+// luhnValid reports whether the digits of s, ignoring separators, pass the Luhn checksum of card numbers.
+func luhnValid(s string) bool {
+	sum, digits := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if digits%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits > 0 && sum%10 == 0
+}
+
+func isArray(v any) bool {
+	_, ok := v.([]any)
+	return ok
+}
+
+// maskField replaces the values found at the path with Mask and returns how many were replaced.
+func maskField(doc any, fieldPath []string) int {
+	if len(fieldPath) == 0 {
+		return 0
+	}
+	key, rest := fieldPath[0], fieldPath[1:]
+	count := 0
+	switch v := doc.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if key != "*" && key != k {
+				continue
+			}
+			if len(rest) == 0 {
+				v[k] = Mask
+				count++
+			} else {
+				count += maskField(child, rest)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if key != "*" && key != strconv.Itoa(i) {
+				continue
+			}
+			if len(rest) == 0 {
+				v[i] = Mask
+				count++
+			} else {
+				count += maskField(child, rest)
+			}
+		}
+	}
+	return count
+}
+
+func redactStrings(doc any, rules []compiledRule) (any, int) {
+	count := 0
+	switch v := doc.(type) {
+	case map[string]any:
+		for k, child := range v {
+			var n int
+			v[k], n = redactStrings(child, rules)
+			count += n
+		}
+	case []any:
+		for i, child := range v {
+			var n int
+			v[i], n = redactStrings(child, rules)
+			count += n
+		}
+	case string:
+		if v == Mask {
+			return v, 0
+		}
+		return redactText(v, rules)
+	}
+	return doc, count
+}
@@ -0,0 +1,164 @@
+package redact
+
+import "testing"
+
+func TestMessage(t *testing.T) {
+	r, err := New([]Rule{
+		{Topics: []string{"orders.*"}, Fields: []string{"customer.email", "items.*.card"}},
+		{Patterns: []string{"email", "card-number", `SSN-\d{9}`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		topic     string
+		payload   string
+		want      string
+		wantCount int
+	}{
+		{
+			name:      "field",
+			topic:     "orders.eu",
+			payload:   `{"id":1,"customer":{"email":"x","name":"Ann"}}`,
+			want:      `{"customer":{"email":"[REDACTED]","name":"Ann"},"id":1}`,
+			wantCount: 1,
+		},
+		{
+			name:      "wildcard array field",
+			topic:     "orders.eu",
+			payload:   `{"items":[{"card":"a","sku":"s1"},{"card":"b"}]}`,
+			want:      `{"items":[{"card":"[REDACTED]","sku":"s1"},{"card":"[REDACTED]"}]}`,
+			wantCount: 2,
+		},
+		{
+			name:      "field of another topic",
+			topic:     "payments",
+			payload:   `{"customer":{"email":"x"}}`,
+			want:      `{"customer":{"email":"x"}}`,
+			wantCount: 0,
+		},
+		{
+			name:      "pattern in a JSON string",
+			topic:     "payments",
+			payload:   `{"note":"contact ann@example.com","amount":12.50}`,
+			want:      `{"amount":12.50,"note":"contact [REDACTED]"}`,
+			wantCount: 1,
+		},
+		{
+			name:      "masked field not counted twice",
+			topic:     "orders.eu",
+			payload:   `{"customer":{"email":"ann@example.com"}}`,
+			want:      `{"customer":{"email":"[REDACTED]"}}`,
+			wantCount: 1,
+		},
+		{
+			name:      "text",
+			topic:     "logs",
+			payload:   `paid with 4111 1111 1111 1111 by SSN-123456789`,
+			want:      `paid with [REDACTED] by [REDACTED]`,
+			wantCount: 2,
+		},
+		{
+			name:      "digits failing the Luhn check",
+			topic:     "logs",
+			payload:   `order 4111 1111 1111 1112 at 1714557600000`,
+			want:      `order 4111 1111 1111 1112 at 1714557600000`,
+			wantCount: 0,
+		},
+		{
+			name:      "card number in a JSON string",
+			topic:     "payments",
+			payload:   `{"card":"5500-0000-0000-0004","createdAt":"1714557600000"}`,
+			want:      `{"card":"[REDACTED]","createdAt":"1714557600000"}`,
+			wantCount: 1,
+		},
+		{
+			name:      "unchanged payload kept as is",
+			topic:     "payments",
+			payload:   `{ "id": 1 }`,
+			want:      `{ "id": 1 }`,
+			wantCount: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, count := r.Message(tt.topic, []byte(tt.payload))
+			if got != tt.want || count != tt.wantCount {
+				t.Errorf("Message() = %s, %d, want %s, %d", got, count, tt.want, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestText(t *testing.T) {
+	r, err := New([]Rule{{Topics: []string{"orders"}, Patterns: []string{"card-number"}}, {Patterns: []string{"token"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, count := r.Text("--value 4111111111111111 --id 1714557600000 -H 'Authorization: Bearer abc.def'")
+	if want := "--value [REDACTED] --id 1714557600000 -H 'Authorization: [REDACTED]'"; got != want || count != 2 {
+		t.Errorf("Text() = %s, %d, want %s, 2", got, count, want)
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   bool
+	}{
+		{"4111 1111 1111 1111", true},
+		{"5500-0000-0000-0004", true},
+		{"378282246310005", true},
+		{"4111 1111 1111 1112", false},
+		{"1714557600000", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := luhnValid(tt.digits); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.digits, got, tt.want)
+		}
+	}
+}
+
+func TestDropHeader(t *testing.T) {
+	r, err := New([]Rule{{Topics: []string{"orders"}, DropHeaders: []string{"Authorization", "x-secret-*"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		topic, key string
+		want       bool
+	}{
+		{"orders", "authorization", true},
+		{"orders", "AUTHORIZATION", true},
+		{"orders", "x-secret-key", true},
+		{"orders", "x-trace", false},
+		{"payments", "authorization", false},
+	}
+	for _, tt := range tests {
+		if got := r.DropHeader(tt.topic, tt.key); got != tt.want {
+			t.Errorf("DropHeader(%q, %q) = %v, want %v", tt.topic, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	r, err := New(nil)
+	if err != nil || r != nil {
+		t.Fatalf("New(nil) = %v, %v, want nil", r, err)
+	}
+	if got, count := r.Message("orders", []byte(`{"email":"ann@example.com"}`)); got != `{"email":"ann@example.com"}` || count != 0 {
+		t.Errorf("Message() = %s, %d", got, count)
+	}
+	if r.DropHeader("orders", "authorization") {
+		t.Error("DropHeader() = true")
+	}
+}
+
+func TestNewInvalidPattern(t *testing.T) {
+	if _, err := New([]Rule{{Patterns: []string{"("}}}); err == nil {
+		t.Error("New() with an invalid pattern succeeded")
+	}
+}